@@ -0,0 +1,101 @@
+// Copyright 2019 CanonicalLtd
+
+package main
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+	"gopkg.in/macaroon-bakery.v1/httpbakery"
+
+	"github.com/cloud-green/sisyphus/config"
+	"github.com/cloud-green/sisyphus/simulation"
+	"github.com/cloud-green/sisyphus/simulation/call"
+)
+
+// newCallBackend returns the call backend specified in the
+// simulation configuration and a function that releases
+// resources held by the backend.
+func newCallBackend(backend config.CallBackend, opts *options) (simulation.CallBackend, func() error, error) {
+	nopClose := func() error { return nil }
+	switch backend {
+	case config.NOPCallBackend:
+		return call.NewNOPCallBackend(), nopClose, nil
+	case config.HTTPCallBackend:
+		return call.NewSessionHTTPCallBackend(newHTTPClient), nopClose, nil
+	case config.KafkaCallBackend:
+		version, err := opts.KafkaVersion()
+		if err != nil {
+			return nil, nil, errors.Annotate(err, "failed to parse kafka version")
+		}
+		config := sarama.NewConfig()
+		config.ClientID = opts.KafkaClientID()
+		config.Producer.Return.Successes = true
+		config.Producer.Partitioner = sarama.NewHashPartitioner
+		config.Version = version
+
+		TLSConfig, err := opts.KafkaTLS()
+		if err != nil {
+			return nil, nil, errors.Annotate(err, "failed to parse kafka TLS config")
+		}
+		if TLSConfig != nil {
+			cfg, err := TLSConfig.Config()
+			if err != nil {
+				return nil, nil, errors.Annotate(err, "failed to parse kafka tls config")
+			}
+			config.Net.TLS.Config = cfg
+			config.Net.TLS.Enable = true
+		}
+
+		if err := config.Validate(); err != nil {
+			return nil, nil, errors.Annotate(err, "failed to validate kafka configuration")
+		}
+
+		client, err := sarama.NewClient(opts.KafkaBrokerURLs(), config)
+		if err != nil {
+			return nil, nil, errors.Annotate(err, "failed to create kafka client")
+		}
+
+		producer, err := sarama.NewSyncProducerFromClient(client)
+		if err != nil {
+			client.Close()
+			return nil, nil, errors.Annotate(err, "failed to create a new kafka producer")
+		}
+
+		closeProducer := func() error {
+			// closing the producer flushes any buffered messages.
+			if err := producer.Close(); err != nil {
+				client.Close()
+				return errors.Annotate(err, "failed to close kafka producer")
+			}
+			return errors.Trace(client.Close())
+		}
+		return call.NewKafkaCallBackend(producer), closeProducer, nil
+	default:
+		return nil, nil, errors.Errorf("unknown call backend %q", backend)
+	}
+}
+
+// newHTTPClient returns a new http client with its own cookie jar. The
+// client of an isolated session uses its own connections instead of
+// the default transport.
+func newHTTPClient(isolated bool) call.HTTPClient {
+	client := httpbakery.NewClient()
+	if isolated {
+		client.Client.Transport = &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+		}
+	}
+	return client
+}
@@ -6,51 +6,115 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
+	"flag"
+	"io/ioutil"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/Shopify/sarama"
 	"github.com/juju/errors"
 	"go.uber.org/zap/zapcore"
+
+	"github.com/cloud-green/sisyphus/config"
+	"github.com/cloud-green/sisyphus/simulation"
 )
 
+// options holds the runtime settings of sisyphus. Each setting may be
+// specified by a command line flag, which takes precedence over the
+// corresponding environment variable.
+type options struct {
+	configFiles     stringsFlag
+	logLevel        string
+	kafkaBrokers    string
+	kafkaVersion    string
+	kafkaClientID   string
+	kafkaClientCert string
+	kafkaClientKey  string
+	kafkaCACert     string
+	virtualClock    bool
+	metricsAddr     string
+	reportJSON      string
+	reportCSV       string
+	// overrides holds configuration values set on the command line,
+	// which override values set in configuration files.
+	overrides config.Config
+}
+
+// registerConfigFlags registers the flags selecting the simulation
+// configuration files.
+func (o *options) registerConfigFlags(flags *flag.FlagSet) {
+	o.configFiles = stringsFlag{values: splitList(os.Getenv("CONFIG"))}
+	flags.Var(&o.configFiles, "config", "simulation configuration `file`; may be repeated or comma separated, later files override earlier ones (env CONFIG)")
+}
+
+// registerSimulationFlags registers flags that control the execution
+// of the simulation.
+func (o *options) registerSimulationFlags(flags *flag.FlagSet) {
+	o.registerConfigFlags(flags)
+	flags.StringVar(&o.logLevel, "log-level", os.Getenv("LOGLEVEL"), "logging `level` (env LOGLEVEL)")
+	flags.DurationVar(&o.overrides.Limits.Duration, "duration", 0, "maximum `duration` of the simulation (overrides limits.duration)")
+	flags.IntVar(&o.overrides.Limits.MaxTransitions, "max-transitions", 0, "maximum `number` of state transitions (overrides limits.max-transitions)")
+	flags.IntVar(&o.overrides.Limits.MaxCalls, "max-calls", 0, "maximum `number` of calls (overrides limits.max-calls)")
+	flags.DurationVar(&o.overrides.Limits.GracePeriod, "grace-period", 0, "`duration` in-flight calls are given to complete once the simulation is stopped (overrides limits.grace-period)")
+	flags.Int64Var(&o.overrides.Seed, "seed", 0, "`seed` of the simulation's source of randomness (overrides seed)")
+	flags.BoolVar(&o.virtualClock, "virtual-clock", false, "run the simulation on a virtual clock that skips ahead to the next timer instead of waiting")
+	flags.StringVar(&o.metricsAddr, "metrics-addr", os.Getenv("METRICS_ADDR"), "`address` to serve prometheus metrics on, e.g. :8080 (env METRICS_ADDR)")
+	flags.StringVar(&o.reportJSON, "report-json", "", "write the simulation report in JSON format to `file` (- for standard output)")
+	flags.StringVar(&o.reportCSV, "report-csv", "", "write the simulation report in CSV format to `file` (- for standard output)")
+}
+
+// registerFlags registers all runtime setting flags.
+func (o *options) registerFlags(flags *flag.FlagSet) {
+	o.registerSimulationFlags(flags)
+	flags.StringVar(&o.kafkaBrokers, "kafka-brokers", os.Getenv("KAFKA_BROKERS"), "comma separated list of kafka broker `addresses` (env KAFKA_BROKERS)")
+	flags.StringVar(&o.kafkaVersion, "kafka-version", os.Getenv("KAFKA_VERSION"), "kafka `version` (env KAFKA_VERSION)")
+	flags.StringVar(&o.kafkaClientID, "kafka-client-id", os.Getenv("KAFKA_CLIENT_ID"), "kafka client `id` (env KAFKA_CLIENT_ID)")
+	flags.StringVar(&o.kafkaClientCert, "kafka-client-cert", "", "`file` containing the PEM encoded kafka client certificate (env KAFKA_CLIENT_CERT holds the certificate itself)")
+	flags.StringVar(&o.kafkaClientKey, "kafka-client-key", "", "`file` containing the PEM encoded kafka client key (env KAFKA_CLIENT_KEY holds the key itself)")
+	flags.StringVar(&o.kafkaCACert, "kafka-ca-cert", "", "`file` containing the PEM encoded kafka CA certificate (env KAFKA_CA_CERT holds the certificate itself)")
+}
+
+// Clock returns the clock the simulation should run on.
+func (o *options) Clock() simulation.Clock {
+	if o.virtualClock {
+		return simulation.NewVirtualClock(time.Now())
+	}
+	return simulation.RealClock
+}
+
 // LogLevel returns the level of logging to perform. If the
-// environment variable is not set, the level will be the default
-// INFO level.
-func LogLevel() zapcore.Level {
+// level is not set, the level will be the default INFO level.
+func (o *options) LogLevel() (zapcore.Level, error) {
 	var level zapcore.Level
-	level.UnmarshalText([]byte(os.Getenv("LOGLEVEL")))
-	return level
+	if err := level.UnmarshalText([]byte(o.logLevel)); err != nil {
+		return level, errors.Annotatef(err, "invalid log level %q", o.logLevel)
+	}
+	return level, nil
 }
 
-// Config returns the CONFIG environment variable.
-func Config() string {
-	return os.Getenv("CONFIG")
+// Config returns the names of the configuration files.
+func (o *options) Config() []string {
+	return o.configFiles.values
 }
 
-func KafkaClientID() string {
-	id := os.Getenv("KAFKA_CLIENT_ID")
-	if id == "" {
+func (o *options) KafkaClientID() string {
+	if o.kafkaClientID == "" {
 		return "sisyphus_simulation"
 	}
-	return id
+	return o.kafkaClientID
 }
 
-func KafkaVersion() (sarama.KafkaVersion, error) {
-	version := os.Getenv("KAFKA_VERSION")
-	if version == "" {
+func (o *options) KafkaVersion() (sarama.KafkaVersion, error) {
+	if o.kafkaVersion == "" {
 		return sarama.V2_0_0_0, nil
 	}
-	return sarama.ParseKafkaVersion(version)
+	return sarama.ParseKafkaVersion(o.kafkaVersion)
 }
 
-// KafkaBrokerURLs returns the KAFKA_BROKERS environment variable
-func KafkaBrokerURLs() []string {
-	brokers := os.Getenv("KAFKA_BROKERS")
-	if brokers == "" {
-		return nil
-	}
-	return strings.Split(brokers, ",")
+// KafkaBrokerURLs returns the list of kafka brokers.
+func (o *options) KafkaBrokerURLs() []string {
+	return splitList(o.kafkaBrokers)
 }
 
 // TLSConfig contains values a client needs to connect to a server via tls.
@@ -78,12 +142,23 @@ func (c *TLSConfig) Config() (*tls.Config, error) {
 	return nil, errors.New("certificate not specified")
 }
 
-// KafkaTLS fetches KAFKA_CLIENT_CERT, KAFKA_CLIENT_KEY and KAFKA_CA_CERT
-// environment variables and returns a tls config structure.
-func KafkaTLS() (*TLSConfig, error) {
-	clientCertString := os.Getenv("KAFKA_CLIENT_CERT")
-	clientKeyString := os.Getenv("KAFKA_CLIENT_KEY")
-	caCertString := os.Getenv("KAFKA_CA_CERT")
+// KafkaTLS loads the kafka client certificate, key and CA certificate
+// from the files named by flags or, if the flags are not set, from
+// KAFKA_CLIENT_CERT, KAFKA_CLIENT_KEY and KAFKA_CA_CERT environment
+// variables and returns a tls config structure.
+func (o *options) KafkaTLS() (*TLSConfig, error) {
+	clientCertString, err := fileOrEnv(o.kafkaClientCert, "KAFKA_CLIENT_CERT")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	clientKeyString, err := fileOrEnv(o.kafkaClientKey, "KAFKA_CLIENT_KEY")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	caCertString, err := fileOrEnv(o.kafkaCACert, "KAFKA_CA_CERT")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
 
 	if clientCertString == "" && clientKeyString == "" {
 		return nil, nil
@@ -109,3 +184,50 @@ func KafkaTLS() (*TLSConfig, error) {
 	}
 	return &config, nil
 }
+
+// fileOrEnv returns the content of the named file or, if no
+// file is named, the value of the environment variable.
+func fileOrEnv(filename, env string) (string, error) {
+	if filename == "" {
+		return os.Getenv(env), nil
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return string(data), nil
+}
+
+// stringsFlag is a flag.Value holding a list of strings. It may be
+// set multiple times and each value may contain a comma separated list.
+// Values set on the command line replace the default value.
+type stringsFlag struct {
+	values []string
+	set    bool
+}
+
+// String implements the flag.Value interface.
+func (f *stringsFlag) String() string {
+	return strings.Join(f.values, ",")
+}
+
+// Set implements the flag.Value interface.
+func (f *stringsFlag) Set(value string) error {
+	if !f.set {
+		f.values = nil
+		f.set = true
+	}
+	f.values = append(f.values, splitList(value)...)
+	return nil
+}
+
+// splitList splits a comma separated list ignoring empty elements.
+func splitList(s string) []string {
+	var values []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
@@ -0,0 +1,81 @@
+// Copyright 2019 CanonicalLtd
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/juju/zaputil"
+	"github.com/juju/zaputil/zapctx"
+
+	"github.com/cloud-green/sisyphus/config"
+	"github.com/cloud-green/sisyphus/simulation/call"
+)
+
+// dryRun implements the dry-run subcommand, which runs the simulation
+// printing the calls that would be made instead of performing them.
+func dryRun(args []string, stdout, stderr io.Writer) int {
+	var opts options
+	flags := newFlagSet("dry-run", "[flags] [config file...]", "Run the simulation printing calls instead of performing them.", stderr)
+	opts.registerSimulationFlags(flags)
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if flags.NArg() > 0 {
+		opts.configFiles.values = flags.Args()
+	}
+	if err := setupLogging(&opts); err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+	ctx := context.Background()
+
+	simConfig, err := loadConfig(opts.Config(), opts.overrides)
+	if err != nil {
+		zapctx.Error(ctx, "failed to load configuration", zaputil.Error(err))
+		return exitConfig
+	}
+	return simulate(ctx, simConfig, &dryRunCallBackend{w: stdout}, &opts, stdout)
+}
+
+// dryRunCallBackend is a call backend that prints calls instead of
+// performing them.
+type dryRunCallBackend struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// Name implements the simulation.NamedCallBackend interface.
+func (c *dryRunCallBackend) Name() string {
+	return "dry-run"
+}
+
+// Do implements the simulation.CallBackend interface.
+func (c *dryRunCallBackend) Do(ctx context.Context, callConfig config.Call, attributes call.Attributes) (call.Attributes, error) {
+	pairs := make([]string, 0, 2*len(attributes))
+	for k, v := range attributes {
+		pairs = append(pairs, "{"+k+"}", fmt.Sprintf("%v", v))
+	}
+	replacer := strings.NewReplacer(pairs...)
+
+	line := []string{callConfig.Method, replacer.Replace(callConfig.URL)}
+	for _, p := range callConfig.Parameters {
+		line = append(line, fmt.Sprintf("%s:%s=%v", p.Type, p.Key, attributes[p.Attribute]))
+	}
+	if b := callConfig.Body; b != nil {
+		bodyType := b.Type
+		if bodyType == "" {
+			bodyType = config.JSONBody
+		}
+		line = append(line, "body:"+string(bodyType))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintln(c.w, strings.TrimSpace(strings.Join(line, " ")))
+	return attributes, nil
+}
@@ -0,0 +1,143 @@
+// Copyright 2019 CanonicalLtd
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/juju/errors"
+
+	"github.com/cloud-green/sisyphus/config"
+)
+
+// graph implements the graph subcommand, which prints entities, states
+// and transitions of the simulation in graphviz dot format.
+func graph(args []string, stdout, stderr io.Writer) int {
+	var opts options
+	flags := newFlagSet("graph", "[flags] [config file...]", "Print the state graph of the simulation in graphviz dot format.", stderr)
+	opts.registerConfigFlags(flags)
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if flags.NArg() > 0 {
+		opts.configFiles.values = flags.Args()
+	}
+	files, err := readConfigFiles(opts.Config())
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitConfig
+	}
+	if err := writeGraph(stdout, files.merged()); err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitRuntime
+	}
+	return exitOK
+}
+
+// writeGraph writes the dot representation of the simulation. Entities
+// are drawn as boxes linked to their initial states, the states of their
+// on-expire transitions and their subordinates, transitions are labeled
+// with their probability, guard and calls, on-failure and on-timeout
+// transitions are drawn dashed and fallback transitions dotted.
+func writeGraph(w io.Writer, c config.Config) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "digraph sisyphus {\n")
+
+	entityNames := make([]string, 0, len(c.Entities))
+	for name := range c.Entities {
+		entityNames = append(entityNames, name)
+	}
+	sort.Strings(entityNames)
+	for _, name := range entityNames {
+		entity := c.Entities[name]
+		node := quote("entity:" + name)
+		fmt.Fprintf(bw, "\t%s [shape=box, label=%s];\n", node, quote(name))
+		if entity.InitialState != "" {
+			fmt.Fprintf(bw, "\t%s -> %s [style=dotted];\n", node, quote(entity.InitialState))
+		}
+		if t := entity.OnExpire; t != nil {
+			label := "on-expire" + callsLabel(*t)
+			fmt.Fprintf(bw, "\t%s -> %s [style=dotted, label=%s];\n", node, quote(t.State), quote(label))
+		}
+		for _, es := range entity.Subordinates {
+			label := es.Cardinality
+			if len(es.Stages) > 0 {
+				label = "profile"
+			}
+			fmt.Fprintf(bw, "\t%s -> %s [style=bold, label=%s];\n", node, quote("entity:"+es.Entity), quote(label))
+		}
+	}
+
+	stateNames := make([]string, 0, len(c.States))
+	for name := range c.States {
+		stateNames = append(stateNames, name)
+	}
+	sort.Strings(stateNames)
+	for _, name := range stateNames {
+		fmt.Fprintf(bw, "\t%s;\n", quote(name))
+		state := c.States[name]
+		for _, t := range state.Transitions {
+			label := string(t.Probability)
+			if label == "" {
+				label = "0"
+			}
+			if t.When != "" {
+				label += " when " + t.When
+			}
+			label += callsLabel(t)
+			fmt.Fprintf(bw, "\t%s -> %s [label=%s];\n", quote(name), quote(t.State), quote(label))
+			writeFailureEdges(bw, name, t)
+		}
+		if t := state.Fallback; t != nil {
+			label := "fallback" + callsLabel(*t)
+			fmt.Fprintf(bw, "\t%s -> %s [style=dotted, label=%s];\n", quote(name), quote(t.State), quote(label))
+			writeFailureEdges(bw, name, *t)
+		}
+	}
+	fmt.Fprintf(bw, "}\n")
+	return errors.Trace(bw.Flush())
+}
+
+// writeFailureEdges writes the on-failure and on-timeout edges of the
+// transition from the state.
+func writeFailureEdges(w io.Writer, state string, t config.Transition) {
+	if t.OnFailure != "" {
+		fmt.Fprintf(w, "\t%s -> %s [style=dashed, label=\"on-failure\"];\n", quote(state), quote(t.OnFailure))
+	}
+	if t.OnTimeout != "" {
+		fmt.Fprintf(w, "\t%s -> %s [style=dashed, label=\"on-timeout\"];\n", quote(state), quote(t.OnTimeout))
+	}
+}
+
+// callsLabel returns the label of the calls of the transition, one
+// call per line, with parallel calls separated by " & ".
+func callsLabel(t config.Transition) string {
+	var label string
+	for _, c := range t.CallSequence() {
+		if l := callLabel(c); l != "" {
+			label += "\n" + l
+		}
+	}
+	return label
+}
+
+func callLabel(c config.Call) string {
+	if len(c.Parallel) == 0 {
+		return strings.TrimSpace(c.Method + " " + c.URL)
+	}
+	labels := make([]string, 0, len(c.Parallel))
+	for _, pc := range c.Parallel {
+		if l := callLabel(pc); l != "" {
+			labels = append(labels, l)
+		}
+	}
+	return strings.Join(labels, " & ")
+}
+
+func quote(s string) string {
+	return fmt.Sprintf("%q", s)
+}
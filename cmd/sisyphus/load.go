@@ -0,0 +1,113 @@
+// Copyright 2019 CanonicalLtd
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/juju/errors"
+	yaml "gopkg.in/yaml.v1"
+
+	"github.com/cloud-green/sisyphus/config"
+)
+
+var (
+	rootEntityPath = regexp.MustCompile(`^root-entities\[(\d+)\]`)
+)
+
+// configFile holds a single parsed configuration file.
+type configFile struct {
+	name      string
+	config    config.Config
+	positions config.Positions
+}
+
+// readConfigFile reads and parses the named configuration file.
+func readConfigFile(filename string) (*configFile, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	f := &configFile{
+		name:      filename,
+		positions: config.ParsePositions(data),
+	}
+	if err := yaml.Unmarshal(data, &f.config); err != nil {
+		return nil, errors.Annotatef(err, "failed to unmarshal %s", filename)
+	}
+	// files referred to by the configuration are relative to the
+	// configuration file
+	f.config.ResolvePaths(filepath.Dir(filename))
+	return f, nil
+}
+
+// configFiles holds configuration files in the order in which
+// they are merged.
+type configFiles []*configFile
+
+// readConfigFiles reads and parses the named configuration files.
+func readConfigFiles(filenames []string) (configFiles, error) {
+	if len(filenames) == 0 {
+		return nil, errors.New("configuration file not specified")
+	}
+	var files configFiles
+	for _, filename := range filenames {
+		f, err := readConfigFile(filename)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// merged returns the configuration obtained by merging all files.
+func (fs configFiles) merged() config.Config {
+	var c config.Config
+	for _, f := range fs {
+		c.Merge(f.config)
+	}
+	return c
+}
+
+// locate returns the file and line at which the value with the
+// specified path of the merged configuration is defined.
+func (fs configFiles) locate(path string) (string, int) {
+	if len(fs) == 0 {
+		return "", 0
+	}
+	// root entities are appended, so we translate the index
+	// in the merged configuration into an index in the file.
+	if match := rootEntityPath.FindStringSubmatch(path); match != nil {
+		index, _ := strconv.Atoi(match[1])
+		for _, f := range fs {
+			if index < len(f.config.RootEntities) {
+				return f.name, f.positions.Line(fmt.Sprintf("root-entities[%d]", index) + path[len(match[0]):])
+			}
+			index -= len(f.config.RootEntities)
+		}
+	}
+	// other values are defined by the last file defining
+	// the closest ancestor of the path.
+	for p := path; p != ""; p = parentPath(p) {
+		for i := len(fs) - 1; i >= 0; i-- {
+			if line, ok := fs[i].positions[p]; ok {
+				return fs[i].name, line
+			}
+		}
+	}
+	return fs[len(fs)-1].name, 0
+}
+
+func parentPath(path string) string {
+	for i := len(path) - 1; i >= 0; i-- {
+		if path[i] == '.' || path[i] == '[' {
+			return path[:i]
+		}
+	}
+	return ""
+}
@@ -4,96 +4,322 @@ package main
 
 import (
 	"context"
-	"io/ioutil"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
-	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
 	"github.com/juju/zaputil"
 	"github.com/juju/zaputil/zapctx"
 	"go.uber.org/zap"
-	"gopkg.in/macaroon-bakery.v1/httpbakery"
-	yaml "gopkg.in/yaml.v1"
 
 	"github.com/cloud-green/sisyphus/config"
+	"github.com/cloud-green/sisyphus/metrics"
+	"github.com/cloud-green/sisyphus/report"
 	"github.com/cloud-green/sisyphus/simulation"
-	"github.com/cloud-green/sisyphus/simulation/call"
 )
 
+// Exit codes returned by sisyphus.
+const (
+	exitOK = 0
+	// exitRuntime means the simulation failed while running.
+	exitRuntime = 1
+	// exitUsage means the command line could not be parsed.
+	exitUsage = 2
+	// exitConfig means the simulation configuration could not be
+	// loaded or is not valid.
+	exitConfig = 3
+	// exitBackend means the call backend could not be set up.
+	exitBackend = 4
+	// exitThresholds means one or more thresholds were breached.
+	exitThresholds = 5
+	// exitInterrupted means the simulation was stopped by a signal.
+	exitInterrupted = 130
+)
+
+// version holds the version of sisyphus and is set at build time
+// using -ldflags "-X main.version=<version>".
+var version = "dev"
+
+type command struct {
+	name    string
+	summary string
+	// run runs the command with the specified arguments, writing
+	// its output to stdout and problems to stderr, and returns the
+	// exit code.
+	run func(args []string, stdout, stderr io.Writer) int
+}
+
+var commands []command
+
+func init() {
+	commands = []command{{
+		name:    "run",
+		summary: "run the simulation (default)",
+		run:     runCommand,
+	}, {
+		name:    "validate",
+		summary: "check the simulation configuration",
+		run:     validate,
+	}, {
+		name:    "graph",
+		summary: "print the state graph in graphviz dot format",
+		run:     graph,
+	}, {
+		name:    "dry-run",
+		summary: "run the simulation printing calls instead of performing them",
+		run:     dryRun,
+	}, {
+		name:    "version",
+		summary: "print the version",
+		run:     printVersion,
+	}, {
+		name:    "help",
+		summary: "print this help",
+		run:     help,
+	}}
+}
+
+// notifySignals relays the signals that stop the simulation to c.
+var notifySignals = func(c chan<- os.Signal) {
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+}
+
 func main() {
-	zapctx.LogLevel.SetLevel(LogLevel())
+	os.Exit(runMain(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// runMain runs the command specified by the arguments and returns the
+// exit code.
+func runMain(args []string, stdout, stderr io.Writer) int {
+	// to remain compatible with earlier versions, the simulation is
+	// run if no command is specified.
+	if len(args) == 0 || (strings.HasPrefix(args[0], "-") && args[0] != "-h" && args[0] != "-help" && args[0] != "--help") {
+		return runCommand(args, stdout, stderr)
+	}
+	for _, c := range commands {
+		if c.name == args[0] {
+			return c.run(args[1:], stdout, stderr)
+		}
+	}
+	if !strings.HasPrefix(args[0], "-") {
+		fmt.Fprintf(stderr, "unknown command %q\n", args[0])
+	}
+	usage(stderr)
+	return exitUsage
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "usage: sisyphus <command> [flags]\n\ncommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(w, "\nrun sisyphus <command> -h for help on a command.\n")
+}
+
+func help(args []string, stdout, stderr io.Writer) int {
+	usage(stderr)
+	return exitOK
+}
+
+func printVersion(args []string, stdout, stderr io.Writer) int {
+	fmt.Fprintf(stdout, "sisyphus %s\n", version)
+	return exitOK
+}
+
+// newFlagSet returns a new flag set for the named command, which
+// writes usage and errors to stderr.
+func newFlagSet(name, arguments, summary string, stderr io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: sisyphus %s %s\n\n%s\n\nflags:\n", name, arguments, summary)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parseFlags parses command line arguments. It returns false and the
+// exit code if the command should not proceed.
+func parseFlags(flags *flag.FlagSet, args []string) (int, bool) {
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return exitOK, false
+		}
+		return exitUsage, false
+	}
+	return exitOK, true
+}
+
+// setupLogging sets the log level specified in options.
+func setupLogging(opts *options) error {
+	level, err := opts.LogLevel()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	zapctx.LogLevel.SetLevel(level)
+	return nil
+}
+
+// loadConfig reads, merges and validates the configuration files.
+// Values set on the command line override values set in the
+// configuration files.
+func loadConfig(filenames []string, overrides config.Config) (config.Config, error) {
+	files, err := readConfigFiles(filenames)
+	if err != nil {
+		return config.Config{}, errors.Trace(err)
+	}
+	simConfig := files.merged()
+	simConfig.Merge(overrides)
+	if err := simConfig.Validate(); err != nil {
+		return config.Config{}, errors.Trace(err)
+	}
+	return simConfig, nil
+}
+
+func runCommand(args []string, stdout, stderr io.Writer) int {
+	var opts options
+	flags := newFlagSet("run", "[flags] [config file...]", "Run the simulation.", stderr)
+	opts.registerFlags(flags)
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if flags.NArg() > 0 {
+		opts.configFiles.values = flags.Args()
+	}
+	if err := setupLogging(&opts); err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
 	ctx := context.Background()
 
-	data, err := ioutil.ReadFile(Config())
+	simConfig, err := loadConfig(opts.Config(), opts.overrides)
 	if err != nil {
-		zapctx.Error(ctx, "failed to read the configuration file", zaputil.Error(err))
-		return
+		zapctx.Error(ctx, "failed to load configuration", zaputil.Error(err))
+		return exitConfig
 	}
 
-	var simConfig config.Config
-	err = yaml.Unmarshal(data, &simConfig)
+	callBackend, closeBackend, err := newCallBackend(simConfig.Backend, &opts)
 	if err != nil {
-		zapctx.Error(ctx, "failed to unmarshal configuration file", zaputil.Error(err))
-		return
-	}
-
-	var callBackend simulation.CallBackend
-	switch simConfig.Backend {
-	case "nop":
-		callBackend = call.NewNOPCallBackend()
-	case "http":
-		callBackend = call.NewHTTPCallBackend(httpbakery.NewClient())
-	case "kafka":
-		version, err := KafkaVersion()
-		if err != nil {
-			zapctx.Error(ctx, "failed to parse kafka version", zaputil.Error(err))
-			return
-		}
-		config := sarama.NewConfig()
-		config.ClientID = KafkaClientID()
-		config.Producer.Return.Successes = true
-		config.Producer.Partitioner = sarama.NewHashPartitioner
-		config.Version = version
+		zapctx.Error(ctx, "failed to set up call backend", zaputil.Error(err))
+		return exitBackend
+	}
 
-		TLSConfig, err := KafkaTLS()
-		if err != nil {
-			zapctx.Error(ctx, "failed to parse kafka TLS config", zaputil.Error(err))
-			return
-		}
-		if TLSConfig != nil {
-			cfg, err := TLSConfig.Config()
-			if err != nil {
-				zapctx.Error(ctx, "failed to parse kafka tls config", zaputil.Error(err))
-				return
-			}
-			config.Net.TLS.Config = cfg
-			config.Net.TLS.Enable = true
+	code := simulate(ctx, simConfig, callBackend, &opts, stdout)
+	if err := closeBackend(); err != nil {
+		zapctx.Error(ctx, "failed to close call backend", zaputil.Error(err))
+		if code == exitOK {
+			code = exitRuntime
 		}
+	}
+	return code
+}
 
-		if err := config.Validate(); err != nil {
-			zapctx.Error(ctx, "failed to validate kafka configuration", zaputil.Error(err))
-			return
-		}
+// simulate runs the simulation until it completes, reaches one of its
+// limits or is interrupted by a signal, writes the summary and report
+// to stdout and returns the exit code. The first SIGINT or SIGTERM
+// stops the simulation gracefully, the second one terminates the
+// process immediately.
+func simulate(ctx context.Context, simConfig config.Config, callBackend simulation.CallBackend, opts *options, stdout io.Writer) int {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-		client, err := sarama.NewClient(KafkaBrokerURLs(), config)
-		if err != nil {
-			zapctx.Error(ctx, "failed to create kafka client", zaputil.Error(err))
+	interrupted := make(chan struct{})
+	finished := make(chan struct{})
+	defer close(finished)
+	signals := make(chan os.Signal, 1)
+	notifySignals(signals)
+	defer signal.Stop(signals)
+	go func() {
+		select {
+		case sig := <-signals:
+			zapctx.Info(ctx, "received signal, stopping simulation", zap.Stringer("signal", sig))
+			close(interrupted)
+			cancel()
+		case <-finished:
 			return
 		}
+		select {
+		case <-signals:
+			zapctx.Error(ctx, "received second signal, exiting")
+			os.Exit(exitInterrupted)
+		case <-finished:
+		}
+	}()
 
-		producer, err := sarama.NewSyncProducerFromClient(client)
+	sim, err := simulation.New(simConfig, callBackend)
+	if err != nil {
+		zapctx.Error(ctx, "failed to create simulation", zaputil.Error(err))
+		return exitConfig
+	}
+	sim.Clock = opts.Clock()
+	recorder := report.NewRecorder()
+	sim.Observer = recorder
+	if opts.metricsAddr != "" {
+		m := metrics.New()
+		closeServer, err := serveMetrics(ctx, opts.metricsAddr, m)
 		if err != nil {
-			zapctx.Error(ctx, "failed to create a new kafka producer", zaputil.Error(err))
-			return
+			zapctx.Error(ctx, "failed to serve metrics", zaputil.Error(err))
+			return exitRuntime
 		}
+		defer closeServer()
+		sim.Observer = simulation.Observers(recorder, m)
+	}
+	zapctx.Info(ctx, "starting simulation", zap.Int64("seed", sim.Seed))
+	if err := sim.Start(ctx); err != nil {
+		zapctx.Error(ctx, "failed to start simulation", zaputil.Error(err))
+		return exitRuntime
+	}
+	if len(simConfig.Thresholds) > 0 {
+		watchCtx, stopWatching := context.WithCancel(ctx)
+		defer stopWatching()
+		go watchThresholds(watchCtx, recorder, simConfig.Thresholds, sim.Stop)
+	}
+	simErr := sim.Wait()
+	stats := sim.Stats()
+	printSummary(stdout, sim.Seed, stats)
+	rep := recorder.Report(sim.Seed, stats.Duration)
+	rep.Thresholds, err = recorder.Check(simConfig.Thresholds)
+	if err != nil {
+		zapctx.Error(ctx, "failed to check thresholds", zaputil.Error(err))
+		return exitRuntime
+	}
+	if err := writeReports(rep, opts, stdout); err != nil {
+		zapctx.Error(ctx, "failed to write report", zaputil.Error(err))
+		return exitRuntime
+	}
+	if simErr != nil {
+		zapctx.Error(ctx, "failed to execute simulation", zaputil.Error(simErr))
+		return exitRuntime
+	}
+	if !rep.Passed() {
+		zapctx.Error(ctx, "thresholds breached")
+		return exitThresholds
+	}
 
-		callBackend = call.NewKafkaCallBackend(producer)
+	select {
+	case <-interrupted:
+		return exitInterrupted
 	default:
-		zapctx.Error(ctx, "unknown call backend", zap.String("backend", string(simConfig.Backend)))
-		return
+		return exitOK
 	}
+}
 
-	_, err = simulation.New(simConfig, callBackend)
-	if err != nil {
-		zapctx.Error(ctx, "failed to execute simulation", zaputil.Error(err))
-		return
-	}
+// printSummary prints simulation counters and the seed, which can be
+// used to replay the simulation.
+func printSummary(w io.Writer, seed int64, stats simulation.Stats) {
+	fmt.Fprintf(w, "simulation (seed %d) finished in %v: %d entities, %d transitions, %d calls (%d failed, %d retries)\n",
+		seed,
+		stats.Duration.Round(time.Millisecond),
+		stats.Entities,
+		stats.Transitions,
+		stats.Calls,
+		stats.FailedCalls,
+		stats.Retries,
+	)
 }
@@ -0,0 +1,379 @@
+// Copyright 2019 CanonicalLtd
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/cloud-green/sisyphus/config"
+)
+
+// simConfig runs a single user transitioning every second for 10.5s,
+// so that the simulation performs 10 transitions.
+var simConfig = `
+backend: nop
+seed: 1
+limits:
+  duration: 10500ms
+root-entities:
+- entity: user
+entities:
+  user:
+    initial_state: home
+state:
+  home:
+    timer:
+      type: fixed
+      interval: 1s
+    transitions:
+    - state: home
+      probability: 1
+      call:
+        method: GET
+        url: http://test.com/home
+`
+
+// otherConfig overrides the seed of simConfig.
+var otherConfig = `
+seed: 2
+`
+
+var invalidConfig = `
+root-entities:
+- entity: admin
+`
+
+var kafkaConfig = `
+backend: kafka
+`
+
+var thresholdsConfig = `
+thresholds:
+- metric: transitions
+  state: home
+  min-count: 1000
+`
+
+// envVars holds the environment variables read by sisyphus.
+var envVars = []string{
+	"CONFIG",
+	"LOGLEVEL",
+	"METRICS_ADDR",
+	"KAFKA_BROKERS",
+	"KAFKA_VERSION",
+	"KAFKA_CLIENT_ID",
+	"KAFKA_CLIENT_CERT",
+	"KAFKA_CLIENT_KEY",
+	"KAFKA_CA_CERT",
+}
+
+// setEnv sets the environment variables read by sisyphus to the
+// specified values, unsetting the others, until c.Done is called.
+func setEnv(c *qt.C, env map[string]string) {
+	for _, key := range envVars {
+		key := key
+		old, ok := os.LookupEnv(key)
+		c.Defer(func() {
+			if ok {
+				os.Setenv(key, old)
+			} else {
+				os.Unsetenv(key)
+			}
+		})
+		if value, ok := env[key]; ok {
+			os.Setenv(key, value)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
+
+// writeConfigFiles writes the configuration files used by the tests
+// to a new directory and returns its name.
+func writeConfigFiles(c *qt.C) string {
+	dir, err := ioutil.TempDir("", "sisyphus")
+	c.Assert(err, qt.IsNil)
+	for name, data := range map[string]string{
+		"sim.yaml":        simConfig,
+		"other.yaml":      otherConfig,
+		"invalid.yaml":    invalidConfig,
+		"kafka.yaml":      kafkaConfig,
+		"thresholds.yaml": thresholdsConfig,
+	} {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644)
+		c.Assert(err, qt.IsNil)
+	}
+	return dir
+}
+
+// commandTest describes a run of sisyphus. Occurrences of $DIR in
+// arguments and environment variables are replaced by the directory
+// holding the configuration files.
+type commandTest struct {
+	about          string
+	args           []string
+	env            map[string]string
+	expectedCode   int
+	expectedStdout string
+	expectedStderr string
+}
+
+func runCommandTests(c *qt.C, tests []commandTest) {
+	dir := writeConfigFiles(c)
+	defer os.RemoveAll(dir)
+	replacer := strings.NewReplacer("$DIR", dir)
+	for i, test := range tests {
+		c.Logf("running test %d: %s", i, test.about)
+		env := make(map[string]string)
+		for k, v := range test.env {
+			env[k] = replacer.Replace(v)
+		}
+		args := make([]string, len(test.args))
+		for i, arg := range test.args {
+			args[i] = replacer.Replace(arg)
+		}
+		func() {
+			defer c.Done()
+			setEnv(c, env)
+			var stdout, stderr bytes.Buffer
+			code := runMain(args, &stdout, &stderr)
+			c.Assert(code, qt.Equals, test.expectedCode, qt.Commentf("stdout: %s\nstderr: %s", stdout.String(), stderr.String()))
+			if test.expectedStdout != "" {
+				c.Assert(stdout.String(), qt.Matches, test.expectedStdout)
+			}
+			if test.expectedStderr != "" {
+				c.Assert(stderr.String(), qt.Matches, test.expectedStderr)
+			}
+		}()
+	}
+}
+
+func TestExitCodes(t *testing.T) {
+	c := qt.New(t)
+
+	runCommandTests(c, []commandTest{{
+		about:          "version",
+		args:           []string{"version"},
+		expectedCode:   exitOK,
+		expectedStdout: "sisyphus dev\n",
+	}, {
+		about:          "help",
+		args:           []string{"help"},
+		expectedCode:   exitOK,
+		expectedStderr: "usage: sisyphus <command> \\[flags\\](.|\n)*",
+	}, {
+		about:          "command help",
+		args:           []string{"run", "-h"},
+		expectedCode:   exitOK,
+		expectedStderr: "usage: sisyphus run \\[flags\\] \\[config file...\\](.|\n)*",
+	}, {
+		about:          "run",
+		args:           []string{"run", "-virtual-clock", "$DIR/sim.yaml"},
+		expectedCode:   exitOK,
+		expectedStdout: `simulation \(seed 1\) finished in .*: 1 entities, 10 transitions, 10 calls \(0 failed, 0 retries\)\n(.|\n)*`,
+	}, {
+		about:          "run without command",
+		args:           []string{"-virtual-clock", "$DIR/sim.yaml"},
+		expectedCode:   exitOK,
+		expectedStdout: `simulation \(seed 1\) finished(.|\n)*`,
+	}, {
+		about:          "dry run",
+		args:           []string{"dry-run", "-virtual-clock", "$DIR/sim.yaml"},
+		expectedCode:   exitOK,
+		expectedStdout: `GET http://test.com/home\n(.|\n)*`,
+	}, {
+		about:          "graph",
+		args:           []string{"graph", "$DIR/sim.yaml"},
+		expectedCode:   exitOK,
+		expectedStdout: `digraph sisyphus {\n(.|\n)*`,
+	}, {
+		about:        "report not written",
+		args:         []string{"run", "-virtual-clock", "-report-json", "$DIR/missing/report.json", "$DIR/sim.yaml"},
+		expectedCode: exitRuntime,
+	}, {
+		about:          "unknown command",
+		args:           []string{"frobnicate"},
+		expectedCode:   exitUsage,
+		expectedStderr: `unknown command "frobnicate"\n(.|\n)*`,
+	}, {
+		about:          "unknown flag",
+		args:           []string{"run", "-frobnicate"},
+		expectedCode:   exitUsage,
+		expectedStderr: `flag provided but not defined: -frobnicate\n(.|\n)*`,
+	}, {
+		about:          "invalid log level",
+		args:           []string{"run", "-log-level", "loud", "$DIR/sim.yaml"},
+		expectedCode:   exitUsage,
+		expectedStderr: `invalid log level "loud".*\n`,
+	}, {
+		about:          "unknown validation format",
+		args:           []string{"validate", "-format", "xml", "$DIR/sim.yaml"},
+		expectedCode:   exitUsage,
+		expectedStderr: `unknown output format "xml"\n`,
+	}, {
+		about:        "configuration not specified",
+		args:         []string{"run"},
+		expectedCode: exitConfig,
+	}, {
+		about:        "missing configuration",
+		args:         []string{"run", "$DIR/missing.yaml"},
+		expectedCode: exitConfig,
+	}, {
+		about:        "invalid configuration",
+		args:         []string{"run", "$DIR/sim.yaml", "$DIR/invalid.yaml"},
+		expectedCode: exitConfig,
+	}, {
+		about:          "validate invalid configuration",
+		args:           []string{"validate", "$DIR/sim.yaml", "$DIR/invalid.yaml"},
+		expectedCode:   exitConfig,
+		expectedStdout: `.*invalid.yaml:3: error: root-entities\[1\].entity: unknown entity "admin"\n`,
+	}, {
+		about:        "validate valid configuration",
+		args:         []string{"validate", "$DIR/sim.yaml"},
+		expectedCode: exitOK,
+	}, {
+		about:        "graph of missing configuration",
+		args:         []string{"graph", "$DIR/missing.yaml"},
+		expectedCode: exitConfig,
+	}, {
+		about:        "call backend not set up",
+		args:         []string{"run", "-kafka-version", "bogus", "$DIR/sim.yaml", "$DIR/kafka.yaml"},
+		expectedCode: exitBackend,
+	}, {
+		about:          "thresholds breached",
+		args:           []string{"run", "-virtual-clock", "$DIR/sim.yaml", "$DIR/thresholds.yaml"},
+		expectedCode:   exitThresholds,
+		expectedStdout: `(.|\n)*transitions to home >= 1000\s+10\s+FAIL\n`,
+	}})
+}
+
+func TestInterrupted(t *testing.T) {
+	c := qt.New(t)
+	dir := writeConfigFiles(c)
+	defer os.RemoveAll(dir)
+	defer c.Done()
+	setEnv(c, nil)
+
+	// the simulation is interrupted once it runs.
+	c.Defer(func(notify func(chan<- os.Signal)) func() {
+		return func() {
+			notifySignals = notify
+		}
+	}(notifySignals))
+	notifySignals = func(signals chan<- os.Signal) {
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			signals <- os.Interrupt
+		}()
+	}
+	var stdout, stderr bytes.Buffer
+	code := runMain([]string{"run", filepath.Join(dir, "sim.yaml")}, &stdout, &stderr)
+	c.Assert(code, qt.Equals, exitInterrupted)
+	c.Assert(stdout.String(), qt.Matches, `simulation \(seed 1\) finished(.|\n)*`)
+}
+
+func TestPrecedence(t *testing.T) {
+	c := qt.New(t)
+
+	runCommandTests(c, []commandTest{{
+		about:          "configuration from the environment",
+		args:           []string{"run", "-virtual-clock"},
+		env:            map[string]string{"CONFIG": "$DIR/sim.yaml"},
+		expectedCode:   exitOK,
+		expectedStdout: `simulation \(seed 1\)(.|\n)*`,
+	}, {
+		about:          "configuration files merged in order",
+		args:           []string{"run", "-virtual-clock"},
+		env:            map[string]string{"CONFIG": "$DIR/sim.yaml,$DIR/other.yaml"},
+		expectedCode:   exitOK,
+		expectedStdout: `simulation \(seed 2\)(.|\n)*`,
+	}, {
+		about:          "configuration flag overrides the environment",
+		args:           []string{"run", "-virtual-clock", "-config", "$DIR/sim.yaml"},
+		env:            map[string]string{"CONFIG": "$DIR/missing.yaml"},
+		expectedCode:   exitOK,
+		expectedStdout: `simulation \(seed 1\)(.|\n)*`,
+	}, {
+		about:          "repeated configuration flags",
+		args:           []string{"run", "-virtual-clock", "-config", "$DIR/sim.yaml", "-config", "$DIR/other.yaml"},
+		expectedCode:   exitOK,
+		expectedStdout: `simulation \(seed 2\)(.|\n)*`,
+	}, {
+		about:          "arguments override the configuration flag",
+		args:           []string{"run", "-virtual-clock", "-config", "$DIR/missing.yaml", "$DIR/sim.yaml"},
+		expectedCode:   exitOK,
+		expectedStdout: `simulation \(seed 1\)(.|\n)*`,
+	}, {
+		about:          "seed flag overrides configuration",
+		args:           []string{"run", "-virtual-clock", "-seed", "42", "$DIR/sim.yaml", "$DIR/other.yaml"},
+		expectedCode:   exitOK,
+		expectedStdout: `simulation \(seed 42\)(.|\n)*`,
+	}, {
+		about:          "duration flag overrides configuration",
+		args:           []string{"run", "-virtual-clock", "-duration", "2500ms", "$DIR/sim.yaml"},
+		expectedCode:   exitOK,
+		expectedStdout: `simulation \(seed 1\) finished in .*: 1 entities, 2 transitions(.|\n)*`,
+	}, {
+		about:        "log level flag overrides the environment",
+		args:         []string{"run", "-virtual-clock", "-log-level", "error", "$DIR/sim.yaml"},
+		env:          map[string]string{"LOGLEVEL": "loud"},
+		expectedCode: exitOK,
+	}, {
+		about:          "log level from the environment",
+		args:           []string{"run", "-virtual-clock", "$DIR/sim.yaml"},
+		env:            map[string]string{"LOGLEVEL": "loud"},
+		expectedCode:   exitUsage,
+		expectedStderr: `invalid log level "loud".*\n`,
+	}, {
+		about:        "kafka version flag overrides the environment",
+		args:         []string{"run", "-kafka-version", "bogus", "$DIR/sim.yaml", "$DIR/kafka.yaml"},
+		env:          map[string]string{"KAFKA_VERSION": "2.0.0"},
+		expectedCode: exitBackend,
+	}})
+}
+
+func TestValidateJSON(t *testing.T) {
+	c := qt.New(t)
+	dir := writeConfigFiles(c)
+	defer os.RemoveAll(dir)
+	defer c.Done()
+	setEnv(c, nil)
+
+	var stdout, stderr bytes.Buffer
+	code := runMain([]string{
+		"validate",
+		"-format", "json",
+		filepath.Join(dir, "sim.yaml"),
+		filepath.Join(dir, "invalid.yaml"),
+	}, &stdout, &stderr)
+	c.Assert(code, qt.Equals, exitConfig)
+	var diagnostics []diagnostic
+	err := json.Unmarshal(stdout.Bytes(), &diagnostics)
+	c.Assert(err, qt.IsNil)
+	c.Assert(diagnostics, qt.DeepEquals, []diagnostic{{
+		File:     filepath.Join(dir, "invalid.yaml"),
+		Line:     3,
+		Severity: config.SeverityError,
+		Path:     "root-entities[1].entity",
+		Message:  `unknown entity "admin"`,
+	}})
+
+	// valid configurations have no diagnostics.
+	stdout.Reset()
+	code = runMain([]string{
+		"validate",
+		"-format", "json",
+		filepath.Join(dir, "sim.yaml"),
+	}, &stdout, &stderr)
+	c.Assert(code, qt.Equals, exitOK)
+	c.Assert(stdout.String(), qt.Equals, "[]\n")
+}
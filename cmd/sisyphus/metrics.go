@@ -0,0 +1,47 @@
+// Copyright 2019 CanonicalLtd
+
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/juju/errors"
+	"github.com/juju/zaputil"
+	"github.com/juju/zaputil/zapctx"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+
+	"github.com/cloud-green/sisyphus/metrics"
+)
+
+// serveMetrics serves the simulation metrics, together with the Go
+// runtime and process metrics, at /metrics on the specified address.
+// It returns a function that stops the server.
+func serveMetrics(ctx context.Context, addr string, m *metrics.Metrics) (func(), error) {
+	registry := prometheus.NewRegistry()
+	if err := m.Register(registry); err != nil {
+		return nil, errors.Trace(err)
+	}
+	registry.MustRegister(prometheus.NewGoCollector())
+	registry.MustRegister(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot listen on %q", addr)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	server := &http.Server{Handler: mux}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			zapctx.Error(ctx, "metrics server failed", zaputil.Error(err))
+		}
+	}()
+	zapctx.Info(ctx, "serving metrics", zap.String("address", listener.Addr().String()))
+	return func() {
+		server.Close()
+	}, nil
+}
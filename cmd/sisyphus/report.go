@@ -0,0 +1,48 @@
+// Copyright 2019 CanonicalLtd
+
+package main
+
+import (
+	"io"
+	"os"
+
+	"github.com/juju/errors"
+
+	"github.com/cloud-green/sisyphus/report"
+)
+
+// writeReports prints the report to stdout and writes it to the JSON
+// and CSV files specified in options.
+func writeReports(rep *report.Report, opts *options, stdout io.Writer) error {
+	if err := rep.WriteText(stdout); err != nil {
+		return errors.Trace(err)
+	}
+	if opts.reportJSON != "" {
+		if err := writeReport(opts.reportJSON, stdout, rep.WriteJSON); err != nil {
+			return errors.Annotate(err, "failed to write JSON report")
+		}
+	}
+	if opts.reportCSV != "" {
+		if err := writeReport(opts.reportCSV, stdout, rep.WriteCSV); err != nil {
+			return errors.Annotate(err, "failed to write CSV report")
+		}
+	}
+	return nil
+}
+
+// writeReport writes the report to the named file or to stdout if the
+// name is "-".
+func writeReport(filename string, stdout io.Writer, write func(io.Writer) error) error {
+	if filename == "-" {
+		return errors.Trace(write(stdout))
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return errors.Trace(err)
+	}
+	return errors.Trace(f.Close())
+}
@@ -0,0 +1,54 @@
+// Copyright 2019 CanonicalLtd
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/juju/zaputil"
+	"github.com/juju/zaputil/zapctx"
+	"go.uber.org/zap"
+
+	"github.com/cloud-green/sisyphus/config"
+	"github.com/cloud-green/sisyphus/report"
+)
+
+// thresholdCheckInterval holds the interval at which thresholds are
+// checked while the simulation runs.
+const thresholdCheckInterval = time.Second
+
+// watchThresholds checks the thresholds at regular intervals until
+// the context is done, logging newly breached thresholds. If a
+// breached threshold specifies abort, stop is called.
+func watchThresholds(ctx context.Context, recorder *report.Recorder, thresholds []config.Threshold, stop func()) {
+	ticker := time.NewTicker(thresholdCheckInterval)
+	defer ticker.Stop()
+	breached := make(map[int]bool)
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		results, err := recorder.Check(thresholds)
+		if err != nil {
+			zapctx.Error(ctx, "failed to check thresholds", zaputil.Error(err))
+			return
+		}
+		for i, result := range results {
+			if result.Passed || breached[i] {
+				continue
+			}
+			breached[i] = true
+			zapctx.Warn(ctx, "threshold breached",
+				zap.String("threshold", result.Threshold),
+				zap.String("value", result.Value),
+			)
+			if result.Abort {
+				zapctx.Error(ctx, "stopping simulation", zap.String("threshold", result.Threshold))
+				stop()
+			}
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2019 CanonicalLtd
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+
+	"github.com/juju/errors"
+
+	"github.com/cloud-green/sisyphus/config"
+)
+
+var (
+	yamlErrorLine = regexp.MustCompile(`line (\d+)`)
+)
+
+// diagnostic describes a single problem found in a configuration file.
+type diagnostic struct {
+	File     string          `json:"file"`
+	Line     int             `json:"line,omitempty"`
+	Severity config.Severity `json:"severity"`
+	Path     string          `json:"path,omitempty"`
+	Message  string          `json:"message"`
+}
+
+// String implements the fmt.Stringer interface.
+func (d diagnostic) String() string {
+	location := d.File
+	if d.Line > 0 {
+		location = fmt.Sprintf("%s:%d", d.File, d.Line)
+	}
+	if d.Path == "" {
+		return fmt.Sprintf("%s: %s: %s", location, d.Severity, d.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s: %s", location, d.Severity, d.Path, d.Message)
+}
+
+// validate implements the validate subcommand, which checks the
+// configuration files and prints all diagnostics.
+func validate(args []string, stdout, stderr io.Writer) int {
+	var opts options
+	flags := newFlagSet("validate", "[flags] [config file...]", "Check the simulation configuration and report problems.", stderr)
+	opts.registerConfigFlags(flags)
+	format := flags.String("format", "text", "output `format`, text or json")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(stderr, "unknown output format %q\n", *format)
+		return exitUsage
+	}
+	filenames := opts.Config()
+	if flags.NArg() > 0 {
+		filenames = flags.Args()
+	}
+	if len(filenames) == 0 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	diagnostics := validateFiles(filenames)
+	if err := writeDiagnostics(stdout, *format, diagnostics); err != nil {
+		fmt.Fprintf(stderr, "failed to write diagnostics: %v\n", err)
+		return exitRuntime
+	}
+	for _, d := range diagnostics {
+		if d.Severity == config.SeverityError {
+			return exitConfig
+		}
+	}
+	return exitOK
+}
+
+// validateFiles reads, merges and analyzes the configuration files
+// returning all problems found.
+func validateFiles(filenames []string) []diagnostic {
+	var files configFiles
+	var diagnostics []diagnostic
+	for _, filename := range filenames {
+		f, err := readConfigFile(filename)
+		if err != nil {
+			d := diagnostic{
+				File:     filename,
+				Severity: config.SeverityError,
+				Message:  errors.Cause(err).Error(),
+			}
+			if match := yamlErrorLine.FindStringSubmatch(d.Message); match != nil {
+				d.Line, _ = strconv.Atoi(match[1])
+			}
+			diagnostics = append(diagnostics, d)
+			continue
+		}
+		files = append(files, f)
+	}
+	if len(diagnostics) > 0 {
+		return diagnostics
+	}
+
+	for _, p := range files.merged().Analyze() {
+		filename, line := files.locate(p.Path)
+		diagnostics = append(diagnostics, diagnostic{
+			File:     filename,
+			Line:     line,
+			Severity: p.Severity,
+			Path:     p.Path,
+			Message:  p.Message,
+		})
+	}
+	return diagnostics
+}
+
+func writeDiagnostics(w io.Writer, format string, diagnostics []diagnostic) error {
+	if format == "json" {
+		if diagnostics == nil {
+			diagnostics = []diagnostic{}
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(diagnostics)
+	}
+	for _, d := range diagnostics {
+		if _, err := fmt.Fprintln(w, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,387 @@
+// Copyright 2019 CanonicalLtd
+
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cloud-green/sisyphus/expr"
+)
+
+var (
+	placeholderPattern = regexp.MustCompile("\\{(.*?)\\}")
+
+	// builtinAttributes holds the names of attributes that are
+	// set by the simulation itself.
+	builtinAttributes = []string{"error"}
+)
+
+// Analyze performs static validation of the configuration,
+// followed by semantic analysis that looks for unreachable states,
+// unused entities and references to attributes that are never
+// defined. It returns all problems found, both errors and warnings.
+func (c Config) Analyze() []Problem {
+	v := &validator{config: c}
+	v.validate()
+	v.analyze()
+	return v.problems
+}
+
+func (v *validator) analyze() {
+	v.checkReachability()
+	v.checkAttributeReferences()
+	v.checkThresholds()
+	v.checkRateLimits()
+	v.checkProfiles()
+	v.checkExpiry()
+}
+
+// checkExpiry reports on-expire transitions of entities that never
+// expire.
+func (v *validator) checkExpiry() {
+	for _, name := range sortedKeys(v.config.Entities) {
+		e := v.config.Entities[name]
+		if e.OnExpire != nil && e.Lifetime.Type == "" && e.MaxTransitions == 0 {
+			v.warnf("entities."+name+".on-expire", "entity never expires: neither lifetime nor max-transitions specified")
+		}
+	}
+}
+
+// checkProfiles reports entity sets that specify a cardinality or a
+// timer, which are ignored in favour of the load profile.
+func (v *validator) checkProfiles() {
+	check := func(path string, es EntitySet) {
+		if len(es.Stages) == 0 {
+			return
+		}
+		if es.Cardinality != "" {
+			v.warnf(path+".cardinality", "cardinality is ignored when stages are specified")
+		}
+		if es.Timer.Type != "" {
+			v.warnf(path+".timer", "timer is ignored when stages are specified")
+		}
+	}
+	for i, es := range v.config.RootEntities {
+		check(fmt.Sprintf("root-entities[%d]", i), es)
+	}
+	for _, name := range sortedKeys(v.config.Entities) {
+		for i, es := range v.config.Entities[name].Subordinates {
+			check(fmt.Sprintf("entities.%s.subordinates[%d]", name, i), es)
+		}
+	}
+}
+
+// checkThresholds reports thresholds that select no calls and
+// thresholds that can never abort the simulation.
+func (v *validator) checkThresholds() {
+	for i, t := range v.config.Thresholds {
+		path := fmt.Sprintf("thresholds[%d]", i)
+		switch t.Metric {
+		case LatencyThresholdMetric, ErrorRateThresholdMetric:
+			if !v.selectsCalls(t.Method, t.URL) {
+				v.warnf(path, "threshold does not match any call")
+			}
+		case TransitionsThresholdMetric:
+			if t.Abort {
+				// the number of transitions only grows, so the
+				// threshold can only be breached at the end.
+				v.warnf(path+".abort", "abort has no effect on transitions thresholds")
+			}
+		}
+	}
+}
+
+// checkRateLimits reports rate limits that select no calls.
+func (v *validator) checkRateLimits() {
+	for i, l := range v.config.RateLimits {
+		if !v.selectsCalls(l.Method, l.URL) {
+			v.warnf(fmt.Sprintf("rate-limits[%d]", i), "rate limit does not match any call")
+		}
+	}
+}
+
+// selectsCalls reports whether any transition call is selected by
+// the method and the url regular expression.
+func (v *validator) selectsCalls(method, urlPattern string) bool {
+	url, err := regexp.Compile(urlPattern)
+	if err != nil {
+		// reported by validate
+		return true
+	}
+	for _, c := range v.calls() {
+		if c.Method == "" && c.URL == "" && len(c.Parameters) == 0 {
+			// no call is performed
+			continue
+		}
+		if (method == "" || method == c.Method) && url.MatchString(c.URL) {
+			return true
+		}
+	}
+	return false
+}
+
+// pathCall holds a call and its path in the configuration.
+type pathCall struct {
+	Call
+	path string
+}
+
+// calls returns all calls of all transitions, including parallel
+// calls, in a stable order.
+func (v *validator) calls() []pathCall {
+	var calls []pathCall
+	var add func(path string, c Call)
+	add = func(path string, c Call) {
+		calls = append(calls, pathCall{
+			Call: c,
+			path: path,
+		})
+		for i, pc := range c.Parallel {
+			add(fmt.Sprintf("%s.parallel[%d]", path, i), pc)
+		}
+	}
+	for _, t := range v.transitions() {
+		add(t.path+".call", t.Call)
+		for i, c := range t.Calls {
+			add(fmt.Sprintf("%s.calls[%d]", t.path, i), c)
+		}
+	}
+	return calls
+}
+
+// pathTransition holds a transition and its path in the
+// configuration.
+type pathTransition struct {
+	Transition
+	path string
+}
+
+// transitions returns all transitions and fallback transitions of
+// states and on-expire transitions of entities, in a stable order.
+func (v *validator) transitions() []pathTransition {
+	var transitions []pathTransition
+	for _, name := range sortedKeys(v.config.States) {
+		state := v.config.States[name]
+		for i, t := range state.Transitions {
+			transitions = append(transitions, pathTransition{
+				Transition: t,
+				path:       fmt.Sprintf("state.%s.transitions[%d]", name, i),
+			})
+		}
+		if state.Fallback != nil {
+			transitions = append(transitions, pathTransition{
+				Transition: *state.Fallback,
+				path:       "state." + name + ".fallback",
+			})
+		}
+	}
+	for _, name := range sortedKeys(v.config.Entities) {
+		if t := v.config.Entities[name].OnExpire; t != nil {
+			transitions = append(transitions, pathTransition{
+				Transition: *t,
+				path:       "entities." + name + ".on-expire",
+			})
+		}
+	}
+	return transitions
+}
+
+// checkReachability reports entities that are never created and
+// states that no entity can ever reach.
+func (v *validator) checkReachability() {
+	entities := make(map[string]bool)
+	states := make(map[string]bool)
+
+	var visitState func(name string)
+	visitState = func(name string) {
+		state, ok := v.config.States[name]
+		if !ok || states[name] {
+			return
+		}
+		states[name] = true
+		transitions := state.Transitions
+		if state.Fallback != nil {
+			transitions = append(transitions[:len(transitions):len(transitions)], *state.Fallback)
+		}
+		for _, t := range transitions {
+			visitState(t.State)
+			if t.OnFailure != "" {
+				visitState(t.OnFailure)
+			}
+			if t.OnTimeout != "" {
+				visitState(t.OnTimeout)
+			}
+		}
+	}
+	var visitEntity func(name string)
+	visitEntity = func(name string) {
+		entity, ok := v.config.Entities[name]
+		if !ok || entities[name] {
+			return
+		}
+		entities[name] = true
+		if entity.InitialState != "" {
+			visitState(entity.InitialState)
+		}
+		if t := entity.OnExpire; t != nil {
+			visitState(t.State)
+			if t.OnFailure != "" {
+				visitState(t.OnFailure)
+			}
+			if t.OnTimeout != "" {
+				visitState(t.OnTimeout)
+			}
+		}
+		for _, es := range entity.Subordinates {
+			visitEntity(es.Entity)
+		}
+	}
+	for _, es := range v.config.RootEntities {
+		visitEntity(es.Entity)
+	}
+
+	for _, name := range sortedKeys(v.config.Entities) {
+		if !entities[name] {
+			v.warnf("entities."+name, "entity is never created")
+		}
+	}
+	for _, name := range sortedKeys(v.config.States) {
+		if !states[name] {
+			v.warnf("state."+name, "state is unreachable")
+		}
+	}
+}
+
+// checkAttributeReferences reports attributes referenced in URL
+// placeholders, call parameters, cardinalities or expressions that are
+// not defined by constants, entity or state attributes or call results.
+func (v *validator) checkAttributeReferences() {
+	defined := make(map[string]bool)
+	for _, name := range builtinAttributes {
+		defined[name] = true
+	}
+	for name := range v.config.Constants {
+		defined[name] = true
+	}
+	for _, entity := range v.config.Entities {
+		for name := range entity.Attributes {
+			defined[name] = true
+		}
+	}
+	for _, state := range v.config.States {
+		for name := range state.Attributes {
+			defined[name] = true
+		}
+	}
+	transitions := v.transitions()
+	calls := v.calls()
+	for _, c := range calls {
+		for _, r := range c.Results {
+			defined[r.Attribute] = true
+		}
+	}
+
+	check := func(path, name string) {
+		if !defined[name] {
+			v.addf(path, "undefined attribute %q", name)
+		}
+	}
+
+	for _, name := range sortedKeys(v.config.Entities) {
+		for i, es := range v.config.Entities[name].Subordinates {
+			if _, err := strconv.Atoi(es.Cardinality); es.Cardinality != "" && err != nil {
+				check(fmt.Sprintf("entities.%s.subordinates[%d].cardinality", name, i), es.Cardinality)
+			}
+		}
+	}
+	checkExpr := func(path, s string) {
+		if e, err := expr.Parse(s); s != "" && err == nil {
+			for _, name := range e.Attributes() {
+				check(path, name)
+			}
+		}
+	}
+
+	checkAttributes := func(path string, attributes map[string]Attribute) {
+		for _, name := range sortedKeys(attributes) {
+			if a := attributes[name]; a.Type == ExprAttributeType {
+				checkExpr(fmt.Sprintf("%s.attributes.%s.expr", path, name), a.Expr)
+			}
+		}
+	}
+	for _, name := range sortedKeys(v.config.Entities) {
+		checkAttributes("entities."+name, v.config.Entities[name].Attributes)
+	}
+	for _, name := range sortedKeys(v.config.States) {
+		checkAttributes("state."+name, v.config.States[name].Attributes)
+	}
+	for _, t := range transitions {
+		checkExpr(t.path+".when", t.When)
+		if _, ok := t.Probability.Value(); !ok {
+			checkExpr(t.path+".probability", string(t.Probability))
+		}
+	}
+	for _, c := range calls {
+		for _, match := range placeholderPattern.FindAllString(c.URL, -1) {
+			check(c.path+".url", strings.Trim(match, "{}"))
+		}
+		for j, p := range c.Parameters {
+			check(fmt.Sprintf("%s.params[%d].attribute", c.path, j), p.Attribute)
+		}
+		if c.Body != nil {
+			if c.Body.Type != TextBody {
+				// text templates may hold braces that are not
+				// placeholders.
+				for _, name := range templatePlaceholders(c.Body.Template) {
+					check(c.path+".body.template", name)
+				}
+			}
+			for j, f := range c.Body.Files {
+				for _, match := range placeholderPattern.FindAllString(f.Name, -1) {
+					check(fmt.Sprintf("%s.body.files[%d].name", c.path, j), strings.Trim(match, "{}"))
+				}
+			}
+		}
+		for j, r := range c.Results {
+			checkExpr(fmt.Sprintf("%s.results[%d].expr", c.path, j), r.Expr)
+		}
+	}
+	if v.config.Backend == KafkaCallBackend {
+		for _, name := range []string{"message-topic", "message-key"} {
+			check("constants", name)
+		}
+	}
+}
+
+// templatePlaceholders returns the attributes named by placeholders in
+// the strings of the body template.
+func templatePlaceholders(template interface{}) []string {
+	var names []string
+	switch t := template.(type) {
+	case string:
+		for _, match := range placeholderPattern.FindAllString(t, -1) {
+			names = append(names, strings.Trim(match, "{}"))
+		}
+	case []interface{}:
+		for _, e := range t {
+			names = append(names, templatePlaceholders(e)...)
+		}
+	case map[interface{}]interface{}:
+		keys := make([]string, 0, len(t))
+		values := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			key := fmt.Sprint(k)
+			keys = append(keys, key)
+			values[key] = e
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			names = append(names, templatePlaceholders(values[k])...)
+		}
+	}
+	return names
+}
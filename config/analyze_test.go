@@ -0,0 +1,168 @@
+// Copyright 2019 CanonicalLtd
+
+package config_test
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	yaml "gopkg.in/yaml.v1"
+
+	"github.com/cloud-green/sisyphus/config"
+)
+
+func TestAnalyze(t *testing.T) {
+	c := qt.New(t)
+
+	data := `
+backend: kafka
+constants:
+  service-url: test.com
+  message-topic: events
+root-entities:
+- entity: user
+  cardinality: "10"
+  stages:
+  - duration: 1m
+    target: 10
+entities:
+  user:
+    initial_state: login
+    attributes:
+      username:
+        type: random_string
+      greeting:
+        type: expr
+        expr: "\"hello \" + {username} + {title}"
+    subordinates:
+    - entity: device
+      cardinality: number-of-devices
+  device:
+    on-expire:
+      state: login
+  admin:
+state:
+  login:
+    transitions:
+    - state: home
+      probability: 1
+      on-failure: login
+      call:
+        method: GET
+        url: http://{service-url}/{tenant}/login
+        params:
+        - type: form
+          attribute: username
+          key: username
+        - type: header
+          attribute: token
+          key: Authorization
+        results:
+        - key: message
+          attribute: message
+        - attribute: summary
+          expr: "{message} + {locale}"
+  home:
+    transitions:
+    - state: login
+      probability: 1
+      when: "{cart-size} > 0"
+    - state: home
+      probability: "{engagement} * 0.3"
+      call:
+        method: GET
+        url: http://{service-url}/home?m={message}&e={error}
+        body:
+          template:
+            user: "{username}"
+            items: ["{cart-id}"]
+  orphan:
+thresholds:
+- metric: latency
+  url: /home
+  percentile: 95
+  max-latency: 300ms
+- metric: error-rate
+  method: POST
+  max-error-rate: 0.01
+- metric: transitions
+  state: home
+  min-count: 10
+  abort: true
+rate-limits:
+- rate: 100
+- url: /login
+  max-in-flight: 10
+- url: /checkout
+  rate: 1
+`
+	var cfg config.Config
+	err := yaml.Unmarshal([]byte(data), &cfg)
+	c.Assert(err, qt.IsNil)
+
+	c.Assert(cfg.Analyze(), qt.DeepEquals, []config.Problem{{
+		Severity: config.SeverityWarning,
+		Path:     "entities.admin",
+		Message:  "entity is never created",
+	}, {
+		Severity: config.SeverityWarning,
+		Path:     "state.orphan",
+		Message:  "state is unreachable",
+	}, {
+		Severity: config.SeverityError,
+		Path:     "entities.user.subordinates[0].cardinality",
+		Message:  `undefined attribute "number-of-devices"`,
+	}, {
+		Severity: config.SeverityError,
+		Path:     "entities.user.attributes.greeting.expr",
+		Message:  `undefined attribute "title"`,
+	}, {
+		Severity: config.SeverityError,
+		Path:     "state.home.transitions[0].when",
+		Message:  `undefined attribute "cart-size"`,
+	}, {
+		Severity: config.SeverityError,
+		Path:     "state.home.transitions[1].probability",
+		Message:  `undefined attribute "engagement"`,
+	}, {
+		Severity: config.SeverityError,
+		Path:     "state.home.transitions[1].call.body.template",
+		Message:  `undefined attribute "cart-id"`,
+	}, {
+		Severity: config.SeverityError,
+		Path:     "state.login.transitions[0].call.url",
+		Message:  `undefined attribute "tenant"`,
+	}, {
+		Severity: config.SeverityError,
+		Path:     "state.login.transitions[0].call.params[1].attribute",
+		Message:  `undefined attribute "token"`,
+	}, {
+		Severity: config.SeverityError,
+		Path:     "state.login.transitions[0].call.results[1].expr",
+		Message:  `undefined attribute "locale"`,
+	}, {
+		Severity: config.SeverityError,
+		Path:     "constants",
+		Message:  `undefined attribute "message-key"`,
+	}, {
+		Severity: config.SeverityWarning,
+		Path:     "thresholds[1]",
+		Message:  "threshold does not match any call",
+	}, {
+		Severity: config.SeverityWarning,
+		Path:     "thresholds[2].abort",
+		Message:  "abort has no effect on transitions thresholds",
+	}, {
+		Severity: config.SeverityWarning,
+		Path:     "rate-limits[2]",
+		Message:  "rate limit does not match any call",
+	}, {
+		Severity: config.SeverityWarning,
+		Path:     "root-entities[0].cardinality",
+		Message:  "cardinality is ignored when stages are specified",
+	}, {
+		Severity: config.SeverityWarning,
+		Path:     "entities.device.on-expire",
+		Message:  "entity never expires: neither lifetime nor max-transitions specified",
+	}})
+}
@@ -3,6 +3,8 @@
 package config
 
 import (
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -24,7 +26,126 @@ type Config struct {
 	// Possible values are:
 	// - http
 	// - kafka
+	// - nop
 	Backend CallBackend `yaml:"backend"`
+	// Limits bound the execution of the simulation.
+	Limits Limits `yaml:"limits,omitempty"`
+	// Seed holds the seed of the simulation's source of
+	// randomness. Simulations with the same seed make the same
+	// random choices. If not specified, the seed is derived
+	// from the current time.
+	Seed int64 `yaml:"seed,omitempty"`
+	// Thresholds holds conditions the simulation must satisfy. If
+	// any of the thresholds is breached, the simulation fails.
+	Thresholds []Threshold `yaml:"thresholds,omitempty"`
+	// RateLimits limit the rate and concurrency of calls. A call
+	// is performed only once permitted by all limits selecting it.
+	RateLimits []RateLimit `yaml:"rate-limits,omitempty"`
+	// CallTimeout holds the default maximum duration of calls that
+	// do not specify a timeout. If not specified, calls do not time
+	// out.
+	CallTimeout time.Duration `yaml:"call-timeout,omitempty"`
+}
+
+// Limits bound the execution of the simulation. Once any of the limits
+// is reached the simulation is stopped. Zero values mean no limit.
+type Limits struct {
+	// Duration holds the maximum duration of the simulation.
+	Duration time.Duration `yaml:"duration,omitempty"`
+	// MaxTransitions holds the maximum number of state transitions
+	// performed by all entities.
+	MaxTransitions int `yaml:"max-transitions,omitempty"`
+	// MaxCalls holds the maximum number of calls performed by
+	// all entities.
+	MaxCalls int `yaml:"max-calls,omitempty"`
+	// GracePeriod holds the time in-flight calls are given to
+	// complete once the simulation is stopped. If not specified,
+	// in-flight calls are given 10 seconds.
+	GracePeriod time.Duration `yaml:"grace-period,omitempty"`
+}
+
+// LimitAction specifies what happens to a call that exceeds
+// a rate limit.
+type LimitAction string
+
+var (
+	// WaitLimitAction means the call waits until the limit
+	// permits it.
+	WaitLimitAction = LimitAction("wait")
+	// SkipLimitAction means the call and its transition are
+	// skipped and the entity remains in its current state.
+	SkipLimitAction = LimitAction("skip")
+	// FailLimitAction means the call is not performed and is
+	// counted as a failed call.
+	FailLimitAction = LimitAction("fail")
+)
+
+// RateLimit limits the rate and concurrency of calls. Calls selected
+// by the same limit share it.
+type RateLimit struct {
+	// Method selects calls with the specified method. If not
+	// specified, calls with any method are selected.
+	Method string `yaml:"method,omitempty"`
+	// URL holds a regular expression selecting calls whose URL
+	// template it matches. If neither method nor url are specified
+	// the limit applies to all calls of the simulation.
+	URL string `yaml:"url,omitempty"`
+	// Rate holds the maximum number of calls per second.
+	Rate float64 `yaml:"rate,omitempty"`
+	// Burst holds the number of calls that may be performed at
+	// once, exceeding the rate. It defaults to 1.
+	Burst int `yaml:"burst,omitempty"`
+	// MaxInFlight holds the maximum number of concurrent calls.
+	MaxInFlight int `yaml:"max-in-flight,omitempty"`
+	// OnLimit specifies what happens to calls exceeding the
+	// limit. It defaults to wait.
+	OnLimit LimitAction `yaml:"on-limit,omitempty"`
+}
+
+// ThresholdMetric names the metric checked by a threshold.
+type ThresholdMetric string
+
+var (
+	LatencyThresholdMetric     = ThresholdMetric("latency")
+	ErrorRateThresholdMetric   = ThresholdMetric("error-rate")
+	TransitionsThresholdMetric = ThresholdMetric("transitions")
+)
+
+// Threshold defines a condition the simulation must satisfy.
+type Threshold struct {
+	// Name holds an optional name of the threshold used when
+	// reporting its result.
+	Name string `yaml:"name,omitempty"`
+	// Metric names the checked metric.
+	// Possible values are:
+	// - latency: the call latency at the specified percentile
+	//   must not exceed max-latency
+	// - error-rate: the fraction of failed calls must not exceed
+	//   max-error-rate
+	// - transitions: the number of transitions to the specified
+	//   state must be at least min-count
+	Metric ThresholdMetric `yaml:"metric"`
+	// Method selects calls with the specified method. If not
+	// specified, calls with any method are selected.
+	Method string `yaml:"method,omitempty"`
+	// URL holds a regular expression selecting calls whose
+	// URL template it matches. If not specified, calls to any
+	// URL are selected.
+	URL string `yaml:"url,omitempty"`
+	// State holds the target state of counted transitions.
+	State string `yaml:"state,omitempty"`
+	// Percentile holds the latency percentile, e.g. 95.
+	Percentile float64 `yaml:"percentile,omitempty"`
+	// MaxLatency holds the maximum latency at the percentile.
+	MaxLatency time.Duration `yaml:"max-latency,omitempty"`
+	// MaxErrorRate holds the maximum fraction of failed calls,
+	// e.g. 0.01 for 1%.
+	MaxErrorRate float64 `yaml:"max-error-rate,omitempty"`
+	// MinCount holds the minimum number of transitions.
+	MinCount int `yaml:"min-count,omitempty"`
+	// Abort specifies that the simulation should be stopped as soon
+	// as the threshold is breached, instead of running to the end.
+	Abort bool `yaml:"abort,omitempty"`
 }
 
 type CallBackend string
@@ -32,6 +153,7 @@ type CallBackend string
 var (
 	HTTPCallBackend  = CallBackend("http")
 	KafkaCallBackend = CallBackend("kafka")
+	NOPCallBackend   = CallBackend("nop")
 )
 
 type EntitySet struct {
@@ -43,6 +165,26 @@ type EntitySet struct {
 	Entity string `yaml:"entity"`
 	// Timer defines the cadence at which new entity instances are created.
 	Timer Timer `yaml:"timer,omitempty"`
+	// Stages defines a load profile, which determines the number
+	// of live entities over time. If specified, cardinality and
+	// timer are not used.
+	Stages []Stage `yaml:"stages,omitempty"`
+}
+
+// Stage defines a stage of a load profile. During a stage the number
+// of live entities changes linearly from the target of the previous
+// stage (or 0 for the first stage) to the target of the stage. New
+// entities are created as needed, entities that finish are replaced
+// and surplus entities are retired, stopping them and their
+// subordinates. Once the last stage completes remaining entities
+// continue to run, but are no longer replaced.
+type Stage struct {
+	// Duration holds the duration of the stage. A stage without
+	// a duration changes the number of entities at once.
+	Duration time.Duration `yaml:"duration,omitempty"`
+	// Target holds the number of live entities at the end of
+	// the stage.
+	Target int `yaml:"target"`
 }
 
 // Entity holds information about an entity in the simulation.
@@ -57,6 +199,50 @@ type Entity struct {
 	// created, their cardinality and a timer, which determines
 	// when a new subordinate entity is to be created.
 	Subordinates []EntitySet `yaml:"subordinates,omitempty"`
+	// Lifetime determines how long the entity lives: the entity
+	// expires once the first interval of the timer elapses, so a
+	// fixed timer specifies a fixed lifetime and, for example, a
+	// random timer a lifetime between min and max.
+	Lifetime Timer `yaml:"lifetime,omitempty"`
+	// MaxTransitions holds the maximum number of transitions the
+	// entity performs before it expires.
+	MaxTransitions int `yaml:"max-transitions,omitempty"`
+	// OnExpire holds the transition performed when the entity
+	// expires, e.g. to log out. Its probability is not used. The
+	// entity finishes in the state of the transition without
+	// performing the state's transitions. Once an expired entity
+	// finishes, its subordinates are stopped.
+	OnExpire *Transition `yaml:"on-expire,omitempty"`
+	// Session holds the settings of the http session of the entity,
+	// which holds the cookies, including discharged macaroons, of
+	// its calls.
+	Session Session `yaml:"session,omitempty"`
+}
+
+// SessionScope specifies which entities share an http session.
+type SessionScope string
+
+var (
+	// EntitySession means each entity has its own session.
+	EntitySession = SessionScope("entity")
+	// ParentSession means the entity shares the session of the
+	// entity it is a subordinate of, e.g. devices of the same
+	// user. Root entities have their own session.
+	ParentSession = SessionScope("parent")
+	// SharedSession means the entity shares a single session with
+	// all entities with the shared scope.
+	SharedSession = SessionScope("shared")
+)
+
+// Session holds the settings of http sessions.
+type Session struct {
+	// Scope holds the scope of the session. It defaults to entity.
+	Scope SessionScope `yaml:"scope,omitempty"`
+	// IsolateConnections specifies that the session uses its own
+	// connections instead of the connection pool shared by all
+	// sessions. It is ignored by entities that do not have their
+	// own session.
+	IsolateConnections bool `yaml:"isolate-connections,omitempty"`
 }
 
 type State struct {
@@ -69,6 +255,11 @@ type State struct {
 	// Transitions holds a list of all transitions from
 	// this state into another.
 	Transitions []Transition `yaml:"transitions,omitempty"`
+	// Fallback holds the transition performed when the guards of
+	// all transitions are false. Its probability and guard are not
+	// used. If no fallback is specified, the entity finishes in the
+	// state.
+	Fallback *Transition `yaml:"fallback,omitempty"`
 }
 
 // TODO ADD NOP CALL
@@ -78,14 +269,69 @@ type Transition struct {
 	// transition leads.
 	State string `yaml:"state"`
 	// Probability holds the probability of this transition
-	Probability float64 `yaml:"probability,omitempty"`
+	// relative to the other transitions of the state.
+	Probability Weight `yaml:"probability,omitempty"`
+	// When holds an expression guarding the transition, e.g.
+	// "{cart-size} > 0", evaluated against the attributes of the
+	// entity. Only transitions whose guard is true are chosen.
+	When string `yaml:"when,omitempty"`
 	// Call holds the configuration information for a
 	// http requedt to be performed on state transition and
 	// instructions on what to do with result
 	Call Call `yaml:"call"`
+	// Calls holds further calls performed in order after Call,
+	// e.g. to load a page, fetch its assets and post an analytics
+	// event. Results of earlier calls are available to later calls.
+	Calls []Call `yaml:"calls,omitempty"`
+	// ContinueOnFailure specifies whether the remaining calls are
+	// performed once a call fails. Either way the transition fails
+	// if any of its calls fails.
+	ContinueOnFailure bool `yaml:"continue-on-failure,omitempty"`
 	// OnFailure names the state to which to transition
 	// in case of call failure.
 	OnFailure string `yaml:"on-failure,omitempty"`
+	// OnTimeout names the state to which to transition if a call
+	// times out. If not specified, the transition leads to the
+	// on-failure state.
+	OnTimeout string `yaml:"on-timeout,omitempty"`
+}
+
+// CallSequence returns the calls performed by the transition in the
+// order in which they are performed.
+func (t Transition) CallSequence() []Call {
+	var calls []Call
+	if !t.Call.IsEmpty() {
+		calls = append(calls, t.Call)
+	}
+	for _, c := range t.Calls {
+		if !c.IsEmpty() {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// Weight holds the weight of a transition, which is either a number
+// or an expression evaluated against the attributes of the entity,
+// e.g. "{engagement} * 0.3".
+type Weight string
+
+// Value returns the weight and true if the weight is a number.
+func (w Weight) Value() (float64, bool) {
+	if w == "" {
+		return 0, true
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(string(w)), 64)
+	return f, err == nil
+}
+
+// GetYAML implements yaml.Getter, marshaling numeric weights as
+// numbers.
+func (w Weight) GetYAML() (string, interface{}) {
+	if f, ok := w.Value(); ok {
+		return "", f
+	}
+	return "", string(w)
 }
 
 type Call struct {
@@ -97,17 +343,223 @@ type Call struct {
 	URL string `yaml:"url"`
 	// Parameters holds the specification for http request parameters.
 	Parameters []CallParameter `yaml:"params"`
-	Results    []CallResult    `yaml:"results"`
+	// Body holds the request body of http calls. If not specified,
+	// the body is the JSON object holding the body parameters.
+	Body    *Body        `yaml:"body,omitempty"`
+	Results []CallResult `yaml:"results"`
+	// Parallel holds calls performed concurrently, e.g. to mimic a
+	// browser fetching resources. A call with parallel calls does
+	// not perform a request itself; it completes once all parallel
+	// calls complete, and fails if any of them fails.
+	Parallel []Call `yaml:"parallel,omitempty"`
+	// Timeout holds the maximum duration of the call, measured in
+	// wall clock time. If not specified, the call timeout of the
+	// simulation applies.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// Retry holds the policy for retrying the call if it fails.
+	// If not specified, failed calls are not retried.
+	Retry *Retry `yaml:"retry,omitempty"`
+	// Expect holds the criteria for the success of http calls. If
+	// not specified, calls succeed if the response status is 200.
+	Expect *Expect `yaml:"expect,omitempty"`
+}
+
+// Expect holds the criteria for the success of an http call. Calls
+// whose response does not meet all criteria fail.
+type Expect struct {
+	// Status holds the accepted status codes: codes (e.g. "201"),
+	// classes of codes (e.g. "2xx") or ranges (e.g. "200-299"). If
+	// not specified, only 200 is accepted.
+	Status []string `yaml:"status,omitempty"`
+	// Body holds assertions on the response body.
+	Body []Assertion `yaml:"body,omitempty"`
+	// Headers holds assertions on the response headers.
+	Headers []Assertion `yaml:"headers,omitempty"`
+	// MaxLatency holds the maximum time to receive the response.
+	MaxLatency time.Duration `yaml:"max-latency,omitempty"`
+}
+
+// Assertion holds an assertion on a value of the response. If none
+// of Equals, Matches and Schema are specified, the assertion holds if
+// the response holds the value.
+type Assertion struct {
+	// Name holds the name of the assertion, recorded as the cause of
+	// failed calls. It defaults to body or header followed by the
+	// key, e.g. body.data.id, or to body for the whole body.
+	Name string `yaml:"name,omitempty"`
+	// Key holds the JSON path of the value in the body, or the name
+	// of the header. Body assertions without a key apply to the whole
+	// body.
+	Key string `yaml:"key,omitempty"`
+	// Equals holds the string, number or boolean the value must
+	// equal.
+	Equals interface{} `yaml:"equals,omitempty"`
+	// Matches holds a regular expression the value must match.
+	Matches string `yaml:"matches,omitempty"`
+	// Schema holds the schema the JSON value must conform to. It is
+	// not supported for headers.
+	Schema *Schema `yaml:"schema,omitempty"`
+}
+
+// AssertionName returns the name of the assertion of the specified
+// kind, body or header.
+func (a Assertion) AssertionName(kind string) string {
+	switch {
+	case a.Name != "":
+		return a.Name
+	case a.Key == "":
+		return kind
+	}
+	return kind + "." + a.Key
+}
+
+// Schema holds a subset of JSON schema.
+type Schema struct {
+	// Type holds the type of the value: object, array, string,
+	// number, integer, boolean or null.
+	Type string `yaml:"type,omitempty"`
+	// Properties holds the schemas of the fields of objects.
+	Properties map[string]*Schema `yaml:"properties,omitempty"`
+	// Required holds the fields objects must have.
+	Required []string `yaml:"required,omitempty"`
+	// Items holds the schema of the elements of arrays.
+	Items *Schema `yaml:"items,omitempty"`
+	// Enum holds the values the value must be one of.
+	Enum []interface{} `yaml:"enum,omitempty"`
+}
+
+// AcceptsStatus reports whether the status code meets the criteria.
+func (e *Expect) AcceptsStatus(code int) bool {
+	if e == nil || len(e.Status) == 0 {
+		return code == 200
+	}
+	for _, s := range e.Status {
+		min, max, ok := parseStatus(s)
+		if ok && code >= min && code <= max {
+			return true
+		}
+	}
+	return false
+}
+
+// parseStatus returns the range of status codes specified by s and
+// true, or false if s is not a valid status code, class or range.
+func parseStatus(s string) (min, max int, ok bool) {
+	s = strings.TrimSpace(s)
+	if len(s) == 3 && strings.HasSuffix(s, "xx") && s[0] >= '1' && s[0] <= '5' {
+		min = int(s[0]-'0') * 100
+		return min, min + 99, true
+	}
+	parts := strings.SplitN(s, "-", 2)
+	min, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, false
+	}
+	max = min
+	if len(parts) == 2 {
+		if max, err = strconv.Atoi(strings.TrimSpace(parts[1])); err != nil {
+			return 0, 0, false
+		}
+	}
+	if min < 100 || max > 599 || max < min {
+		return 0, 0, false
+	}
+	return min, max, true
+}
+
+// BackoffType specifies how the delay between attempts of a call
+// changes.
+type BackoffType string
+
+var (
+	// FixedBackoff means attempts are separated by a constant
+	// delay.
+	FixedBackoff = BackoffType("fixed")
+	// ExponentialBackoff means the delay is multiplied by the
+	// multiplier after each attempt.
+	ExponentialBackoff = BackoffType("exponential")
+)
+
+// Retry holds the policy for retrying failed calls. Each attempt
+// counts as a call and is subject to rate limits.
+type Retry struct {
+	// MaxAttempts holds the maximum number of attempts, including
+	// the first one.
+	MaxAttempts int `yaml:"max-attempts"`
+	// Backoff holds the type of backoff. It defaults to fixed.
+	Backoff BackoffType `yaml:"backoff,omitempty"`
+	// Delay holds the delay before the first retry.
+	Delay time.Duration `yaml:"delay,omitempty"`
+	// MaxDelay holds the maximum delay between attempts when
+	// using exponential backoff.
+	MaxDelay time.Duration `yaml:"max-delay,omitempty"`
+	// Multiplier holds the factor by which the delay grows after
+	// each attempt when using exponential backoff. It defaults to 2.
+	Multiplier float64 `yaml:"multiplier,omitempty"`
+	// Jitter holds the fraction, between 0 and 1, by which delays
+	// are randomly increased or decreased.
+	Jitter float64 `yaml:"jitter,omitempty"`
+	// On holds the errors on which calls are retried: status codes
+	// (e.g. "503"), classes of status codes (e.g. "5xx") or error
+	// causes (timeout, send-error, throttled, other), expect for
+	// any failed expectation or expect: followed by the name of an
+	// assertion. If not specified, calls are retried on any error
+	// other than the simulation stopping.
+	On []string `yaml:"on,omitempty"`
+}
+
+// IsEmpty reports whether the call performs no request.
+func (c Call) IsEmpty() bool {
+	return c.Method == "" && c.URL == "" && len(c.Parameters) == 0 && c.Body == nil && len(c.Results) == 0 && len(c.Parallel) == 0
 }
 
 type CallResult struct {
-	// Key holds the name of the JSON field.
+	// Source holds the part of the response the value is read
+	// from. Possible values are:
+	// - body: the JSON value at the path specified by Key (default)
+	// - header: the value of the response header named by Key
+	// - cookie: the value of the cookie named by Key set by the
+	//           response
+	// - status: the status code of the response
+	// - raw-body: the first group captured by the regular expression
+	//           specified by Key in the response body, or the whole
+	//           match if the expression has no groups
+	Source ResultSource `yaml:"source,omitempty"`
+	// Key holds the path of the JSON value in the response, e.g.
+	// token, data.items[0].id, items[*].id for the list of ids of
+	// all items or length(items) for the number of items. Values
+	// retain their JSON types. For other sources it holds the
+	// header or cookie name or the regular expression.
 	Key string `yaml:"key"`
 	// Attribute holds the name of the attribute
 	// to be set.
 	Attribute string `yaml:"attribute"`
+	// Optional specifies whether the call succeeds if the response
+	// does not hold the value, in which case the attribute is not
+	// set.
+	Optional bool `yaml:"optional,omitempty"`
+	// Default holds the value of the attribute if the response does
+	// not hold the value. Results with a default are optional.
+	Default interface{} `yaml:"default,omitempty"`
+	// Expr holds an expression computing the value of the
+	// attribute once the call succeeds, instead of reading it from
+	// the response, e.g. "{price} * {quantity}". Results are
+	// computed in order, after values are read from the response.
+	Expr string `yaml:"expr,omitempty"`
 }
 
+// ResultSource specifies the part of the response a call result is
+// read from.
+type ResultSource string
+
+var (
+	BodyResultSource    = ResultSource("body")
+	HeaderResultSource  = ResultSource("header")
+	CookieResultSource  = ResultSource("cookie")
+	StatusResultSource  = ResultSource("status")
+	RawBodyResultSource = ResultSource("raw-body")
+)
+
 type CallParameterType string
 
 var (
@@ -132,11 +584,80 @@ type CallParameter struct {
 	Key string `yaml:"key"`
 }
 
+// BodyType specifies the encoding of a request body.
+type BodyType string
+
+var (
+	// JSONBody means the body is the JSON encoded template.
+	JSONBody = BodyType("json")
+	// FormBody means the fields of the template are form
+	// encoded.
+	FormBody = BodyType("form-urlencoded")
+	// MultipartBody means the fields of the template and the
+	// generated files are encoded as multipart/form-data.
+	MultipartBody = BodyType("multipart")
+	// TextBody means the body is the template string.
+	TextBody = BodyType("text")
+	// BinaryBody means the body is the content of the file or
+	// generated data.
+	BinaryBody = BodyType("binary")
+)
+
+// Body holds the specification of a request body. The Content-Type
+// header of the request is set according to its type.
+type Body struct {
+	// Type holds the type of the body. It defaults to json.
+	Type BodyType `yaml:"type,omitempty"`
+	// Template holds the body template: a structure of objects,
+	// arrays and values for json bodies, an object mapping field
+	// names to values for form-urlencoded and multipart bodies, or
+	// a string for text bodies. Strings may contain {attribute}
+	// placeholders; in json bodies a string consisting of a single
+	// placeholder is replaced by the value of the attribute, keeping
+	// its type. Body parameters are added as fields of the template.
+	Template interface{} `yaml:"template,omitempty"`
+	// File holds the name of a file holding the template, in YAML or
+	// JSON for json, form-urlencoded and multipart bodies, used
+	// instead of Template. The content of the file is sent as-is in
+	// binary bodies. A relative File is relative to the directory of
+	// the configuration file.
+	File string `yaml:"file,omitempty"`
+	// Size holds the size in bytes of the random data sent in binary
+	// bodies without a file. Random data is drawn from the entity's
+	// source of randomness, derived from the simulation seed.
+	Size int `yaml:"size,omitempty"`
+	// Files holds the files uploaded in multipart bodies.
+	Files []FileUpload `yaml:"files,omitempty"`
+	// ContentType holds the content type of the body, overriding the
+	// default for its type.
+	ContentType string `yaml:"content-type,omitempty"`
+}
+
+// FileUpload holds the specification of a file of random data uploaded
+// in a multipart body.
+type FileUpload struct {
+	// Field holds the name of the form field.
+	Field string `yaml:"field"`
+	// Name holds the name of the file. It may contain {attribute}
+	// placeholders.
+	Name string `yaml:"name"`
+	// Size holds the size of the file in bytes.
+	Size int `yaml:"size"`
+	// ContentType holds the content type of the file. It defaults
+	// to application/octet-stream.
+	ContentType string `yaml:"content-type,omitempty"`
+}
+
 type TimerType string
 
 var (
-	RandomTimer = TimerType("random")
-	FixedTimer  = TimerType("fixed")
+	RandomTimer      = TimerType("random")
+	FixedTimer       = TimerType("fixed")
+	ExponentialTimer = TimerType("exponential")
+	LogNormalTimer   = TimerType("lognormal")
+	ParetoTimer      = TimerType("pareto")
+	DailyTimer       = TimerType("daily")
+	TraceTimer       = TimerType("trace")
 )
 
 type Timer struct {
@@ -146,10 +667,35 @@ type Timer struct {
 	//           between Min and Max durations long.
 	// - fixed: means the timer will fire at fixed intervals defined
 	//          by Interval
+	// - exponential: means the timer will fire at exponentially
+	//          distributed intervals, modelling a Poisson process
+	//          with Rate events per second
+	// - lognormal: means intervals follow a log-normal distribution
+	//          with the specified Median and shape Sigma
+	// - pareto: means intervals follow a Pareto distribution with
+	//          scale Min and shape Alpha. If Max is specified longer
+	//          intervals are truncated to Max.
+	// - daily: means the timer fires as a Poisson process whose
+	//          rate varies over the day. Rates, in events per second,
+	//          divide the day into equal periods, so 24 rates specify
+	//          the rate for each hour of the day, in the time zone of
+	//          the simulation clock
+	// - trace: means intervals are replayed from the CSV File,
+	//          whose first column holds either durations (e.g. 1.5s)
+	//          or numbers of seconds. Each timer replays the
+	//          intervals from the first and restarts from the first
+	//          once the trace is exhausted. A relative File is
+	//          relative to the directory of the configuration file.
 	Type     TimerType     `yaml:"type"`
 	Min      time.Duration `yaml:"min,omitempty"`
 	Max      time.Duration `yaml:"max,omitempty"`
 	Interval time.Duration `yaml:"interval,omitempty"`
+	Rate     float64       `yaml:"rate,omitempty"`
+	Median   time.Duration `yaml:"median,omitempty"`
+	Sigma    float64       `yaml:"sigma,omitempty"`
+	Alpha    float64       `yaml:"alpha,omitempty"`
+	Rates    []float64     `yaml:"rates,omitempty"`
+	File     string        `yaml:"file,omitempty"`
 }
 
 type AttributeType string
@@ -166,6 +712,7 @@ var (
 	RandomStringAttributeType   = AttributeType("random_string")
 	RandomValueAttributeType    = AttributeType("random_value")
 	RandomSubsetAttributeType   = AttributeType("random_subset")
+	ExprAttributeType           = AttributeType("expr")
 )
 
 // Attribute holds the definition of the attribute's value or
@@ -189,6 +736,12 @@ type Attribute struct {
 	//          list will be chosen
 	// - random_subset: meaning a random subset of Values will
 	//          be chosen
+	// - expr: meaning the value is computed by evaluating Expr
+	//          against the current attributes, e.g. to increment
+	//          a counter on each visit to a state:
+	//          "default({visits}, 0) + 1". Computed attributes
+	//          are evaluated after all other attributes, in order
+	//          of their names.
 	Type        AttributeType `yaml:"type"`
 	StringValue string        `yaml:"string-value,omitempty"`
 	Value       float64       `yaml:"value,omitempty"`
@@ -197,4 +750,5 @@ type Attribute struct {
 	N           float64       `yaml:"n,omitempty"`
 	StdDev      float64       `yaml:"std-dev,omitempty"`
 	Values      []interface{} `yaml:"values,omitempty"`
+	Expr        string        `yaml:"expr,omitempty"`
 }
@@ -0,0 +1,58 @@
+// Copyright 2019 CanonicalLtd
+
+package config
+
+// Merge merges the other configuration into c. Constants, entities
+// and states defined in other replace those with the same name in c,
+// root entities, thresholds and rate limits of other are appended to
+// those of c and the backend, limits, seed and call timeout of other,
+// if set, replace those of c.
+func (c *Config) Merge(other Config) {
+	if len(other.Constants) > 0 && c.Constants == nil {
+		c.Constants = make(map[string]interface{})
+	}
+	for k, v := range other.Constants {
+		c.Constants[k] = v
+	}
+	c.RootEntities = append(c.RootEntities, other.RootEntities...)
+	if len(other.Entities) > 0 && c.Entities == nil {
+		c.Entities = make(map[string]Entity)
+	}
+	for k, v := range other.Entities {
+		c.Entities[k] = v
+	}
+	if len(other.States) > 0 && c.States == nil {
+		c.States = make(map[string]State)
+	}
+	for k, v := range other.States {
+		c.States[k] = v
+	}
+	if other.Backend != "" {
+		c.Backend = other.Backend
+	}
+	c.Limits.Merge(other.Limits)
+	if other.Seed != 0 {
+		c.Seed = other.Seed
+	}
+	c.Thresholds = append(c.Thresholds, other.Thresholds...)
+	c.RateLimits = append(c.RateLimits, other.RateLimits...)
+	if other.CallTimeout != 0 {
+		c.CallTimeout = other.CallTimeout
+	}
+}
+
+// Merge replaces limits in l with those set in other.
+func (l *Limits) Merge(other Limits) {
+	if other.Duration != 0 {
+		l.Duration = other.Duration
+	}
+	if other.MaxTransitions != 0 {
+		l.MaxTransitions = other.MaxTransitions
+	}
+	if other.MaxCalls != 0 {
+		l.MaxCalls = other.MaxCalls
+	}
+	if other.GracePeriod != 0 {
+		l.GracePeriod = other.GracePeriod
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2019 CanonicalLtd
+
+package config_test
+
+import (
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/cloud-green/sisyphus/config"
+)
+
+func TestMerge(t *testing.T) {
+	c := qt.New(t)
+
+	cfg := config.Config{
+		Constants: map[string]interface{}{
+			"url":   "test.com",
+			"users": 10,
+		},
+		RootEntities: []config.EntitySet{{
+			Entity: "user",
+		}},
+		Entities: map[string]config.Entity{
+			"user": {InitialState: "login"},
+		},
+		States: map[string]config.State{
+			"login": {},
+		},
+		Backend: config.HTTPCallBackend,
+		Thresholds: []config.Threshold{{
+			Metric:       config.ErrorRateThresholdMetric,
+			MaxErrorRate: 0.01,
+		}},
+	}
+	cfg.Merge(config.Config{
+		Constants: map[string]interface{}{
+			"users": 100,
+		},
+		RootEntities: []config.EntitySet{{
+			Entity: "admin",
+		}},
+		Entities: map[string]config.Entity{
+			"admin": {InitialState: "admin-login"},
+		},
+		States: map[string]config.State{
+			"admin-login": {},
+		},
+		Thresholds: []config.Threshold{{
+			Metric:   config.TransitionsThresholdMetric,
+			State:    "admin-login",
+			MinCount: 1,
+		}},
+	})
+
+	c.Assert(cfg, qt.DeepEquals, config.Config{
+		Constants: map[string]interface{}{
+			"url":   "test.com",
+			"users": 100,
+		},
+		RootEntities: []config.EntitySet{{
+			Entity: "user",
+		}, {
+			Entity: "admin",
+		}},
+		Entities: map[string]config.Entity{
+			"user":  {InitialState: "login"},
+			"admin": {InitialState: "admin-login"},
+		},
+		States: map[string]config.State{
+			"login":       {},
+			"admin-login": {},
+		},
+		Backend: config.HTTPCallBackend,
+		Thresholds: []config.Threshold{{
+			Metric:       config.ErrorRateThresholdMetric,
+			MaxErrorRate: 0.01,
+		}, {
+			Metric:   config.TransitionsThresholdMetric,
+			State:    "admin-login",
+			MinCount: 1,
+		}},
+	})
+}
+
+func TestMergeCallTimeout(t *testing.T) {
+	c := qt.New(t)
+
+	tests := []struct {
+		about           string
+		timeout         time.Duration
+		otherTimeout    time.Duration
+		expectedTimeout time.Duration
+	}{{
+		about:           "timeout set in other",
+		timeout:         time.Second,
+		otherTimeout:    5 * time.Second,
+		expectedTimeout: 5 * time.Second,
+	}, {
+		about:           "timeout not set in other",
+		timeout:         time.Second,
+		expectedTimeout: time.Second,
+	}, {
+		about:           "timeout only set in other",
+		otherTimeout:    5 * time.Second,
+		expectedTimeout: 5 * time.Second,
+	}}
+	for i, test := range tests {
+		c.Logf("running test %d: %s", i, test.about)
+		cfg := config.Config{
+			CallTimeout: test.timeout,
+		}
+		cfg.Merge(config.Config{
+			CallTimeout: test.otherTimeout,
+		})
+		c.Assert(cfg.CallTimeout, qt.Equals, test.expectedTimeout)
+	}
+}
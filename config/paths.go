@@ -0,0 +1,80 @@
+// Copyright 2019 CanonicalLtd
+
+package config
+
+import "path/filepath"
+
+// ResolvePaths resolves the relative names of trace files of timers
+// and of body files of calls against the specified directory, usually
+// the directory holding the configuration file.
+func (c *Config) ResolvePaths(dir string) {
+	for i := range c.RootEntities {
+		c.RootEntities[i].Timer.resolvePath(dir)
+	}
+	for name, e := range c.Entities {
+		e.Lifetime.resolvePath(dir)
+		if len(e.Subordinates) > 0 {
+			subordinates := make([]EntitySet, len(e.Subordinates))
+			copy(subordinates, e.Subordinates)
+			for i := range subordinates {
+				subordinates[i].Timer.resolvePath(dir)
+			}
+			e.Subordinates = subordinates
+		}
+		e.OnExpire = e.OnExpire.resolvePaths(dir)
+		c.Entities[name] = e
+	}
+	for name, s := range c.States {
+		s.Timer.resolvePath(dir)
+		if len(s.Transitions) > 0 {
+			transitions := make([]Transition, len(s.Transitions))
+			for i, t := range s.Transitions {
+				transitions[i] = *t.resolvePaths(dir)
+			}
+			s.Transitions = transitions
+		}
+		s.Fallback = s.Fallback.resolvePaths(dir)
+		c.States[name] = s
+	}
+}
+
+func (t *Timer) resolvePath(dir string) {
+	if t.File != "" && !filepath.IsAbs(t.File) {
+		t.File = filepath.Join(dir, t.File)
+	}
+}
+
+// resolvePaths returns a copy of the transition with the body files of
+// its calls resolved against dir.
+func (t *Transition) resolvePaths(dir string) *Transition {
+	if t == nil {
+		return nil
+	}
+	resolved := *t
+	resolved.Call = t.Call.resolvePaths(dir)
+	resolved.Calls = resolveCallPaths(t.Calls, dir)
+	return &resolved
+}
+
+// resolvePaths returns a copy of the call with the body files of the
+// call and its parallel calls resolved against dir.
+func (c Call) resolvePaths(dir string) Call {
+	if c.Body != nil && c.Body.File != "" && !filepath.IsAbs(c.Body.File) {
+		body := *c.Body
+		body.File = filepath.Join(dir, body.File)
+		c.Body = &body
+	}
+	c.Parallel = resolveCallPaths(c.Parallel, dir)
+	return c
+}
+
+func resolveCallPaths(calls []Call, dir string) []Call {
+	if len(calls) == 0 {
+		return calls
+	}
+	resolved := make([]Call, len(calls))
+	for i, c := range calls {
+		resolved[i] = c.resolvePaths(dir)
+	}
+	return resolved
+}
@@ -0,0 +1,182 @@
+// Copyright 2019 CanonicalLtd
+
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	yaml "gopkg.in/yaml.v1"
+
+	"github.com/cloud-green/sisyphus/config"
+)
+
+func TestResolvePaths(t *testing.T) {
+	c := qt.New(t)
+
+	trace := config.Timer{
+		Type: config.TraceTimer,
+		File: "traces/arrivals.csv",
+	}
+	body := &config.Body{
+		File: "bodies/login.json",
+	}
+	cfg := config.Config{
+		RootEntities: []config.EntitySet{{
+			Entity: "user",
+			Timer:  trace,
+		}, {
+			Entity: "bot",
+			Timer: config.Timer{
+				Type: config.TraceTimer,
+				File: "/var/traces/bots.csv",
+			},
+		}},
+		Entities: map[string]config.Entity{
+			"user": {
+				Lifetime: trace,
+				Subordinates: []config.EntitySet{{
+					Entity: "device",
+					Timer:  trace,
+				}},
+			},
+			"device": {},
+			"admin": {
+				OnExpire: &config.Transition{
+					State: "login",
+					Call:  config.Call{Body: body},
+				},
+			},
+		},
+		States: map[string]config.State{
+			"login": {
+				Timer: trace,
+				Transitions: []config.Transition{{
+					State: "home",
+					Call:  config.Call{Body: body},
+					Calls: []config.Call{{
+						Parallel: []config.Call{{
+							Body: body,
+						}, {
+							Parallel: []config.Call{{
+								Body: body,
+							}},
+						}},
+					}, {
+						Body: &config.Body{File: "/var/bodies/login.json"},
+					}},
+				}},
+			},
+			"home": {
+				Timer: config.Timer{
+					Type:     config.FixedTimer,
+					Interval: 1,
+				},
+				Fallback: &config.Transition{
+					State: "login",
+					Call:  config.Call{Body: body},
+				},
+			},
+		},
+	}
+	cfg.ResolvePaths("/etc/sisyphus")
+
+	resolved := "/etc/sisyphus/traces/arrivals.csv"
+	c.Assert(cfg.RootEntities[0].Timer.File, qt.Equals, resolved)
+	c.Assert(cfg.RootEntities[1].Timer.File, qt.Equals, "/var/traces/bots.csv")
+	c.Assert(cfg.Entities["user"].Lifetime.File, qt.Equals, resolved)
+	c.Assert(cfg.Entities["user"].Subordinates[0].Timer.File, qt.Equals, resolved)
+	c.Assert(cfg.Entities["device"].Subordinates, qt.IsNil)
+	c.Assert(cfg.States["login"].Timer.File, qt.Equals, resolved)
+	c.Assert(cfg.States["home"].Timer.File, qt.Equals, "")
+
+	resolvedBody := "/etc/sisyphus/bodies/login.json"
+	c.Assert(cfg.Entities["admin"].OnExpire.Call.Body.File, qt.Equals, resolvedBody)
+	transition := cfg.States["login"].Transitions[0]
+	c.Assert(transition.Call.Body.File, qt.Equals, resolvedBody)
+	c.Assert(transition.Calls[0].Parallel[0].Body.File, qt.Equals, resolvedBody)
+	c.Assert(transition.Calls[0].Parallel[1].Parallel[0].Body.File, qt.Equals, resolvedBody)
+	c.Assert(transition.Calls[1].Body.File, qt.Equals, "/var/bodies/login.json")
+	c.Assert(cfg.States["home"].Fallback.Call.Body.File, qt.Equals, resolvedBody)
+	// bodies shared with other calls are not modified
+	c.Assert(body.File, qt.Equals, "bodies/login.json")
+}
+
+func TestResolvePathsFromOtherDirectory(t *testing.T) {
+	c := qt.New(t)
+
+	dir, err := ioutil.TempDir("", "sisyphus")
+	c.Assert(err, qt.IsNil)
+	defer os.RemoveAll(dir)
+	err = os.Mkdir(filepath.Join(dir, "bodies"), 0755)
+	c.Assert(err, qt.IsNil)
+	err = ioutil.WriteFile(filepath.Join(dir, "bodies", "login.json"), []byte(`{"user": "{name}"}`), 0644)
+	c.Assert(err, qt.IsNil)
+	err = ioutil.WriteFile(filepath.Join(dir, "trace.csv"), []byte("1s\n"), 0644)
+	c.Assert(err, qt.IsNil)
+	filename := filepath.Join(dir, "config.yaml")
+	err = ioutil.WriteFile(filename, []byte(`
+root-entities:
+- entity: user
+  timer:
+    type: trace
+    file: trace.csv
+entities:
+  user:
+    initial_state: start
+    on-expire:
+      state: start
+      call:
+        method: POST
+        url: http://test.com/logout
+        body:
+          file: bodies/login.json
+state:
+  start:
+    transitions:
+    - state: start
+      call:
+        method: POST
+        url: http://test.com/login
+        body:
+          file: bodies/login.json
+      calls:
+      - parallel:
+        - method: POST
+          url: http://test.com/event
+          body:
+            file: bodies/login.json
+    fallback:
+      state: start
+      call:
+        method: POST
+        url: http://test.com/login
+        body:
+          file: bodies/login.json
+`), 0644)
+	c.Assert(err, qt.IsNil)
+
+	// the configuration is not loaded from the working directory
+	c.Assert(filepath.Dir(filename) == ".", qt.Equals, false)
+	data, err := ioutil.ReadFile(filename)
+	c.Assert(err, qt.IsNil)
+	var cfg config.Config
+	err = yaml.Unmarshal(data, &cfg)
+	c.Assert(err, qt.IsNil)
+	cfg.ResolvePaths(filepath.Dir(filename))
+
+	files := []string{
+		cfg.RootEntities[0].Timer.File,
+		cfg.Entities["user"].OnExpire.Call.Body.File,
+		cfg.States["start"].Transitions[0].Call.Body.File,
+		cfg.States["start"].Transitions[0].Calls[0].Parallel[0].Body.File,
+		cfg.States["start"].Fallback.Call.Body.File,
+	}
+	for _, file := range files {
+		_, err := ioutil.ReadFile(file)
+		c.Assert(err, qt.IsNil, qt.Commentf("file %q", file))
+	}
+}
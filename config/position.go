@@ -0,0 +1,110 @@
+// Copyright 2019 CanonicalLtd
+
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	keyPattern = regexp.MustCompile(`^("[^"]*"|'[^']*'|[^\s:#'"][^:#]*?)\s*:(\s|$)`)
+)
+
+// Positions maps YAML paths, as used in Problem.Path, to the
+// line numbers at which they are defined.
+type Positions map[string]int
+
+// Line returns the line number at which the value with the
+// specified path is defined. If the path itself is not defined,
+// the line of its closest defined ancestor is returned, or 0 if
+// no ancestor is defined.
+func (p Positions) Line(path string) int {
+	for path != "" {
+		if line, ok := p[path]; ok {
+			return line
+		}
+		i := strings.LastIndexAny(path, ".[")
+		if i < 0 {
+			break
+		}
+		path = path[:i]
+	}
+	return 0
+}
+
+type positionFrame struct {
+	indent int
+	path   string
+	item   bool
+}
+
+// ParsePositions returns the positions of all mapping keys and
+// sequence items in the YAML document. It only understands the block
+// style used in simulation configuration files, which is sufficient
+// to locate problems reported by Validate and Analyze.
+func ParsePositions(data []byte) Positions {
+	positions := make(Positions)
+	counts := make(map[string]int)
+	var stack []positionFrame
+
+	parent := func() string {
+		if len(stack) == 0 {
+			return ""
+		}
+		return stack[len(stack)-1].path
+	}
+	join := func(parent, key string) string {
+		if parent == "" {
+			return key
+		}
+		return parent + "." + key
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := scanner.Text()
+		content := strings.TrimLeft(line, " ")
+		if content == "" || strings.HasPrefix(content, "#") || strings.HasPrefix(content, "---") {
+			continue
+		}
+		indent := len(line) - len(content)
+
+		if content == "-" || strings.HasPrefix(content, "- ") {
+			// a sequence item belongs to the closest preceding key
+			// with a lower or equal indentation.
+			for len(stack) > 0 {
+				top := stack[len(stack)-1]
+				if top.indent < indent || (top.indent == indent && !top.item) {
+					break
+				}
+				stack = stack[:len(stack)-1]
+			}
+			sequence := parent()
+			path := fmt.Sprintf("%s[%d]", sequence, counts[sequence])
+			counts[sequence]++
+			positions[path] = lineNumber
+			stack = append(stack, positionFrame{indent: indent, path: path, item: true})
+
+			rest := strings.TrimPrefix(content, "-")
+			trimmed := strings.TrimLeft(rest, " ")
+			indent += 1 + len(rest) - len(trimmed)
+			content = trimmed
+		}
+
+		match := keyPattern.FindStringSubmatch(content)
+		if match == nil {
+			continue
+		}
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+		path := join(parent(), strings.Trim(match[1], `"'`))
+		positions[path] = lineNumber
+		stack = append(stack, positionFrame{indent: indent, path: path})
+	}
+	return positions
+}
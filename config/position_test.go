@@ -0,0 +1,68 @@
+// Copyright 2019 CanonicalLtd
+
+package config_test
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/cloud-green/sisyphus/config"
+)
+
+func TestParsePositions(t *testing.T) {
+	c := qt.New(t)
+
+	data := `# a comment
+constants:
+  number-of-users: 1
+root-entities:
+- entity: user
+  cardinality: number-of-users
+- entity: admin
+entities:
+  user:
+    initial_state: login
+    subordinates:
+      - entity: device
+        timer:
+          type: fixed
+state:
+  login:
+    transitions:
+    - state: home
+      "probability": 1
+      call:
+        params:
+        - type: form
+          key: username
+        - type: header
+          key: token
+  home:
+`
+	positions := config.ParsePositions([]byte(data))
+
+	tests := []struct {
+		path string
+		line int
+	}{
+		{"constants", 2},
+		{"constants.number-of-users", 3},
+		{"root-entities[0]", 5},
+		{"root-entities[0].entity", 5},
+		{"root-entities[0].cardinality", 6},
+		{"root-entities[1].entity", 7},
+		{"root-entities[1].timer.max", 7},
+		{"entities.user.initial_state", 10},
+		{"entities.user.subordinates[0].entity", 12},
+		{"entities.user.subordinates[0].timer.type", 14},
+		{"state.login.transitions[0].probability", 19},
+		{"state.login.transitions[0].call.params[1].type", 24},
+		{"state.login.transitions[0].call.params[1].key", 25},
+		{"state.home", 26},
+		{"unknown.path", 0},
+	}
+	for _, test := range tests {
+		c.Assert(positions.Line(test.path), qt.Equals, test.line, qt.Commentf("path %q", test.path))
+	}
+}
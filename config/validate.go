@@ -0,0 +1,674 @@
+// Copyright 2019 CanonicalLtd
+
+package config
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cloud-green/sisyphus/expr"
+	"github.com/cloud-green/sisyphus/jsonpath"
+)
+
+// Severity describes how serious a configuration problem is.
+type Severity string
+
+var (
+	// SeverityError marks problems that prevent the simulation
+	// from running correctly.
+	SeverityError = Severity("error")
+	// SeverityWarning marks problems that are likely mistakes,
+	// but do not prevent the simulation from running.
+	SeverityWarning = Severity("warning")
+)
+
+// Problem describes a single problem found in the configuration.
+type Problem struct {
+	// Severity holds the severity of the problem.
+	Severity Severity `json:"severity"`
+	// Path holds the YAML path of the offending value, e.g.
+	// state.login.transitions[0].on-failure
+	Path string `json:"path"`
+	// Message describes the problem.
+	Message string `json:"message"`
+}
+
+// String implements the fmt.Stringer interface.
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s", p.Path, p.Message)
+}
+
+// ValidationError holds all problems found while validating
+// the configuration.
+type ValidationError struct {
+	Problems []Problem
+}
+
+// Error implements the error interface.
+func (e *ValidationError) Error() string {
+	lines := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		lines[i] = p.String()
+	}
+	return fmt.Sprintf("%d configuration problem(s):\n%s", len(e.Problems), strings.Join(lines, "\n"))
+}
+
+// Validate performs static validation of the configuration and
+// returns a *ValidationError listing every problem found, or nil if
+// the configuration is valid.
+func (c Config) Validate() error {
+	v := &validator{config: c}
+	v.validate()
+	if len(v.problems) == 0 {
+		return nil
+	}
+	return &ValidationError{
+		Problems: v.problems,
+	}
+}
+
+type validator struct {
+	config   Config
+	problems []Problem
+}
+
+func (v *validator) addf(path, format string, args ...interface{}) {
+	v.problems = append(v.problems, Problem{
+		Severity: SeverityError,
+		Path:     path,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *validator) warnf(path, format string, args ...interface{}) {
+	v.problems = append(v.problems, Problem{
+		Severity: SeverityWarning,
+		Path:     path,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *validator) validate() {
+	switch v.config.Backend {
+	case "", HTTPCallBackend, KafkaCallBackend, NOPCallBackend:
+	default:
+		v.addf("backend", "unknown backend %q", v.config.Backend)
+	}
+	v.validateLimits("limits", v.config.Limits)
+	if v.config.CallTimeout < 0 {
+		v.addf("call-timeout", "negative timeout %v", v.config.CallTimeout)
+	}
+	if len(v.config.RootEntities) == 0 {
+		v.addf("root-entities", "no root entities defined")
+	}
+	for i, es := range v.config.RootEntities {
+		v.validateEntitySet(fmt.Sprintf("root-entities[%d]", i), es, true)
+	}
+	for _, name := range sortedKeys(v.config.Entities) {
+		v.validateEntity("entities."+name, v.config.Entities[name])
+	}
+	for _, name := range sortedKeys(v.config.States) {
+		v.validateState("state."+name, v.config.States[name])
+	}
+	for i, t := range v.config.Thresholds {
+		v.validateThreshold(fmt.Sprintf("thresholds[%d]", i), t)
+	}
+	for i, l := range v.config.RateLimits {
+		v.validateRateLimit(fmt.Sprintf("rate-limits[%d]", i), l)
+	}
+}
+
+func (v *validator) validateLimits(path string, l Limits) {
+	if l.Duration < 0 {
+		v.addf(path+".duration", "negative duration %v", l.Duration)
+	}
+	if l.MaxTransitions < 0 {
+		v.addf(path+".max-transitions", "negative number of transitions %d", l.MaxTransitions)
+	}
+	if l.MaxCalls < 0 {
+		v.addf(path+".max-calls", "negative number of calls %d", l.MaxCalls)
+	}
+	if l.GracePeriod < 0 {
+		v.addf(path+".grace-period", "negative duration %v", l.GracePeriod)
+	}
+}
+
+func (v *validator) validateThreshold(path string, t Threshold) {
+	if t.URL != "" {
+		if _, err := regexp.Compile(t.URL); err != nil {
+			v.addf(path+".url", "invalid regular expression: %v", err)
+		}
+	}
+	switch t.Metric {
+	case LatencyThresholdMetric:
+		if t.Percentile <= 0 || t.Percentile > 100 {
+			v.addf(path+".percentile", "percentile %v not in range (0, 100]", t.Percentile)
+		}
+		if t.MaxLatency <= 0 {
+			v.addf(path+".max-latency", "max-latency not specified")
+		}
+	case ErrorRateThresholdMetric:
+		if t.MaxErrorRate < 0 || t.MaxErrorRate > 1 {
+			v.addf(path+".max-error-rate", "error rate %v not in range [0, 1]", t.MaxErrorRate)
+		}
+	case TransitionsThresholdMetric:
+		if t.State == "" {
+			v.addf(path+".state", "state not specified")
+		} else if _, ok := v.config.States[t.State]; !ok {
+			v.addf(path+".state", "unknown state %q", t.State)
+		}
+		if t.MinCount <= 0 {
+			v.addf(path+".min-count", "min-count not specified")
+		}
+	case "":
+		v.addf(path+".metric", "metric not specified")
+	default:
+		v.addf(path+".metric", "unknown metric %q", t.Metric)
+	}
+}
+
+func (v *validator) validateRateLimit(path string, l RateLimit) {
+	if l.URL != "" {
+		if _, err := regexp.Compile(l.URL); err != nil {
+			v.addf(path+".url", "invalid regular expression: %v", err)
+		}
+	}
+	if l.Rate < 0 {
+		v.addf(path+".rate", "negative rate %v", l.Rate)
+	}
+	if l.Burst < 0 {
+		v.addf(path+".burst", "negative burst %d", l.Burst)
+	}
+	if l.MaxInFlight < 0 {
+		v.addf(path+".max-in-flight", "negative number of calls %d", l.MaxInFlight)
+	}
+	if l.Rate == 0 && l.MaxInFlight == 0 {
+		v.addf(path, "neither rate nor max-in-flight specified")
+	}
+	switch l.OnLimit {
+	case "", WaitLimitAction, SkipLimitAction, FailLimitAction:
+	default:
+		v.addf(path+".on-limit", "unknown action %q", l.OnLimit)
+	}
+}
+
+func (v *validator) validateEntitySet(path string, es EntitySet, root bool) {
+	if es.Entity == "" {
+		v.addf(path+".entity", "entity not specified")
+	} else if _, ok := v.config.Entities[es.Entity]; !ok {
+		v.addf(path+".entity", "unknown entity %q", es.Entity)
+	}
+	if es.Cardinality != "" {
+		if n, err := strconv.Atoi(es.Cardinality); err == nil {
+			if n < 0 {
+				v.addf(path+".cardinality", "negative cardinality %d", n)
+			}
+		} else if root {
+			// root entity sets only have access to simulation
+			// constants.
+			value, ok := v.config.Constants[es.Cardinality]
+			if !ok {
+				v.addf(path+".cardinality", "unknown constant %q", es.Cardinality)
+			} else if !isInteger(value) {
+				v.addf(path+".cardinality", "constant %q is not an integer", es.Cardinality)
+			}
+		}
+	}
+	v.validateTimer(path+".timer", es.Timer)
+	for i, stage := range es.Stages {
+		spath := fmt.Sprintf("%s.stages[%d]", path, i)
+		if stage.Duration < 0 {
+			v.addf(spath+".duration", "negative duration %v", stage.Duration)
+		}
+		if stage.Target < 0 {
+			v.addf(spath+".target", "negative target %d", stage.Target)
+		}
+	}
+}
+
+func (v *validator) validateEntity(path string, e Entity) {
+	if e.InitialState != "" {
+		if _, ok := v.config.States[e.InitialState]; !ok {
+			v.addf(path+".initial_state", "unknown state %q", e.InitialState)
+		}
+	}
+	for _, name := range sortedKeys(e.Attributes) {
+		v.validateAttribute(path+".attributes."+name, e.Attributes[name])
+	}
+	for i, es := range e.Subordinates {
+		v.validateEntitySet(fmt.Sprintf("%s.subordinates[%d]", path, i), es, false)
+	}
+	v.validateTimer(path+".lifetime", e.Lifetime)
+	if e.MaxTransitions < 0 {
+		v.addf(path+".max-transitions", "negative number of transitions %d", e.MaxTransitions)
+	}
+	switch e.Session.Scope {
+	case "", EntitySession, ParentSession, SharedSession:
+	default:
+		v.addf(path+".session.scope", "unknown session scope %q", e.Session.Scope)
+	}
+	if e.OnExpire != nil {
+		v.validateTransition(path+".on-expire", *e.OnExpire)
+	}
+}
+
+func (v *validator) validateState(path string, s State) {
+	for _, name := range sortedKeys(s.Attributes) {
+		v.validateAttribute(path+".attributes."+name, s.Attributes[name])
+	}
+	v.validateTimer(path+".timer", s.Timer)
+	sum := 0.0
+	// the sum of weights depending on attributes is only known
+	// when the simulation runs
+	dynamic := false
+	for i, t := range s.Transitions {
+		tpath := fmt.Sprintf("%s.transitions[%d]", path, i)
+		v.validateTransition(tpath, t)
+		if w, ok := t.Probability.Value(); !ok {
+			dynamic = true
+			v.validateExpr(tpath+".probability", string(t.Probability))
+		} else if w < 0 {
+			v.addf(tpath+".probability", "negative transition probability %v", w)
+		} else {
+			sum += w
+		}
+	}
+	if len(s.Transitions) > 0 && sum == 0 && !dynamic {
+		v.addf(path+".transitions", "sum of transition probabilities is 0")
+	}
+	if s.Fallback != nil {
+		v.validateTransition(path+".fallback", *s.Fallback)
+	}
+}
+
+func (v *validator) validateTransition(path string, t Transition) {
+	if t.State == "" {
+		v.addf(path+".state", "state not specified")
+	} else if _, ok := v.config.States[t.State]; !ok {
+		v.addf(path+".state", "unknown state %q", t.State)
+	}
+	if t.OnFailure != "" {
+		if _, ok := v.config.States[t.OnFailure]; !ok {
+			v.addf(path+".on-failure", "unknown state %q", t.OnFailure)
+		}
+	}
+	if t.OnTimeout != "" {
+		if _, ok := v.config.States[t.OnTimeout]; !ok {
+			v.addf(path+".on-timeout", "unknown state %q", t.OnTimeout)
+		}
+	}
+	if t.When != "" {
+		v.validateExpr(path+".when", t.When)
+	}
+	v.validateCall(path+".call", t.Call)
+	for j, c := range t.Calls {
+		cpath := fmt.Sprintf("%s.calls[%d]", path, j)
+		if c.IsEmpty() {
+			v.addf(cpath, "empty call")
+		}
+		v.validateCall(cpath, c)
+	}
+}
+
+func (v *validator) validateCall(path string, c Call) {
+	if len(c.Parallel) > 0 && (c.Method != "" || c.URL != "" || len(c.Parameters) > 0 || c.Body != nil || len(c.Results) > 0) {
+		v.addf(path+".parallel", "parallel calls cannot be combined with a request")
+	}
+	for j, p := range c.Parameters {
+		v.validateCallParameter(fmt.Sprintf("%s.params[%d]", path, j), p)
+	}
+	if c.Body != nil {
+		v.validateBody(path+".body", *c.Body)
+		if c.Body.Type == TextBody || c.Body.Type == BinaryBody {
+			for j, p := range c.Parameters {
+				if p.Type == BodyCallParameterType {
+					v.addf(fmt.Sprintf("%s.params[%d].type", path, j), "body parameters not supported in %s bodies", c.Body.Type)
+				}
+			}
+		}
+	}
+	for j, r := range c.Results {
+		rpath := fmt.Sprintf("%s.results[%d]", path, j)
+		if r.Expr != "" {
+			v.validateExpr(rpath+".expr", r.Expr)
+			continue
+		}
+		switch r.Source {
+		case "", BodyResultSource:
+			if r.Key == "" {
+				v.addf(rpath+".key", "key not specified")
+			} else if _, err := jsonpath.Parse(r.Key); err != nil {
+				v.addf(rpath+".key", "invalid path: %v", err)
+			}
+		case HeaderResultSource, CookieResultSource:
+			if r.Key == "" {
+				v.addf(rpath+".key", "key not specified")
+			}
+		case StatusResultSource:
+		case RawBodyResultSource:
+			if r.Key == "" {
+				v.addf(rpath+".key", "key not specified")
+			} else if _, err := regexp.Compile(r.Key); err != nil {
+				v.addf(rpath+".key", "invalid regular expression: %v", err)
+			}
+		default:
+			v.addf(rpath+".source", "unknown result source %q", r.Source)
+		}
+	}
+	if c.Timeout < 0 {
+		v.addf(path+".timeout", "negative timeout %v", c.Timeout)
+	}
+	if c.Retry != nil {
+		v.validateRetry(path+".retry", *c.Retry)
+	}
+	if c.Expect != nil {
+		v.validateExpect(path+".expect", *c.Expect)
+	}
+	for j, pc := range c.Parallel {
+		ppath := fmt.Sprintf("%s.parallel[%d]", path, j)
+		if pc.IsEmpty() {
+			v.addf(ppath, "empty call")
+		}
+		v.validateCall(ppath, pc)
+	}
+}
+
+// retryCauses holds the error causes on which calls may be retried.
+var retryCauses = map[string]bool{
+	"timeout":    true,
+	"send-error": true,
+	"throttled":  true,
+	"expect":     true,
+	"other":      true,
+}
+
+var statusPattern = regexp.MustCompile(`^[1-5]([0-9][0-9]|xx)$`)
+
+func (v *validator) validateRetry(path string, r Retry) {
+	if r.MaxAttempts < 1 {
+		v.addf(path+".max-attempts", "max-attempts must be at least 1")
+	}
+	switch r.Backoff {
+	case "", FixedBackoff, ExponentialBackoff:
+	default:
+		v.addf(path+".backoff", "unknown backoff %q", r.Backoff)
+	}
+	if r.Delay < 0 {
+		v.addf(path+".delay", "negative delay %v", r.Delay)
+	}
+	if r.MaxDelay < 0 {
+		v.addf(path+".max-delay", "negative delay %v", r.MaxDelay)
+	} else if r.MaxDelay > 0 && r.MaxDelay < r.Delay {
+		v.addf(path+".max-delay", "max-delay less than delay")
+	}
+	if r.Multiplier != 0 && r.Multiplier < 1 {
+		v.addf(path+".multiplier", "multiplier must be at least 1")
+	}
+	if r.Jitter < 0 || r.Jitter > 1 {
+		v.addf(path+".jitter", "jitter must be between 0 and 1")
+	}
+	for i, on := range r.On {
+		if !retryCauses[on] && !statusPattern.MatchString(on) && !strings.HasPrefix(on, "expect:") {
+			v.addf(fmt.Sprintf("%s.on[%d]", path, i), "unknown error %q", on)
+		}
+	}
+}
+
+func (v *validator) validateExpect(path string, e Expect) {
+	for i, s := range e.Status {
+		if _, _, ok := parseStatus(s); !ok {
+			v.addf(fmt.Sprintf("%s.status[%d]", path, i), "invalid status %q", s)
+		}
+	}
+	for i, a := range e.Body {
+		apath := fmt.Sprintf("%s.body[%d]", path, i)
+		if a.Key != "" {
+			if _, err := jsonpath.Parse(a.Key); err != nil {
+				v.addf(apath+".key", "invalid path: %v", err)
+			}
+		}
+		v.validateAssertion(apath, a)
+	}
+	for i, a := range e.Headers {
+		apath := fmt.Sprintf("%s.headers[%d]", path, i)
+		if a.Key == "" {
+			v.addf(apath+".key", "key not specified")
+		}
+		if a.Schema != nil {
+			v.addf(apath+".schema", "schema not supported for headers")
+		}
+		v.validateAssertion(apath, a)
+	}
+	if e.MaxLatency < 0 {
+		v.addf(path+".max-latency", "negative latency %v", e.MaxLatency)
+	}
+}
+
+func (v *validator) validateAssertion(path string, a Assertion) {
+	switch a.Equals.(type) {
+	case nil, string, int, int64, float64, bool:
+	default:
+		v.addf(path+".equals", "equals must be a string, number or boolean")
+	}
+	if _, err := regexp.Compile(a.Matches); err != nil {
+		v.addf(path+".matches", "invalid regular expression: %v", err)
+	}
+	if a.Schema != nil {
+		v.validateSchema(path+".schema", *a.Schema)
+	}
+}
+
+// schemaTypes holds the types supported by schemas.
+var schemaTypes = map[string]bool{
+	"object":  true,
+	"array":   true,
+	"string":  true,
+	"number":  true,
+	"integer": true,
+	"boolean": true,
+	"null":    true,
+}
+
+func (v *validator) validateSchema(path string, s Schema) {
+	if s.Type != "" && !schemaTypes[s.Type] {
+		v.addf(path+".type", "unknown type %q", s.Type)
+	}
+	for _, name := range sortedKeys(s.Properties) {
+		if p := s.Properties[name]; p != nil {
+			v.validateSchema(path+".properties."+name, *p)
+		}
+	}
+	if s.Items != nil {
+		v.validateSchema(path+".items", *s.Items)
+	}
+}
+
+func (v *validator) validateExpr(path, s string) {
+	if s == "" {
+		v.addf(path, "expression not specified")
+	} else if _, err := expr.Parse(s); err != nil {
+		v.addf(path, "invalid expression: %v", err)
+	}
+}
+
+func (v *validator) validateBody(path string, b Body) {
+	if b.Template != nil && b.File != "" {
+		v.addf(path+".file", "template and file cannot both be specified")
+	}
+	switch b.Type {
+	case "", JSONBody:
+	case FormBody, MultipartBody:
+		switch b.Template.(type) {
+		case nil, map[interface{}]interface{}:
+		default:
+			v.addf(path+".template", "template must be an object")
+		}
+	case TextBody:
+		switch b.Template.(type) {
+		case nil, string:
+		default:
+			v.addf(path+".template", "template must be a string")
+		}
+	case BinaryBody:
+		if b.Template != nil {
+			v.addf(path+".template", "template not supported in binary bodies")
+		}
+	default:
+		v.addf(path+".type", "unknown body type %q", b.Type)
+	}
+	if b.Size < 0 {
+		v.addf(path+".size", "negative size %d", b.Size)
+	}
+	if len(b.Files) > 0 && b.Type != MultipartBody {
+		v.addf(path+".files", "files only supported in multipart bodies")
+	}
+	for i, f := range b.Files {
+		fpath := fmt.Sprintf("%s.files[%d]", path, i)
+		if f.Field == "" {
+			v.addf(fpath+".field", "field not specified")
+		}
+		if f.Size < 0 {
+			v.addf(fpath+".size", "negative size %d", f.Size)
+		}
+	}
+}
+
+func (v *validator) validateCallParameter(path string, p CallParameter) {
+	switch p.Type {
+	case BodyCallParameterType, FormCallParameterType, HeaderCallParameterType:
+	default:
+		v.addf(path+".type", "unknown parameter type %q", p.Type)
+	}
+	if p.Key == "" {
+		v.addf(path+".key", "key not specified")
+	}
+}
+
+func (v *validator) validateTimer(path string, t Timer) {
+	switch t.Type {
+	case "":
+	case FixedTimer:
+		if t.Interval < 0 {
+			v.addf(path+".interval", "negative interval %v", t.Interval)
+		}
+	case RandomTimer:
+		if t.Min < 0 {
+			v.addf(path+".min", "negative duration %v", t.Min)
+		}
+		if t.Max <= t.Min {
+			v.addf(path+".max", "max (%v) must be greater than min (%v)", t.Max, t.Min)
+		}
+	case ExponentialTimer:
+		if t.Rate <= 0 {
+			v.addf(path+".rate", "rate must be positive")
+		}
+	case LogNormalTimer:
+		if t.Median <= 0 {
+			v.addf(path+".median", "median must be positive")
+		}
+		if t.Sigma < 0 {
+			v.addf(path+".sigma", "negative sigma %v", t.Sigma)
+		}
+	case ParetoTimer:
+		if t.Min <= 0 {
+			v.addf(path+".min", "min must be positive")
+		}
+		if t.Alpha <= 0 {
+			v.addf(path+".alpha", "alpha must be positive")
+		}
+		if t.Max != 0 && t.Max <= t.Min {
+			v.addf(path+".max", "max (%v) must be greater than min (%v)", t.Max, t.Min)
+		}
+	case DailyTimer:
+		if len(t.Rates) == 0 {
+			v.addf(path+".rates", "rates not specified")
+		}
+		sum := 0.0
+		for i, rate := range t.Rates {
+			if rate < 0 {
+				v.addf(fmt.Sprintf("%s.rates[%d]", path, i), "negative rate %v", rate)
+			} else {
+				sum += rate
+			}
+		}
+		if len(t.Rates) > 0 && sum == 0 {
+			v.addf(path+".rates", "all rates are zero")
+		}
+	case TraceTimer:
+		if t.File == "" {
+			v.addf(path+".file", "file not specified")
+		}
+	default:
+		v.addf(path+".type", "unknown timer type %q", t.Type)
+	}
+}
+
+func (v *validator) validateAttribute(path string, a Attribute) {
+	switch a.Type {
+	case ConstantIntAttributeType, ConstantStringAttributeType, RandomStringAttributeType:
+	case RandomIntAttributeType, RandomFloatAttributeType:
+		if a.Max < a.Min {
+			v.addf(path+".max", "max (%v) is less than min (%v)", a.Max, a.Min)
+		}
+	case PowerIntAttributeType, PowerFloatAttributeType:
+		if a.Max < a.Min {
+			v.addf(path+".max", "max (%v) is less than min (%v)", a.Max, a.Min)
+		}
+		if a.N == -1 {
+			v.addf(path+".n", "exponent must not be -1")
+		}
+	case NormalIntAttributeType, NormalFloatAttributeType:
+		if a.StdDev < 0 {
+			v.addf(path+".std-dev", "negative standard deviation %v", a.StdDev)
+		}
+	case RandomValueAttributeType, RandomSubsetAttributeType:
+		if len(a.Values) == 0 {
+			v.addf(path+".values", "empty list of values")
+		}
+	case ExprAttributeType:
+		v.validateExpr(path+".expr", a.Expr)
+	default:
+		v.addf(path+".type", "unknown attribute type %q", a.Type)
+	}
+}
+
+func isInteger(value interface{}) bool {
+	switch v := value.(type) {
+	case int:
+		return true
+	case string:
+		_, err := strconv.Atoi(v)
+		return err == nil
+	case float64:
+		return !math.IsInf(v, 0) && v == math.Trunc(v)
+	}
+	return false
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch m := m.(type) {
+	case map[string]Entity:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]State:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]Attribute:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]*Schema:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,781 @@
+// Copyright 2019 CanonicalLtd
+
+package config_test
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	yaml "gopkg.in/yaml.v1"
+
+	"github.com/cloud-green/sisyphus/config"
+)
+
+func TestValidate(t *testing.T) {
+	c := qt.New(t)
+
+	tests := []struct {
+		about            string
+		config           string
+		expectedProblems []config.Problem
+	}{{
+		about: "a valid configuration",
+		config: `
+constants:
+  number-of-users: 10
+root-entities:
+- entity: user
+  cardinality: number-of-users
+  timer:
+    type: random
+    min: 1ms
+    max: 10ms
+entities:
+  user:
+    initial_state: login
+    attributes:
+      username:
+        type: random_string
+state:
+  login:
+    transitions:
+    - state: done
+      probability: 1
+      on-failure: login
+      call:
+        method: GET
+        url: /login
+        params:
+        - type: form
+          attribute: username
+          key: username
+  done:
+`,
+	}, {
+		about: "unknown names",
+		config: `
+root-entities:
+- entity: admin
+- entity: user
+  cardinality: number-of-users
+entities:
+  user:
+    initial_state: logn
+    subordinates:
+    - entity: device
+state:
+  login:
+    transitions:
+    - state: dne
+      probability: 1
+      on-failure: eror
+`,
+		expectedProblems: []config.Problem{{
+			Path:    "root-entities[0].entity",
+			Message: `unknown entity "admin"`,
+		}, {
+			Path:    "root-entities[1].cardinality",
+			Message: `unknown constant "number-of-users"`,
+		}, {
+			Path:    "entities.user.initial_state",
+			Message: `unknown state "logn"`,
+		}, {
+			Path:    "entities.user.subordinates[0].entity",
+			Message: `unknown entity "device"`,
+		}, {
+			Path:    "state.login.transitions[0].state",
+			Message: `unknown state "dne"`,
+		}, {
+			Path:    "state.login.transitions[0].on-failure",
+			Message: `unknown state "eror"`,
+		}},
+	}, {
+		about: "invalid values",
+		config: `
+backend: grpc
+root-entities:
+- entity: user
+  cardinality: "-1"
+  timer:
+    type: random
+    min: 10ms
+    max: 10ms
+entities:
+  user:
+    attributes:
+      a1:
+        type: random_value
+      a2:
+        type: constant
+state:
+  s1:
+    timer:
+      type: periodic
+    transitions:
+    - state: s1
+      probability: -1
+    - state: s1
+      call:
+        params:
+        - type: query
+          key: username
+`,
+		expectedProblems: []config.Problem{{
+			Path:    "backend",
+			Message: `unknown backend "grpc"`,
+		}, {
+			Path:    "root-entities[0].cardinality",
+			Message: `negative cardinality -1`,
+		}, {
+			Path:    "root-entities[0].timer.max",
+			Message: `max \(10ms\) must be greater than min \(10ms\)`,
+		}, {
+			Path:    "entities.user.attributes.a1.values",
+			Message: `empty list of values`,
+		}, {
+			Path:    "entities.user.attributes.a2.type",
+			Message: `unknown attribute type "constant"`,
+		}, {
+			Path:    "state.s1.timer.type",
+			Message: `unknown timer type "periodic"`,
+		}, {
+			Path:    "state.s1.transitions[0].probability",
+			Message: `negative transition probability -1`,
+		}, {
+			Path:    "state.s1.transitions[1].call.params[0].type",
+			Message: `unknown parameter type "query"`,
+		}, {
+			Path:    "state.s1.transitions",
+			Message: `sum of transition probabilities is 0`,
+		}},
+	}, {
+		about: "invalid thresholds",
+		config: `
+root-entities:
+- entity: user
+entities:
+  user:
+    initial_state: login
+state:
+  login:
+    transitions:
+    - state: done
+      probability: 1
+  done:
+thresholds:
+- metric: latency
+  url: /login(
+  percentile: 120
+- metric: error-rate
+  max-error-rate: 5
+- metric: transitions
+  state: checkout
+- metric: throughput
+- url: /login
+`,
+		expectedProblems: []config.Problem{{
+			Path:    "thresholds[0].url",
+			Message: `invalid regular expression: .*`,
+		}, {
+			Path:    "thresholds[0].percentile",
+			Message: `percentile 120 not in range \(0, 100\]`,
+		}, {
+			Path:    "thresholds[0].max-latency",
+			Message: `max-latency not specified`,
+		}, {
+			Path:    "thresholds[1].max-error-rate",
+			Message: `error rate 5 not in range \[0, 1\]`,
+		}, {
+			Path:    "thresholds[2].state",
+			Message: `unknown state "checkout"`,
+		}, {
+			Path:    "thresholds[2].min-count",
+			Message: `min-count not specified`,
+		}, {
+			Path:    "thresholds[3].metric",
+			Message: `unknown metric "throughput"`,
+		}, {
+			Path:    "thresholds[4].metric",
+			Message: `metric not specified`,
+		}},
+	}, {
+		about: "invalid rate limits",
+		config: `
+root-entities:
+- entity: user
+entities:
+  user:
+state:
+rate-limits:
+- rate: 10
+- url: /login(
+  rate: -1
+  burst: -1
+  max-in-flight: -1
+- method: GET
+- max-in-flight: 10
+  on-limit: drop
+`,
+		expectedProblems: []config.Problem{{
+			Path:    "rate-limits[1].url",
+			Message: `invalid regular expression: .*`,
+		}, {
+			Path:    "rate-limits[1].rate",
+			Message: `negative rate -1`,
+		}, {
+			Path:    "rate-limits[1].burst",
+			Message: `negative burst -1`,
+		}, {
+			Path:    "rate-limits[1].max-in-flight",
+			Message: `negative number of calls -1`,
+		}, {
+			Path:    "rate-limits[2]",
+			Message: `neither rate nor max-in-flight specified`,
+		}, {
+			Path:    "rate-limits[3].on-limit",
+			Message: `unknown action "drop"`,
+		}},
+	}, {
+		about: "invalid timers",
+		config: `
+root-entities:
+- entity: user
+  timer:
+    type: exponential
+- entity: user
+  timer:
+    type: daily
+    rates: [0, -1]
+entities:
+  user:
+    subordinates:
+    - entity: user
+      timer:
+        type: trace
+state:
+  s1:
+    timer:
+      type: lognormal
+      sigma: -1
+  s2:
+    timer:
+      type: pareto
+      min: 1s
+      max: 1s
+`,
+		expectedProblems: []config.Problem{{
+			Path:    "root-entities[0].timer.rate",
+			Message: `rate must be positive`,
+		}, {
+			Path:    "root-entities[1].timer.rates[1]",
+			Message: `negative rate -1`,
+		}, {
+			Path:    "root-entities[1].timer.rates",
+			Message: `all rates are zero`,
+		}, {
+			Path:    "entities.user.subordinates[0].timer.file",
+			Message: `file not specified`,
+		}, {
+			Path:    "state.s1.timer.median",
+			Message: `median must be positive`,
+		}, {
+			Path:    "state.s1.timer.sigma",
+			Message: `negative sigma -1`,
+		}, {
+			Path:    "state.s2.timer.alpha",
+			Message: `alpha must be positive`,
+		}, {
+			Path:    "state.s2.timer.max",
+			Message: `max \(1s\) must be greater than min \(1s\)`,
+		}},
+	}, {
+		about: "invalid entity expiry",
+		config: `
+root-entities:
+- entity: user
+entities:
+  user:
+    lifetime:
+      type: fixed
+      interval: -1m
+    max-transitions: -1
+    on-expire:
+      state: logged-out
+      on-failure: error
+      call:
+        method: POST
+        params:
+        - type: cookie
+          key: session
+state:
+`,
+		expectedProblems: []config.Problem{{
+			Path:    "entities.user.lifetime.interval",
+			Message: `negative interval -1m0s`,
+		}, {
+			Path:    "entities.user.max-transitions",
+			Message: `negative number of transitions -1`,
+		}, {
+			Path:    "entities.user.on-expire.state",
+			Message: `unknown state "logged-out"`,
+		}, {
+			Path:    "entities.user.on-expire.on-failure",
+			Message: `unknown state "error"`,
+		}, {
+			Path:    "entities.user.on-expire.call.params[0].type",
+			Message: `unknown parameter type "cookie"`,
+		}},
+	}, {
+		about: "invalid session scope",
+		config: `
+root-entities:
+- entity: user
+entities:
+  user:
+    session:
+      scope: user
+state:
+`,
+		expectedProblems: []config.Problem{{
+			Path:    "entities.user.session.scope",
+			Message: `unknown session scope "user"`,
+		}},
+	}, {
+		about: "invalid expressions",
+		config: `
+root-entities:
+- entity: user
+entities:
+  user:
+    initial_state: s1
+    attributes:
+      a:
+        type: expr
+      b:
+        type: expr
+        expr: "round({a}"
+state:
+  s1:
+    transitions:
+    - state: s1
+      probability: 1
+      when: "{a} >"
+    - state: s1
+      probability: "{a} *"
+      call:
+        results:
+        - attribute: c
+          expr: "{a} {b}"
+    fallback:
+      state: s3
+  s2:
+    transitions:
+    - state: s1
+      probability: "{a} * 0.3"
+`,
+		expectedProblems: []config.Problem{{
+			Path:    "entities.user.attributes.a.expr",
+			Message: `expression not specified`,
+		}, {
+			Path:    "entities.user.attributes.b.expr",
+			Message: `invalid expression: unexpected end of expression`,
+		}, {
+			Path:    "state.s1.transitions[0].when",
+			Message: `invalid expression: unexpected end of expression`,
+		}, {
+			Path:    "state.s1.transitions[1].call.results[0].expr",
+			Message: `invalid expression: unexpected "\{b\}" at position 4`,
+		}, {
+			Path:    "state.s1.transitions[1].probability",
+			Message: `invalid expression: unexpected end of expression`,
+		}, {
+			Path:    "state.s1.fallback.state",
+			Message: `unknown state "s3"`,
+		}},
+	}, {
+		about: "invalid calls",
+		config: `
+root-entities:
+- entity: user
+entities:
+  user:
+    initial_state: s1
+state:
+  s1:
+    transitions:
+    - state: s1
+      probability: 1
+      calls:
+      - url: /page
+      - {}
+      - url: /assets
+        parallel:
+        - url: /style.css
+        - params:
+          - type: query
+            key: q
+      - parallel:
+        - url: /script.js
+        - {}
+`,
+		expectedProblems: []config.Problem{{
+			Path:    "state.s1.transitions[0].calls[1]",
+			Message: `empty call`,
+		}, {
+			Path:    "state.s1.transitions[0].calls[2].parallel",
+			Message: `parallel calls cannot be combined with a request`,
+		}, {
+			Path:    "state.s1.transitions[0].calls[2].parallel[1].params[0].type",
+			Message: `unknown parameter type "query"`,
+		}, {
+			Path:    "state.s1.transitions[0].calls[3].parallel[1]",
+			Message: `empty call`,
+		}},
+	}, {
+		about: "invalid retry policies",
+		config: `
+root-entities:
+- entity: user
+entities:
+  user:
+    initial_state: s1
+state:
+  s1:
+    transitions:
+    - state: s1
+      probability: 1
+      call:
+        url: /login
+        retry:
+          backoff: linear
+          delay: 2s
+          max-delay: 1s
+          multiplier: 0.5
+          jitter: 1.5
+          on: ["503", 5xx, 6xx, timeout, reset]
+`,
+		expectedProblems: []config.Problem{{
+			Path:    "state.s1.transitions[0].call.retry.max-attempts",
+			Message: `max-attempts must be at least 1`,
+		}, {
+			Path:    "state.s1.transitions[0].call.retry.backoff",
+			Message: `unknown backoff "linear"`,
+		}, {
+			Path:    "state.s1.transitions[0].call.retry.max-delay",
+			Message: `max-delay less than delay`,
+		}, {
+			Path:    "state.s1.transitions[0].call.retry.multiplier",
+			Message: `multiplier must be at least 1`,
+		}, {
+			Path:    "state.s1.transitions[0].call.retry.jitter",
+			Message: `jitter must be between 0 and 1`,
+		}, {
+			Path:    "state.s1.transitions[0].call.retry.on[2]",
+			Message: `unknown error "6xx"`,
+		}, {
+			Path:    "state.s1.transitions[0].call.retry.on[4]",
+			Message: `unknown error "reset"`,
+		}},
+	}, {
+		about: "invalid timeouts",
+		config: `
+call-timeout: -1s
+root-entities:
+- entity: user
+entities:
+  user:
+    initial_state: s1
+state:
+  s1:
+    transitions:
+    - state: s1
+      probability: 1
+      on-timeout: s2
+      call:
+        url: /login
+        timeout: -10ms
+`,
+		expectedProblems: []config.Problem{{
+			Path:    "call-timeout",
+			Message: `negative timeout -1s`,
+		}, {
+			Path:    "state.s1.transitions[0].on-timeout",
+			Message: `unknown state "s2"`,
+		}, {
+			Path:    "state.s1.transitions[0].call.timeout",
+			Message: `negative timeout -10ms`,
+		}},
+	}, {
+		about: "invalid result paths",
+		config: `
+root-entities:
+- entity: user
+entities:
+  user:
+    initial_state: s1
+state:
+  s1:
+    transitions:
+    - state: s1
+      probability: 1
+      call:
+        url: /login
+        results:
+        - attribute: token
+        - key: data..token
+          attribute: token
+        - key: items[0
+          attribute: item
+          optional: true
+`,
+		expectedProblems: []config.Problem{{
+			Path:    "state.s1.transitions[0].call.results[0].key",
+			Message: `key not specified`,
+		}, {
+			Path:    "state.s1.transitions[0].call.results[1].key",
+			Message: `invalid path: expected field name at position 5`,
+		}, {
+			Path:    "state.s1.transitions[0].call.results[2].key",
+			Message: `invalid path: missing \] at position 5`,
+		}},
+	}, {
+		about: "invalid expectations",
+		config: `
+root-entities:
+- entity: user
+entities:
+  user:
+    initial_state: s1
+state:
+  s1:
+    transitions:
+    - state: s1
+      probability: 1
+      call:
+        url: /login
+        retry:
+          max-attempts: 2
+          on: [expect, "expect:body.id", expected]
+        expect:
+          status: [201, 2xx, 300-299, 6xx]
+          body:
+          - key: data..id
+            equals: [1]
+          - matches: "(a"
+            schema:
+              type: list
+              properties:
+                id:
+                  type: int
+          headers:
+          - equals: application/json
+          - key: Content-Type
+            schema:
+              type: string
+          max-latency: -1s
+`,
+		expectedProblems: []config.Problem{{
+			Path:    "state.s1.transitions[0].call.retry.on[2]",
+			Message: `unknown error "expected"`,
+		}, {
+			Path:    "state.s1.transitions[0].call.expect.status[2]",
+			Message: `invalid status "300-299"`,
+		}, {
+			Path:    "state.s1.transitions[0].call.expect.status[3]",
+			Message: `invalid status "6xx"`,
+		}, {
+			Path:    "state.s1.transitions[0].call.expect.body[0].key",
+			Message: `invalid path: expected field name at position 5`,
+		}, {
+			Path:    "state.s1.transitions[0].call.expect.body[0].equals",
+			Message: `equals must be a string, number or boolean`,
+		}, {
+			Path:    "state.s1.transitions[0].call.expect.body[1].matches",
+			Message: `invalid regular expression: .*`,
+		}, {
+			Path:    "state.s1.transitions[0].call.expect.body[1].schema.type",
+			Message: `unknown type "list"`,
+		}, {
+			Path:    "state.s1.transitions[0].call.expect.body[1].schema.properties.id.type",
+			Message: `unknown type "int"`,
+		}, {
+			Path:    "state.s1.transitions[0].call.expect.headers[0].key",
+			Message: `key not specified`,
+		}, {
+			Path:    "state.s1.transitions[0].call.expect.headers[1].schema",
+			Message: `schema not supported for headers`,
+		}, {
+			Path:    "state.s1.transitions[0].call.expect.max-latency",
+			Message: `negative latency -1s`,
+		}},
+	}, {
+		about: "invalid bodies",
+		config: `
+root-entities:
+- entity: user
+entities:
+  user:
+    initial_state: s1
+state:
+  s1:
+    transitions:
+    - state: s1
+      probability: 1
+      calls:
+      - url: /a
+        body:
+          type: form-urlencoded
+          template: [a, b]
+          file: form.yaml
+      - url: /b
+        params:
+        - type: body
+          attribute: a
+          key: a
+        body:
+          type: binary
+          template: data
+          size: -1
+      - url: /c
+        body:
+          type: text
+          template:
+            a: b
+          files:
+          - name: file
+            size: -1
+      - url: /d
+        body:
+          type: xml
+`,
+		expectedProblems: []config.Problem{{
+			Path:    "state.s1.transitions[0].calls[0].body.file",
+			Message: `template and file cannot both be specified`,
+		}, {
+			Path:    "state.s1.transitions[0].calls[0].body.template",
+			Message: `template must be an object`,
+		}, {
+			Path:    "state.s1.transitions[0].calls[1].body.template",
+			Message: `template not supported in binary bodies`,
+		}, {
+			Path:    "state.s1.transitions[0].calls[1].body.size",
+			Message: `negative size -1`,
+		}, {
+			Path:    "state.s1.transitions[0].calls[1].params[0].type",
+			Message: `body parameters not supported in binary bodies`,
+		}, {
+			Path:    "state.s1.transitions[0].calls[2].body.template",
+			Message: `template must be a string`,
+		}, {
+			Path:    "state.s1.transitions[0].calls[2].body.files",
+			Message: `files only supported in multipart bodies`,
+		}, {
+			Path:    "state.s1.transitions[0].calls[2].body.files[0].field",
+			Message: `field not specified`,
+		}, {
+			Path:    "state.s1.transitions[0].calls[2].body.files[0].size",
+			Message: `negative size -1`,
+		}, {
+			Path:    "state.s1.transitions[0].calls[3].body.type",
+			Message: `unknown body type "xml"`,
+		}},
+	}, {
+		about: "invalid result sources",
+		config: `
+root-entities:
+- entity: user
+entities:
+  user:
+    initial_state: s1
+state:
+  s1:
+    transitions:
+    - state: s1
+      probability: 1
+      call:
+        url: /login
+        results:
+        - source: header
+          attribute: location
+        - source: raw-body
+          key: "id=(\\d+"
+          attribute: id
+        - source: trailer
+          key: etag
+          attribute: etag
+        - source: status
+          attribute: status
+`,
+		expectedProblems: []config.Problem{{
+			Path:    "state.s1.transitions[0].call.results[0].key",
+			Message: `key not specified`,
+		}, {
+			Path:    "state.s1.transitions[0].call.results[1].key",
+			Message: `invalid regular expression: .*missing closing \).*`,
+		}, {
+			Path:    "state.s1.transitions[0].call.results[2].source",
+			Message: `unknown result source "trailer"`,
+		}},
+	}, {
+		about: "invalid stages",
+		config: `
+root-entities:
+- entity: user
+  stages:
+  - duration: 10m
+    target: 100
+  - duration: -1m
+    target: -1
+entities:
+  user:
+state:
+`,
+		expectedProblems: []config.Problem{{
+			Path:    "root-entities[0].stages[1].duration",
+			Message: `negative duration -1m0s`,
+		}, {
+			Path:    "root-entities[0].stages[1].target",
+			Message: `negative target -1`,
+		}},
+	}, {
+		about: "float cardinality constants",
+		config: `
+constants:
+  users: 2.0
+  devices: 2.5
+root-entities:
+- entity: user
+  cardinality: users
+- entity: user
+  cardinality: devices
+entities:
+  user:
+state:
+`,
+		expectedProblems: []config.Problem{{
+			Path:    "root-entities[1].cardinality",
+			Message: `constant "devices" is not an integer`,
+		}},
+	}}
+
+	for i, test := range tests {
+		c.Logf("running test %d: %s", i, test.about)
+		var cfg config.Config
+		err := yaml.Unmarshal([]byte(test.config), &cfg)
+		c.Assert(err, qt.IsNil)
+
+		err = cfg.Validate()
+		if len(test.expectedProblems) == 0 {
+			c.Assert(err, qt.IsNil)
+			continue
+		}
+		c.Assert(err, qt.Not(qt.IsNil))
+		verr, ok := err.(*config.ValidationError)
+		c.Assert(ok, qt.Equals, true)
+		c.Assert(verr.Problems, qt.HasLen, len(test.expectedProblems))
+		for j, p := range verr.Problems {
+			c.Assert(p.Path, qt.Equals, test.expectedProblems[j].Path)
+			c.Assert(p.Message, qt.Matches, test.expectedProblems[j].Message)
+		}
+	}
+}
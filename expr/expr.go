@@ -0,0 +1,256 @@
+// Copyright 2019 CanonicalLtd
+
+// Package expr implements the expressions used in simulation
+// configurations, e.g. to guard transitions or compute attributes.
+//
+// Expressions reference attributes by name in braces, as URL
+// placeholders do, e.g. {cart-size} > 0 && {error} == nil. Referencing
+// an attribute that is not set yields nil. Strings holding numbers,
+// as set from call results, are treated as numbers when compared or
+// combined with numbers. Expressions support number,
+// string (in single or double quotes), boolean and nil literals,
+// arithmetic (+, -, *, /, %), string concatenation (+), comparisons
+// (==, !=, <, <=, >, >=), boolean logic (&&, ||, !), parentheses and
+// the following functions:
+//   - exists(x): true if x is not nil
+//   - default(x, y): x if it is not nil, y otherwise
+//   - if(c, x, y): x if c is true, y otherwise; only the chosen
+//     value is evaluated
+//   - len(x): the length of a string or list
+//   - upper(s), lower(s), trim(s): the string converted to upper
+//     case, lower case or without surrounding white space
+//   - contains(s, x): true if the string s contains x or the list s
+//     contains an element equal to x
+//   - replace(s, old, new): s with all occurrences of old replaced
+//     by new
+//   - string(x), number(x): x converted to a string or a number
+//   - floor(x), round(x): x rounded down or to the nearest integer
+//   - now(): the current time
+//   - unix(t): the number of seconds elapsed since January 1, 1970 UTC
+//   - format_time(t, layout): the time formatted according to the
+//     layout, as defined by the time package, e.g. "2006-01-02"
+package expr
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// Env holds the environment in which expressions are evaluated.
+type Env struct {
+	// Attributes holds the values of the attributes referenced
+	// by expressions.
+	Attributes map[string]interface{}
+	// Now returns the current time. If nil, time.Now is used.
+	Now func() time.Time
+}
+
+// now returns the current time.
+func (env *Env) now() time.Time {
+	if env.Now == nil {
+		return time.Now()
+	}
+	return env.Now()
+}
+
+// Expr holds a parsed expression.
+type Expr struct {
+	src        string
+	root       node
+	attributes []string
+}
+
+// Parse parses the expression.
+func Parse(s string) (*Expr, error) {
+	p := &parser{
+		lexer: lexer{src: s},
+		attrs: make(map[string]bool),
+	}
+	if err := p.next(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	root, err := p.parseExpr(0)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if p.tok.kind != eofToken {
+		return nil, p.unexpected()
+	}
+	e := &Expr{
+		src:  s,
+		root: root,
+	}
+	for name := range p.attrs {
+		e.attributes = append(e.attributes, name)
+	}
+	sort.Strings(e.attributes)
+	return e, nil
+}
+
+// String returns the source of the expression.
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Attributes returns the names of the attributes referenced by the
+// expression in alphabetical order.
+func (e *Expr) Attributes() []string {
+	return e.attributes
+}
+
+// Eval evaluates the expression in the environment.
+func (e *Expr) Eval(env Env) (interface{}, error) {
+	v, err := e.root.eval(&env)
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot evaluate %q", e.src)
+	}
+	return v, nil
+}
+
+// Bool evaluates the expression in the environment and reports
+// whether the result is true. Nil, false, zero numbers and empty
+// strings and lists are false, all other values are true.
+func (e *Expr) Bool(env Env) (bool, error) {
+	v, err := e.Eval(env)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	return truth(v), nil
+}
+
+// Float evaluates the expression in the environment and returns
+// the resulting number. Strings holding numbers are converted.
+func (e *Expr) Float(env Env) (float64, error) {
+	v, err := e.Eval(env)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	f, ok := number(v)
+	if !ok {
+		f, ok = parseNumber(v)
+	}
+	if !ok {
+		return 0, errors.Errorf("%q evaluates to %s, not a number", e.src, describe(v))
+	}
+	return f, nil
+}
+
+// node is a node of the syntax tree of an expression.
+type node interface {
+	eval(env *Env) (interface{}, error)
+}
+
+// literal is a constant value.
+type literal struct {
+	value interface{}
+}
+
+func (n literal) eval(*Env) (interface{}, error) {
+	return n.value, nil
+}
+
+// attribute references an attribute.
+type attribute struct {
+	name string
+}
+
+func (n attribute) eval(env *Env) (interface{}, error) {
+	return env.Attributes[n.name], nil
+}
+
+// unary is a unary operation.
+type unary struct {
+	op      string
+	operand node
+}
+
+func (n unary) eval(env *Env) (interface{}, error) {
+	v, err := n.operand.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "!":
+		return !truth(v), nil
+	case "-":
+		f, ok := number(v)
+		if !ok {
+			return nil, errors.Errorf("cannot negate %s", describe(v))
+		}
+		return -f, nil
+	}
+	return nil, errors.Errorf("unknown operator %q", n.op)
+}
+
+// binary is a binary operation.
+type binary struct {
+	op          string
+	left, right node
+}
+
+func (n binary) eval(env *Env) (interface{}, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	// boolean operators short-circuit
+	switch n.op {
+	case "&&":
+		if !truth(left) {
+			return false, nil
+		}
+		right, err := n.right.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		return truth(right), nil
+	case "||":
+		if truth(left) {
+			return true, nil
+		}
+		right, err := n.right.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		return truth(right), nil
+	}
+	right, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+	case "<", "<=", ">", ">=":
+		c, err := compare(left, right)
+		if err != nil {
+			return nil, err
+		}
+		switch n.op {
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		default:
+			return c >= 0, nil
+		}
+	case "+", "-", "*", "/", "%":
+		return arithmetic(n.op, left, right)
+	}
+	return nil, errors.Errorf("unknown operator %q", n.op)
+}
+
+// describe returns a description of the value for error messages.
+func describe(v interface{}) string {
+	if v == nil {
+		return "nil"
+	}
+	return fmt.Sprintf("%T %v", v, v)
+}
@@ -0,0 +1,298 @@
+// Copyright 2019 CanonicalLtd
+
+package expr_test
+
+import (
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/cloud-green/sisyphus/expr"
+)
+
+var attributes = map[string]interface{}{
+	"cart-size": 3,
+	"total":     "12.5",
+	"name":      "alice",
+	"premium":   true,
+	"items":     []interface{}{"a", "b"},
+	"empty":     "",
+	"ratio":     0.25,
+}
+
+func TestEval(t *testing.T) {
+	c := qt.New(t)
+
+	tests := []struct {
+		about         string
+		expr          string
+		expectedValue interface{}
+		expectedError string
+	}{{
+		about:         "number",
+		expr:          "1.5e3",
+		expectedValue: 1500.0,
+	}, {
+		about:         "strings",
+		expr:          `"a\"b" == 'a"b'`,
+		expectedValue: true,
+	}, {
+		about:         "attribute",
+		expr:          "{name}",
+		expectedValue: "alice",
+	}, {
+		about:         "undefined attribute",
+		expr:          "{error}",
+		expectedValue: nil,
+	}, {
+		about:         "comparison of numbers",
+		expr:          "{cart-size} > 0",
+		expectedValue: true,
+	}, {
+		about:         "numbers held in strings",
+		expr:          "{total} >= 12.5 && {total} == 12.5",
+		expectedValue: true,
+	}, {
+		about:         "comparison of strings",
+		expr:          `{name} < "bob"`,
+		expectedValue: true,
+	}, {
+		about:         "mismatched types are not equal",
+		expr:          `{name} == 1 || {premium} == "true"`,
+		expectedValue: false,
+	}, {
+		about:         "nil",
+		expr:          "{error} == nil && {name} != nil",
+		expectedValue: true,
+	}, {
+		about:         "exists",
+		expr:          "exists({name}) && !exists({error})",
+		expectedValue: true,
+	}, {
+		about:         "precedence",
+		expr:          "false && false || true",
+		expectedValue: true,
+	}, {
+		about:         "parentheses",
+		expr:          "false && (false || true)",
+		expectedValue: false,
+	}, {
+		about:         "truth",
+		expr:          "{items} && !{empty} && {ratio} && !0",
+		expectedValue: true,
+	}, {
+		about:         "negation",
+		expr:          "-{ratio} < 0",
+		expectedValue: true,
+	}, {
+		about:         "short circuit",
+		expr:          "exists({error}) && {error} > 1",
+		expectedValue: false,
+	}, {
+		about:         "arithmetic",
+		expr:          "1 + 2 * 3 - 8 / 4 - 7 % 4",
+		expectedValue: 2.0,
+	}, {
+		about:         "arithmetic on attributes",
+		expr:          "{total} * {ratio} + {cart-size}",
+		expectedValue: 6.125,
+	}, {
+		about:         "arithmetic precedence over comparison",
+		expr:          "{cart-size} * 2 > 5",
+		expectedValue: true,
+	}, {
+		about:         "division by zero",
+		expr:          "1 / ({cart-size} - 3)",
+		expectedError: `cannot evaluate "1 / \({cart-size} - 3\)": division by zero`,
+	}, {
+		about:         "arithmetic on non-numbers",
+		expr:          "{name} * 2",
+		expectedError: `cannot evaluate "{name} \* 2": cannot apply \* to string alice and float64 2`,
+	}, {
+		about:         "string concatenation",
+		expr:          "{name} + '-' + {cart-size} + {error}",
+		expectedValue: "alice-3",
+	}, {
+		about:         "invalid comparison",
+		expr:          "{premium} > 1",
+		expectedError: `cannot evaluate "{premium} > 1": cannot compare bool true and float64 1`,
+	}, {
+		about:         "invalid negation",
+		expr:          "-{name}",
+		expectedError: `cannot evaluate "-{name}": cannot negate string alice`,
+	}}
+	for i, test := range tests {
+		c.Logf("running test %d: %s", i, test.about)
+		e, err := expr.Parse(test.expr)
+		c.Assert(err, qt.IsNil)
+		c.Assert(e.String(), qt.Equals, test.expr)
+		v, err := e.Eval(expr.Env{Attributes: attributes})
+		if test.expectedError != "" {
+			c.Assert(err, qt.ErrorMatches, test.expectedError)
+			continue
+		}
+		c.Assert(err, qt.IsNil)
+		c.Assert(v, qt.DeepEquals, test.expectedValue)
+	}
+}
+
+func TestFunctions(t *testing.T) {
+	c := qt.New(t)
+
+	now := time.Date(2019, 4, 1, 12, 30, 0, 0, time.UTC)
+	env := expr.Env{
+		Attributes: attributes,
+		Now: func() time.Time {
+			return now
+		},
+	}
+	tests := []struct {
+		expr          string
+		expectedValue interface{}
+		expectedError string
+	}{{
+		expr:          "default({visits}, 0) + 1",
+		expectedValue: 1.0,
+	}, {
+		expr:          "default({cart-size}, 0) + 1",
+		expectedValue: 4.0,
+	}, {
+		expr:          "if({premium}, 'gold', 1 / 0)",
+		expectedValue: "gold",
+	}, {
+		expr:          "if(!{premium}, 1 / 0, 'gold')",
+		expectedValue: "gold",
+	}, {
+		expr:          "len({name}) + len({items})",
+		expectedValue: 7.0,
+	}, {
+		expr:          "len({cart-size})",
+		expectedError: `len: cannot take the length of int 3`,
+	}, {
+		expr:          "upper({name}) + lower('B') + trim(' c ')",
+		expectedValue: "ALICEbc",
+	}, {
+		expr:          "upper({cart-size})",
+		expectedError: `upper: expected string, got int 3`,
+	}, {
+		expr:          "contains({name}, 'lic') && contains({items}, 'b') && !contains({items}, 'c')",
+		expectedValue: true,
+	}, {
+		expr:          "replace({name}, 'a', 'A')",
+		expectedValue: "Alice",
+	}, {
+		expr:          "string({cart-size}) + string(number({total}) * 2)",
+		expectedValue: "325",
+	}, {
+		expr:          "floor({total}) + round(2.5) + round(-2.5)",
+		expectedValue: 12.0,
+	}, {
+		expr:          "now()",
+		expectedValue: now,
+	}, {
+		expr:          "unix(now())",
+		expectedValue: float64(now.Unix()),
+	}, {
+		expr:          "format_time(now(), '2006-01-02T15:04')",
+		expectedValue: "2019-04-01T12:30",
+	}, {
+		expr:          "unix({name})",
+		expectedError: `unix: expected time, got string alice`,
+	}}
+	for i, test := range tests {
+		c.Logf("running test %d: %s", i, test.expr)
+		e, err := expr.Parse(test.expr)
+		c.Assert(err, qt.IsNil)
+		v, err := e.Eval(env)
+		if test.expectedError != "" {
+			c.Assert(err, qt.ErrorMatches, `cannot evaluate .*: `+test.expectedError)
+			continue
+		}
+		c.Assert(err, qt.IsNil)
+		c.Assert(v, qt.DeepEquals, test.expectedValue)
+	}
+}
+
+func TestBool(t *testing.T) {
+	c := qt.New(t)
+
+	e, err := expr.Parse("{cart-size}")
+	c.Assert(err, qt.IsNil)
+	ok, err := e.Bool(expr.Env{Attributes: attributes})
+	c.Assert(err, qt.IsNil)
+	c.Assert(ok, qt.Equals, true)
+	ok, err = e.Bool(expr.Env{})
+	c.Assert(err, qt.IsNil)
+	c.Assert(ok, qt.Equals, false)
+}
+
+func TestFloat(t *testing.T) {
+	c := qt.New(t)
+
+	e, err := expr.Parse("{total}")
+	c.Assert(err, qt.IsNil)
+	f, err := e.Float(expr.Env{Attributes: attributes})
+	c.Assert(err, qt.IsNil)
+	c.Assert(f, qt.Equals, 12.5)
+
+	e, err = expr.Parse("{name}")
+	c.Assert(err, qt.IsNil)
+	_, err = e.Float(expr.Env{Attributes: attributes})
+	c.Assert(err, qt.ErrorMatches, `"{name}" evaluates to string alice, not a number`)
+}
+
+func TestAttributes(t *testing.T) {
+	c := qt.New(t)
+
+	e, err := expr.Parse("{b} > 1 && exists({a}) || {b} < 0")
+	c.Assert(err, qt.IsNil)
+	c.Assert(e.Attributes(), qt.DeepEquals, []string{"a", "b"})
+}
+
+func TestParseErrors(t *testing.T) {
+	c := qt.New(t)
+
+	tests := []struct {
+		expr          string
+		expectedError string
+	}{{
+		expr:          "",
+		expectedError: "unexpected end of expression",
+	}, {
+		expr:          "{a} >",
+		expectedError: "unexpected end of expression",
+	}, {
+		expr:          "{a} 1",
+		expectedError: `unexpected "1" at position 4`,
+	}, {
+		expr:          "{a",
+		expectedError: "unterminated attribute reference at position 0",
+	}, {
+		expr:          "{}",
+		expectedError: "empty attribute reference at position 0",
+	}, {
+		expr:          `"abc`,
+		expectedError: "unterminated string at position 0",
+	}, {
+		expr:          "(1",
+		expectedError: "unexpected end of expression",
+	}, {
+		expr:          "1 # 2",
+		expectedError: `unexpected '#' at position 2`,
+	}, {
+		expr:          "1.2.3",
+		expectedError: `invalid number "1.2.3" at position 0`,
+	}, {
+		expr:          "undefined({a})",
+		expectedError: `unknown function "undefined" at position 0`,
+	}, {
+		expr:          "exists({a}, {b})",
+		expectedError: `exists expects 1 argument\(s\), got 2`,
+	}}
+	for i, test := range tests {
+		c.Logf("running test %d: %s", i, test.expr)
+		_, err := expr.Parse(test.expr)
+		c.Assert(err, qt.ErrorMatches, test.expectedError)
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright 2019 CanonicalLtd
+
+package expr
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// call is a function call.
+type call struct {
+	name string
+	f    function
+	args []node
+}
+
+func (n call) eval(env *Env) (interface{}, error) {
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		v, err := arg.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	v, err := n.f.call(env, args)
+	if err != nil {
+		return nil, errors.Annotatef(err, "%s", n.name)
+	}
+	return v, nil
+}
+
+// conditional implements the if function, which only evaluates the
+// chosen value.
+type conditional struct {
+	cond, then, otherwise node
+}
+
+func (n conditional) eval(env *Env) (interface{}, error) {
+	c, err := n.cond.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if truth(c) {
+		return n.then.eval(env)
+	}
+	return n.otherwise.eval(env)
+}
+
+// function holds a function that may be called in expressions.
+type function struct {
+	// nargs holds the number of arguments of the function.
+	nargs int
+	call  func(env *Env, args []interface{}) (interface{}, error)
+}
+
+var functions = map[string]function{
+	"exists": {
+		nargs: 1,
+		call: func(env *Env, args []interface{}) (interface{}, error) {
+			return args[0] != nil, nil
+		},
+	},
+	"default": {
+		nargs: 2,
+		call: func(env *Env, args []interface{}) (interface{}, error) {
+			if args[0] == nil {
+				return args[1], nil
+			}
+			return args[0], nil
+		},
+	},
+	// if is implemented by conditional and only registered so
+	// that its arguments are checked.
+	"if": {
+		nargs: 3,
+	},
+	"len": {
+		nargs: 1,
+		call: func(env *Env, args []interface{}) (interface{}, error) {
+			if s, ok := args[0].(string); ok {
+				return float64(len(s)), nil
+			}
+			v := reflect.ValueOf(args[0])
+			switch v.Kind() {
+			case reflect.Slice, reflect.Map:
+				return float64(v.Len()), nil
+			}
+			return nil, errors.Errorf("cannot take the length of %s", describe(args[0]))
+		},
+	},
+	"upper": stringFunction(strings.ToUpper),
+	"lower": stringFunction(strings.ToLower),
+	"trim":  stringFunction(strings.TrimSpace),
+	"contains": {
+		nargs: 2,
+		call: func(env *Env, args []interface{}) (interface{}, error) {
+			if s, ok := args[0].(string); ok {
+				return strings.Contains(s, toString(args[1])), nil
+			}
+			v := reflect.ValueOf(args[0])
+			if v.Kind() != reflect.Slice {
+				return nil, errors.Errorf("expected string or list, got %s", describe(args[0]))
+			}
+			for i := 0; i < v.Len(); i++ {
+				if equal(v.Index(i).Interface(), args[1]) {
+					return true, nil
+				}
+			}
+			return false, nil
+		},
+	},
+	"replace": {
+		nargs: 3,
+		call: func(env *Env, args []interface{}) (interface{}, error) {
+			return strings.Replace(toString(args[0]), toString(args[1]), toString(args[2]), -1), nil
+		},
+	},
+	"string": {
+		nargs: 1,
+		call: func(env *Env, args []interface{}) (interface{}, error) {
+			return toString(args[0]), nil
+		},
+	},
+	"number": numberFunction(func(f float64) float64 { return f }),
+	"floor":  numberFunction(math.Floor),
+	"round": numberFunction(func(f float64) float64 {
+		// math.Round is not available in all supported
+		// Go versions
+		if f < 0 {
+			return math.Ceil(f - 0.5)
+		}
+		return math.Floor(f + 0.5)
+	}),
+	"now": {
+		nargs: 0,
+		call: func(env *Env, args []interface{}) (interface{}, error) {
+			return env.now(), nil
+		},
+	},
+	"unix": {
+		nargs: 1,
+		call: func(env *Env, args []interface{}) (interface{}, error) {
+			t, ok := args[0].(time.Time)
+			if !ok {
+				return nil, errors.Errorf("expected time, got %s", describe(args[0]))
+			}
+			return float64(t.UnixNano()) / float64(time.Second), nil
+		},
+	},
+	"format_time": {
+		nargs: 2,
+		call: func(env *Env, args []interface{}) (interface{}, error) {
+			t, ok := args[0].(time.Time)
+			if !ok {
+				return nil, errors.Errorf("expected time, got %s", describe(args[0]))
+			}
+			return t.Format(toString(args[1])), nil
+		},
+	},
+}
+
+// stringFunction returns a function of one string argument.
+func stringFunction(f func(string) string) function {
+	return function{
+		nargs: 1,
+		call: func(env *Env, args []interface{}) (interface{}, error) {
+			s, ok := args[0].(string)
+			if !ok {
+				return nil, errors.Errorf("expected string, got %s", describe(args[0]))
+			}
+			return f(s), nil
+		},
+	}
+}
+
+// numberFunction returns a function of one numeric argument.
+func numberFunction(f func(float64) float64) function {
+	return function{
+		nargs: 1,
+		call: func(env *Env, args []interface{}) (interface{}, error) {
+			x, ok := number(args[0])
+			if !ok {
+				x, ok = parseNumber(args[0])
+			}
+			if !ok {
+				return nil, errors.Errorf("expected number, got %s", describe(args[0]))
+			}
+			return f(x), nil
+		},
+	}
+}
+
+// toString returns the string representation of the value.
+func toString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	}
+	return fmt.Sprintf("%v", v)
+}
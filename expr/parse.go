@@ -0,0 +1,295 @@
+// Copyright 2019 CanonicalLtd
+
+package expr
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/juju/errors"
+)
+
+type tokenKind int
+
+const (
+	eofToken tokenKind = iota
+	numberToken
+	stringToken
+	identToken
+	attributeToken
+	operatorToken
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	value interface{}
+	pos   int
+}
+
+// operators holds the operators in order of decreasing length, so
+// that the longest operator is matched.
+var operators = []string{
+	"&&", "||", "==", "!=", "<=", ">=",
+	"<", ">", "!", "+", "-", "*", "/", "%", "(", ")", ",",
+}
+
+// precedence holds the precedence of binary operators.
+var precedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3,
+	"!=": 3,
+	"<":  3,
+	"<=": 3,
+	">":  3,
+	">=": 3,
+	"+":  4,
+	"-":  4,
+	"*":  5,
+	"/":  5,
+	"%":  5,
+}
+
+// lexer splits an expression into tokens.
+type lexer struct {
+	src string
+	pos int
+}
+
+// next returns the next token.
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.src) && unicode.IsSpace(rune(l.src[l.pos])) {
+		l.pos++
+	}
+	start := l.pos
+	if l.pos == len(l.src) {
+		return token{kind: eofToken, pos: start}, nil
+	}
+	c := l.src[l.pos]
+	switch {
+	case c == '{':
+		end := strings.IndexByte(l.src[start:], '}')
+		if end < 0 {
+			return token{}, errors.Errorf("unterminated attribute reference at position %d", start)
+		}
+		l.pos = start + end + 1
+		name := strings.TrimSpace(l.src[start+1 : start+end])
+		if name == "" {
+			return token{}, errors.Errorf("empty attribute reference at position %d", start)
+		}
+		return token{kind: attributeToken, text: l.src[start:l.pos], value: name, pos: start}, nil
+	case c == '"' || c == '\'':
+		for l.pos++; l.pos < len(l.src) && l.src[l.pos] != c; l.pos++ {
+			if l.src[l.pos] == '\\' {
+				l.pos++
+			}
+		}
+		if l.pos >= len(l.src) {
+			return token{}, errors.Errorf("unterminated string at position %d", start)
+		}
+		l.pos++
+		text := l.src[start:l.pos]
+		quoted := text
+		if c == '\'' {
+			// convert to a double quoted string
+			quoted = `"` + strings.Replace(strings.Replace(text[1:len(text)-1], `\'`, `'`, -1), `"`, `\"`, -1) + `"`
+		}
+		s, err := strconv.Unquote(quoted)
+		if err != nil {
+			return token{}, errors.Errorf("invalid string %s at position %d", text, start)
+		}
+		return token{kind: stringToken, text: text, value: s, pos: start}, nil
+	case c >= '0' && c <= '9' || c == '.':
+		for l.pos < len(l.src) && (isDigit(l.src[l.pos]) || l.src[l.pos] == '.' || l.src[l.pos] == 'e' || l.src[l.pos] == 'E' ||
+			(l.src[l.pos] == '-' || l.src[l.pos] == '+') && (l.src[l.pos-1] == 'e' || l.src[l.pos-1] == 'E')) {
+			l.pos++
+		}
+		text := l.src[start:l.pos]
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return token{}, errors.Errorf("invalid number %q at position %d", text, start)
+		}
+		return token{kind: numberToken, text: text, value: f, pos: start}, nil
+	case c == '_' || unicode.IsLetter(rune(c)):
+		for l.pos < len(l.src) && (l.src[l.pos] == '_' || isDigit(l.src[l.pos]) || unicode.IsLetter(rune(l.src[l.pos]))) {
+			l.pos++
+		}
+		return token{kind: identToken, text: l.src[start:l.pos], pos: start}, nil
+	}
+	for _, op := range operators {
+		if strings.HasPrefix(l.src[start:], op) {
+			l.pos += len(op)
+			return token{kind: operatorToken, text: op, pos: start}, nil
+		}
+	}
+	return token{}, errors.Errorf("unexpected %q at position %d", c, start)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// parser implements a precedence climbing parser.
+type parser struct {
+	lexer
+	tok token
+	// attrs holds the names of referenced attributes.
+	attrs map[string]bool
+}
+
+// next advances to the next token.
+func (p *parser) next() error {
+	tok, err := p.lexer.next()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	p.tok = tok
+	return nil
+}
+
+// unexpected returns an error for the current token.
+func (p *parser) unexpected() error {
+	if p.tok.kind == eofToken {
+		return errors.New("unexpected end of expression")
+	}
+	return errors.Errorf("unexpected %q at position %d", p.tok.text, p.tok.pos)
+}
+
+// expect consumes the specified operator.
+func (p *parser) expect(op string) error {
+	if p.tok.kind != operatorToken || p.tok.text != op {
+		return p.unexpected()
+	}
+	return p.next()
+}
+
+// parseExpr parses a sequence of unary expressions joined by binary
+// operators with at least the specified precedence.
+func (p *parser) parseExpr(minPrecedence int) (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for p.tok.kind == operatorToken {
+		op := p.tok.text
+		prec, ok := precedence[op]
+		if !ok || prec <= minPrecedence {
+			break
+		}
+		if err := p.next(); err != nil {
+			return nil, errors.Trace(err)
+		}
+		right, err := p.parseExpr(prec)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		left = binary{
+			op:    op,
+			left:  left,
+			right: right,
+		}
+	}
+	return left, nil
+}
+
+// parseUnary parses a unary expression.
+func (p *parser) parseUnary() (node, error) {
+	if p.tok.kind == operatorToken && (p.tok.text == "!" || p.tok.text == "-") {
+		op := p.tok.text
+		if err := p.next(); err != nil {
+			return nil, errors.Trace(err)
+		}
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return unary{
+			op:      op,
+			operand: operand,
+		}, nil
+	}
+	return p.parsePrimary()
+}
+
+// parsePrimary parses literals, attribute references, function calls
+// and parenthesized expressions.
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.tok
+	switch tok.kind {
+	case numberToken, stringToken:
+		return literal{tok.value}, p.next()
+	case attributeToken:
+		name := tok.value.(string)
+		p.attrs[name] = true
+		return attribute{name}, p.next()
+	case identToken:
+		switch tok.text {
+		case "true":
+			return literal{true}, p.next()
+		case "false":
+			return literal{false}, p.next()
+		case "nil":
+			return literal{nil}, p.next()
+		}
+		return p.parseCall()
+	case operatorToken:
+		if tok.text == "(" {
+			if err := p.next(); err != nil {
+				return nil, errors.Trace(err)
+			}
+			n, err := p.parseExpr(0)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			return n, p.expect(")")
+		}
+	}
+	return nil, p.unexpected()
+}
+
+// parseCall parses a function call.
+func (p *parser) parseCall() (node, error) {
+	tok := p.tok
+	f, ok := functions[tok.text]
+	if !ok {
+		return nil, errors.Errorf("unknown function %q at position %d", tok.text, tok.pos)
+	}
+	if err := p.next(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := p.expect("("); err != nil {
+		return nil, errors.Trace(err)
+	}
+	n := call{
+		name: tok.text,
+		f:    f,
+	}
+	for p.tok.kind != operatorToken || p.tok.text != ")" {
+		if len(n.args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, errors.Trace(err)
+			}
+		}
+		arg, err := p.parseExpr(0)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		n.args = append(n.args, arg)
+	}
+	if err := p.next(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(n.args) != f.nargs {
+		return nil, errors.Errorf("%s expects %d argument(s), got %d", tok.text, f.nargs, len(n.args))
+	}
+	if n.name == "if" {
+		return conditional{
+			cond:      n.args[0],
+			then:      n.args[1],
+			otherwise: n.args[2],
+		}, nil
+	}
+	return n, nil
+}
@@ -0,0 +1,145 @@
+// Copyright 2019 CanonicalLtd
+
+package expr
+
+import (
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// truth reports whether the value is considered true.
+func truth(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	}
+	if f, ok := number(v); ok {
+		return f != 0
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Map:
+		return rv.Len() > 0
+	}
+	return true
+}
+
+// number returns the value as a number, if it is numeric.
+func number(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// numbers returns both values as numbers if either is numeric and
+// the other is numeric or a string holding a number. Attributes set
+// from call results often hold numbers as strings.
+func numbers(a, b interface{}) (float64, float64, bool) {
+	fa, oka := number(a)
+	fb, okb := number(b)
+	if !oka && !okb {
+		return 0, 0, false
+	}
+	if !oka {
+		fa, oka = parseNumber(a)
+	}
+	if !okb {
+		fb, okb = parseNumber(b)
+	}
+	return fa, fb, oka && okb
+}
+
+// parseNumber parses a string holding a number.
+func parseNumber(v interface{}) (float64, bool) {
+	s, ok := v.(string)
+	if !ok {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	return f, err == nil
+}
+
+// equal reports whether the values are equal.
+func equal(a, b interface{}) bool {
+	if fa, fb, ok := numbers(a, b); ok {
+		return fa == fb
+	}
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// compare compares numbers or strings, returning a negative number
+// if a is less than b, 0 if they are equal and a positive number
+// if a is greater than b.
+func compare(a, b interface{}) (int, error) {
+	if fa, fb, ok := numbers(a, b); ok {
+		switch {
+		case fa < fb:
+			return -1, nil
+		case fa > fb:
+			return 1, nil
+		}
+		return 0, nil
+	}
+	sa, oka := a.(string)
+	sb, okb := b.(string)
+	if oka && okb {
+		return strings.Compare(sa, sb), nil
+	}
+	return 0, errors.Errorf("cannot compare %s and %s", describe(a), describe(b))
+}
+
+// arithmetic applies the arithmetic operator to numbers.
+func arithmetic(op string, a, b interface{}) (interface{}, error) {
+	fa, fb, ok := numbers(a, b)
+	if !ok && op == "+" {
+		// concatenate strings
+		_, oka := a.(string)
+		_, okb := b.(string)
+		if oka || okb {
+			return toString(a) + toString(b), nil
+		}
+	}
+	if !ok {
+		return nil, errors.Errorf("cannot apply %s to %s and %s", op, describe(a), describe(b))
+	}
+	switch op {
+	case "+":
+		return fa + fb, nil
+	case "-":
+		return fa - fb, nil
+	case "*":
+		return fa * fb, nil
+	case "/":
+		if fb == 0 {
+			return nil, errors.New("division by zero")
+		}
+		return fa / fb, nil
+	case "%":
+		if fb == 0 {
+			return nil, errors.New("division by zero")
+		}
+		return math.Mod(fa, fb), nil
+	}
+	return nil, errors.Errorf("unknown operator %q", op)
+}
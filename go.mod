@@ -9,6 +9,7 @@ require (
 	github.com/juju/errors v0.0.0-20190207033735-e65537c515d7
 	github.com/juju/utils v0.0.0-20180820210520-bf9cc5bdd62d
 	github.com/juju/zaputil v0.0.0-20190326175239-ef53049637ac
+	github.com/prometheus/client_golang v0.9.2
 	go.uber.org/zap v1.9.1
 	gopkg.in/macaroon-bakery.v1 v1.0.0-20180822103327-f3518acd1415
 	gopkg.in/yaml.v1 v1.0.0-20140924161607-9f9df34309c0
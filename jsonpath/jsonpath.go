@@ -0,0 +1,206 @@
+// Copyright 2019 CanonicalLtd
+
+// Package jsonpath implements path expressions selecting values from
+// decoded JSON documents, as used to extract call results.
+//
+// A path is a sequence of steps separated by dots, e.g.
+// data.items[0].id. Steps may be:
+//   - name: the field of an object
+//   - ["name"]: the field of an object, which may contain dots
+//   - [n]: the n-th element of an array, starting at 0; negative
+//     indices count from the end of the array
+//   - [*]: all elements of an array or all values of an object, in
+//     order of their keys; the path selects the list of values
+//     selected by the remaining steps from each element
+//
+// Paths may start with $, which denotes the whole document. The path
+// length(p) selects the length of the array, object or string selected
+// by p.
+package jsonpath
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+type stepKind int
+
+const (
+	fieldStep stepKind = iota
+	indexStep
+	wildcardStep
+)
+
+type step struct {
+	kind  stepKind
+	name  string
+	index int
+}
+
+// Path holds a parsed path.
+type Path struct {
+	src    string
+	length bool
+	steps  []step
+}
+
+// Parse parses the path.
+func Parse(s string) (*Path, error) {
+	p := &Path{src: s}
+	src := strings.TrimSpace(s)
+	if strings.HasPrefix(src, "length(") && strings.HasSuffix(src, ")") {
+		p.length = true
+		src = strings.TrimSpace(src[len("length(") : len(src)-1])
+	}
+	if src == "" {
+		return nil, errors.New("empty path")
+	}
+	if strings.HasPrefix(src, "$") {
+		src = src[1:]
+		if strings.HasPrefix(src, ".") {
+			src = src[1:]
+			if src == "" {
+				return nil, errors.New("expected field name at end of path")
+			}
+		}
+	}
+	for i := 0; i < len(src); {
+		switch {
+		case src[i] == '[':
+			end := strings.IndexByte(src[i:], ']')
+			if end < 0 {
+				return nil, errors.Errorf("missing ] at position %d", i)
+			}
+			st, err := parseBracket(src[i+1 : i+end])
+			if err != nil {
+				return nil, errors.Annotatef(err, "position %d", i)
+			}
+			p.steps = append(p.steps, st)
+			i += end + 1
+		case src[i] == '.' && i > 0:
+			i++
+			fallthrough
+		default:
+			end := strings.IndexAny(src[i:], ".[")
+			if end < 0 {
+				end = len(src) - i
+			}
+			if end == 0 {
+				return nil, errors.Errorf("expected field name at position %d", i)
+			}
+			p.steps = append(p.steps, step{
+				kind: fieldStep,
+				name: src[i : i+end],
+			})
+			i += end
+		}
+	}
+	return p, nil
+}
+
+func parseBracket(s string) (step, error) {
+	s = strings.TrimSpace(s)
+	if s == "*" {
+		return step{kind: wildcardStep}, nil
+	}
+	if strings.HasPrefix(s, `"`) || strings.HasPrefix(s, "'") {
+		if len(s) < 2 || s[len(s)-1] != s[0] {
+			return step{}, errors.Errorf("unterminated field name %s", s)
+		}
+		return step{kind: fieldStep, name: s[1 : len(s)-1]}, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return step{}, errors.Errorf("invalid index %q", s)
+	}
+	return step{kind: indexStep, index: n}, nil
+}
+
+// String returns the source of the path.
+func (p *Path) String() string {
+	return p.src
+}
+
+// Select returns the value selected by the path from the decoded JSON
+// document and true, or false if the document does not hold the
+// selected value.
+func (p *Path) Select(doc interface{}) (interface{}, bool) {
+	v, ok := selectSteps(p.steps, doc)
+	if !ok || !p.length {
+		return v, ok
+	}
+	switch v := v.(type) {
+	case []interface{}:
+		return len(v), true
+	case map[string]interface{}:
+		return len(v), true
+	case string:
+		return len(v), true
+	}
+	return nil, false
+}
+
+func selectSteps(steps []step, v interface{}) (interface{}, bool) {
+	for i, st := range steps {
+		switch st.kind {
+		case fieldStep:
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if v, ok = m[st.name]; !ok {
+				return nil, false
+			}
+		case indexStep:
+			a, ok := v.([]interface{})
+			if !ok {
+				return nil, false
+			}
+			index := st.index
+			if index < 0 {
+				index += len(a)
+			}
+			if index < 0 || index >= len(a) {
+				return nil, false
+			}
+			v = a[index]
+		case wildcardStep:
+			elements, ok := values(v)
+			if !ok {
+				return nil, false
+			}
+			selected := []interface{}{}
+			for _, e := range elements {
+				if s, ok := selectSteps(steps[i+1:], e); ok {
+					selected = append(selected, s)
+				}
+			}
+			return selected, true
+		}
+	}
+	return v, true
+}
+
+// values returns the elements of an array or the values of an object
+// in order of their keys.
+func values(v interface{}) ([]interface{}, bool) {
+	switch v := v.(type) {
+	case []interface{}:
+		return v, true
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		values := make([]interface{}, len(keys))
+		for i, k := range keys {
+			values[i] = v[k]
+		}
+		return values, true
+	}
+	return nil, false
+}
@@ -0,0 +1,145 @@
+// Copyright 2019 CanonicalLtd
+
+package jsonpath_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/cloud-green/sisyphus/jsonpath"
+)
+
+var document = `{
+	"token": "123456",
+	"count": 3,
+	"active": true,
+	"a.b": "dotted",
+	"data": {
+		"items": [
+			{"id": 1, "name": "first"},
+			{"id": 2},
+			{"id": 3, "name": "third"}
+		],
+		"tags": {"b": "beta", "a": "alpha"}
+	}
+}`
+
+func TestSelect(t *testing.T) {
+	c := qt.New(t)
+
+	var doc interface{}
+	err := json.Unmarshal([]byte(document), &doc)
+	c.Assert(err, qt.IsNil)
+
+	tests := []struct {
+		about         string
+		path          string
+		expectedValue interface{}
+		expectedOK    bool
+	}{{
+		about:         "top level field",
+		path:          "token",
+		expectedValue: "123456",
+		expectedOK:    true,
+	}, {
+		about:         "numbers and booleans",
+		path:          "$.active",
+		expectedValue: true,
+		expectedOK:    true,
+	}, {
+		about:         "nested fields and indices",
+		path:          "data.items[0].id",
+		expectedValue: 1.0,
+		expectedOK:    true,
+	}, {
+		about:         "negative indices",
+		path:          "data.items[-1].name",
+		expectedValue: "third",
+		expectedOK:    true,
+	}, {
+		about:         "quoted field names",
+		path:          `["a.b"]`,
+		expectedValue: "dotted",
+		expectedOK:    true,
+	}, {
+		about:         "wildcards",
+		path:          "data.items[*].name",
+		expectedValue: []interface{}{"first", "third"},
+		expectedOK:    true,
+	}, {
+		about:         "wildcards over objects",
+		path:          "data.tags[*]",
+		expectedValue: []interface{}{"alpha", "beta"},
+		expectedOK:    true,
+	}, {
+		about:         "length",
+		path:          "length(data.items)",
+		expectedValue: 3,
+		expectedOK:    true,
+	}, {
+		about:         "the whole document",
+		path:          "length($)",
+		expectedValue: 5,
+		expectedOK:    true,
+	}, {
+		about: "missing field",
+		path:  "data.total",
+	}, {
+		about: "index out of range",
+		path:  "data.items[3]",
+	}, {
+		about: "field of a non-object",
+		path:  "token.value",
+	}, {
+		about: "length of a number",
+		path:  "length(count)",
+	}}
+
+	for i, test := range tests {
+		c.Logf("running test %d: %s", i, test.about)
+		p, err := jsonpath.Parse(test.path)
+		c.Assert(err, qt.IsNil)
+		c.Assert(p.String(), qt.Equals, test.path)
+		value, ok := p.Select(doc)
+		c.Assert(ok, qt.Equals, test.expectedOK)
+		c.Assert(value, qt.DeepEquals, test.expectedValue)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	c := qt.New(t)
+
+	tests := []struct {
+		path          string
+		expectedError string
+	}{{
+		path:          "",
+		expectedError: "empty path",
+	}, {
+		path:          "length()",
+		expectedError: "empty path",
+	}, {
+		path:          "data..items",
+		expectedError: "expected field name at position 5",
+	}, {
+		path:          "$.",
+		expectedError: "expected field name at end of path",
+	}, {
+		path:          "items[0",
+		expectedError: "missing \\] at position 5",
+	}, {
+		path:          "items[x]",
+		expectedError: `position 5: invalid index "x"`,
+	}, {
+		path:          `items["x]`,
+		expectedError: `position 5: unterminated field name "x`,
+	}}
+
+	for i, test := range tests {
+		c.Logf("running test %d: %s", i, test.path)
+		_, err := jsonpath.Parse(test.path)
+		c.Assert(err, qt.ErrorMatches, test.expectedError)
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2019 CanonicalLtd
+
+// Package metrics implements a simulation observer that exposes
+// simulation telemetry as Prometheus metrics.
+package metrics
+
+import (
+	"github.com/juju/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/cloud-green/sisyphus/simulation"
+	"github.com/cloud-green/sisyphus/simulation/call"
+)
+
+const namespace = "sisyphus"
+
+// New returns a new set of simulation metrics.
+func New() *Metrics {
+	return &Metrics{
+		activeEntities: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "active_entities",
+			Help:      "The number of active entities.",
+		}, []string{"entity"}),
+		createdEntities: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "created_entities_total",
+			Help:      "The number of created entities.",
+		}, []string{"entity"}),
+		stateOccupants: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "state_occupants",
+			Help:      "The number of entities currently in a state.",
+		}, []string{"state"}),
+		transitions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "transitions_total",
+			Help:      "The number of state transitions taken.",
+		}, []string{"from", "to"}),
+		failureTransitions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "failure_transitions_total",
+			Help:      "The number of on-failure state transitions taken.",
+		}, []string{"from", "to"}),
+		callDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "call_duration_seconds",
+			Help:      "The duration of calls.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"backend"}),
+		callErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "call_errors_total",
+			Help:      "The number of failed calls by cause.",
+		}, []string{"backend", "cause"}),
+		callRetries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "call_retries_total",
+			Help:      "The number of calls retrying a failed call.",
+		}, []string{"backend"}),
+		throttledCalls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "throttled_calls_total",
+			Help:      "The number of calls that exceeded a rate limit by action taken.",
+		}, []string{"backend", "action"}),
+		throttleWait: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "throttle_wait_seconds_total",
+			Help:      "The time calls spent waiting for rate limits.",
+		}, []string{"backend"}),
+	}
+}
+
+// Metrics implements the simulation.Observer interface recording
+// Prometheus metrics.
+type Metrics struct {
+	activeEntities     *prometheus.GaugeVec
+	createdEntities    *prometheus.CounterVec
+	stateOccupants     *prometheus.GaugeVec
+	transitions        *prometheus.CounterVec
+	failureTransitions *prometheus.CounterVec
+	callDuration       *prometheus.HistogramVec
+	callErrors         *prometheus.CounterVec
+	callRetries        *prometheus.CounterVec
+	throttledCalls     *prometheus.CounterVec
+	throttleWait       *prometheus.CounterVec
+}
+
+// Register registers all metrics with the registerer.
+func (m *Metrics) Register(r prometheus.Registerer) error {
+	for _, c := range m.collectors() {
+		if err := r.Register(c); err != nil {
+			return errors.Annotate(err, "failed to register metrics")
+		}
+	}
+	return nil
+}
+
+func (m *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.activeEntities,
+		m.createdEntities,
+		m.stateOccupants,
+		m.transitions,
+		m.failureTransitions,
+		m.callDuration,
+		m.callErrors,
+		m.callRetries,
+		m.throttledCalls,
+		m.throttleWait,
+	}
+}
+
+// EntityCreated implements the simulation.Observer interface.
+func (m *Metrics) EntityCreated(entity string) {
+	m.createdEntities.WithLabelValues(entity).Inc()
+	m.activeEntities.WithLabelValues(entity).Inc()
+}
+
+// EntityFinished implements the simulation.Observer interface.
+func (m *Metrics) EntityFinished(entity string) {
+	m.activeEntities.WithLabelValues(entity).Dec()
+}
+
+// StateEntered implements the simulation.Observer interface.
+func (m *Metrics) StateEntered(entity, state string) {
+	m.stateOccupants.WithLabelValues(state).Inc()
+}
+
+// StateExited implements the simulation.Observer interface.
+func (m *Metrics) StateExited(entity, state string) {
+	m.stateOccupants.WithLabelValues(state).Dec()
+}
+
+// Transition implements the simulation.Observer interface.
+func (m *Metrics) Transition(entity, from, to string, failure bool) {
+	m.transitions.WithLabelValues(from, to).Inc()
+	if failure {
+		m.failureTransitions.WithLabelValues(from, to).Inc()
+	}
+}
+
+// Call implements the simulation.Observer interface.
+func (m *Metrics) Call(info simulation.CallInfo) {
+	backend := string(info.Backend)
+	m.callDuration.WithLabelValues(backend).Observe(info.Duration.Seconds())
+	if info.Err != nil {
+		m.callErrors.WithLabelValues(backend, call.ErrorCause(info.Err)).Inc()
+	}
+	if info.Attempt > 1 {
+		m.callRetries.WithLabelValues(backend).Inc()
+	}
+}
+
+// Throttled implements the simulation.Observer interface.
+func (m *Metrics) Throttled(info simulation.ThrottleInfo) {
+	backend := string(info.Backend)
+	m.throttledCalls.WithLabelValues(backend, string(info.Action)).Inc()
+	if info.Wait > 0 {
+		m.throttleWait.WithLabelValues(backend).Add(info.Wait.Seconds())
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2019 CanonicalLtd
+
+package metrics_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	yaml "gopkg.in/yaml.v1"
+
+	"github.com/cloud-green/sisyphus/config"
+	"github.com/cloud-green/sisyphus/metrics"
+	"github.com/cloud-green/sisyphus/simulation"
+	"github.com/cloud-green/sisyphus/simulation/call"
+)
+
+var simConfig = `
+backend: nop
+root-entities:
+- entity: user
+  cardinality: "3"
+entities:
+  user:
+    initial_state: login
+state:
+  login:
+    transitions:
+    - state: home
+      probability: 1
+      on-failure: error
+      call:
+        method: POST
+        url: http://test.com/login
+        retry:
+          max-attempts: 2
+  home:
+    transitions:
+    - state: logout
+      probability: 1
+      call:
+        method: POST
+        url: http://test.com/logout
+  logout:
+  error:
+`
+
+// failingCallBackend fails login calls. It does not report its name,
+// so its calls are labelled with the unknown backend.
+type failingCallBackend struct{}
+
+func (failingCallBackend) Do(ctx context.Context, callConfig config.Call, attributes call.Attributes) (call.Attributes, error) {
+	if strings.HasSuffix(callConfig.URL, "login") {
+		return attributes, errors.Trace(&call.StatusError{StatusCode: 503})
+	}
+	return attributes, nil
+}
+
+func TestMetrics(t *testing.T) {
+	c := qt.New(t)
+
+	var cfg config.Config
+	err := yaml.Unmarshal([]byte(simConfig), &cfg)
+	c.Assert(err, qt.IsNil)
+
+	m := metrics.New()
+	registry := prometheus.NewPedanticRegistry()
+	err = m.Register(registry)
+	c.Assert(err, qt.IsNil)
+
+	sim, err := simulation.New(cfg, failingCallBackend{})
+	c.Assert(err, qt.IsNil)
+	sim.Observer = m
+	err = sim.Start(context.Background())
+	c.Assert(err, qt.IsNil)
+	err = sim.Wait()
+	c.Assert(err, qt.IsNil)
+
+	expected := `
+# HELP sisyphus_active_entities The number of active entities.
+# TYPE sisyphus_active_entities gauge
+sisyphus_active_entities{entity="user"} 0
+# HELP sisyphus_call_errors_total The number of failed calls by cause.
+# TYPE sisyphus_call_errors_total counter
+sisyphus_call_errors_total{backend="unknown",cause="503"} 6
+# HELP sisyphus_call_retries_total The number of calls retrying a failed call.
+# TYPE sisyphus_call_retries_total counter
+sisyphus_call_retries_total{backend="unknown"} 3
+# HELP sisyphus_created_entities_total The number of created entities.
+# TYPE sisyphus_created_entities_total counter
+sisyphus_created_entities_total{entity="user"} 3
+# HELP sisyphus_failure_transitions_total The number of on-failure state transitions taken.
+# TYPE sisyphus_failure_transitions_total counter
+sisyphus_failure_transitions_total{from="login",to="error"} 3
+# HELP sisyphus_state_occupants The number of entities currently in a state.
+# TYPE sisyphus_state_occupants gauge
+sisyphus_state_occupants{state="error"} 0
+sisyphus_state_occupants{state="login"} 0
+# HELP sisyphus_transitions_total The number of state transitions taken.
+# TYPE sisyphus_transitions_total counter
+sisyphus_transitions_total{from="login",to="error"} 3
+`
+	err = testutil.GatherAndCompare(registry, strings.NewReader(expected),
+		"sisyphus_active_entities",
+		"sisyphus_call_errors_total",
+		"sisyphus_call_retries_total",
+		"sisyphus_created_entities_total",
+		"sisyphus_failure_transitions_total",
+		"sisyphus_state_occupants",
+		"sisyphus_transitions_total",
+	)
+	c.Assert(err, qt.IsNil)
+}
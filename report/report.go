@@ -0,0 +1,449 @@
+// Copyright 2019 CanonicalLtd
+
+// Package report implements a simulation observer that records
+// statistics of calls and state visits and summarizes them at the end
+// of a simulation.
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/juju/errors"
+
+	"github.com/cloud-green/sisyphus/simulation"
+	"github.com/cloud-green/sisyphus/simulation/call"
+)
+
+// maxSamples holds the maximum number of latencies sampled for each
+// call template to compute latency percentiles.
+const maxSamples = 10000
+
+// StatusOK holds the status of successful calls in call summaries.
+// Failed calls have the status returned by call.ErrorCause, e.g. 503
+// or timeout.
+const StatusOK = "ok"
+
+// NewRecorder returns a new recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{
+		templates:   make(map[template]*callStats),
+		selections:  make(map[selectionKey]*selection),
+		visits:      make(map[string]int64),
+		transitions: make(map[string]int64),
+		rnd:         rand.New(rand.NewSource(1)),
+	}
+}
+
+// Recorder implements the simulation.Observer interface recording
+// statistics of calls for each call template, state visits and
+// transitions.
+type Recorder struct {
+	simulation.NopObserver
+
+	mu        sync.Mutex
+	templates map[template]*callStats
+	// order holds the call templates in the order of their
+	// first call.
+	order       []template
+	selections  map[selectionKey]*selection
+	visits      map[string]int64
+	transitions map[string]int64
+	// rnd selects the sampled latencies of calls.
+	rnd *rand.Rand
+}
+
+// template identifies calls with the same method and URL template.
+type template struct {
+	method, url string
+}
+
+// transition identifies the transition performing a call.
+type transition struct {
+	state, target string
+}
+
+// callStats holds the statistics of the calls with the same template.
+type callStats struct {
+	count       int
+	errors      int
+	retries     int
+	max         time.Duration
+	statuses    map[string]int
+	transitions map[transition]int
+	// samples holds a uniform random sample of the latencies of
+	// the calls, holding all latencies up to maxSamples calls.
+	samples []time.Duration
+}
+
+// add records the call described by info.
+func (s *callStats) add(info simulation.CallInfo, rnd *rand.Rand) {
+	s.count++
+	status := StatusOK
+	if info.Err != nil {
+		s.errors++
+		status = call.ErrorCause(info.Err)
+	}
+	s.statuses[status]++
+	s.transitions[transition{info.State, info.Target}]++
+	if info.Attempt > 1 {
+		s.retries++
+	}
+	d := info.Duration
+	if d > s.max {
+		s.max = d
+	}
+	if len(s.samples) < maxSamples {
+		s.samples = append(s.samples, d)
+	} else if i := rnd.Intn(s.count); i < maxSamples {
+		// reservoir sampling keeps each latency with the
+		// same probability
+		s.samples[i] = d
+	}
+}
+
+// weightedSamples returns the sampled latencies, each weighted by the
+// number of calls it represents.
+func (s *callStats) weightedSamples() []sample {
+	samples := make([]sample, len(s.samples))
+	weight := float64(s.count) / float64(len(s.samples))
+	for i, d := range s.samples {
+		samples[i] = sample{
+			duration: d,
+			weight:   weight,
+		}
+	}
+	return samples
+}
+
+// StateEntered implements the simulation.Observer interface.
+func (r *Recorder) StateEntered(entity, state string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.visits[state]++
+}
+
+// Call implements the simulation.Observer interface.
+func (r *Recorder) Call(info simulation.CallInfo) {
+	t := template{info.Call.Method, info.Call.URL}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.templates[t]
+	if s == nil {
+		s = &callStats{
+			statuses:    make(map[string]int),
+			transitions: make(map[transition]int),
+		}
+		r.templates[t] = s
+		r.order = append(r.order, t)
+	}
+	s.add(info, r.rnd)
+}
+
+// Report holds the summary of a simulation.
+type Report struct {
+	// Seed holds the seed of the simulation.
+	Seed int64 `json:"seed"`
+	// Duration holds the duration of the simulation.
+	Duration time.Duration `json:"duration"`
+	// Calls holds call summaries, one for each call template.
+	Calls []CallSummary `json:"calls"`
+	// States holds the number of visits of each state.
+	States []StateSummary `json:"states"`
+	// Thresholds holds the results of checking thresholds.
+	Thresholds []ThresholdResult `json:"thresholds,omitempty"`
+}
+
+// CallSummary summarizes calls with the same method and URL template.
+type CallSummary struct {
+	Method     string        `json:"method"`
+	URL        string        `json:"url"`
+	Count      int           `json:"count"`
+	Errors     int           `json:"errors"`
+	Retries    int           `json:"retries"`
+	ErrorRate  float64       `json:"error-rate"`
+	Throughput float64       `json:"throughput"`
+	P50        time.Duration `json:"p50"`
+	P90        time.Duration `json:"p90"`
+	P99        time.Duration `json:"p99"`
+	Max        time.Duration `json:"max"`
+	// Statuses holds the number of calls with each status: StatusOK
+	// for successful calls, otherwise the cause of the failure.
+	Statuses map[string]int `json:"statuses"`
+	// Transitions holds the number of calls performed by each
+	// transition, sorted by state and target.
+	Transitions []TransitionSummary `json:"transitions"`
+}
+
+// TransitionSummary holds the number of calls with the same template
+// performed by a transition.
+type TransitionSummary struct {
+	State  string `json:"state"`
+	Target string `json:"target"`
+	Count  int    `json:"count"`
+}
+
+// StateSummary holds the number of times a state was visited.
+type StateSummary struct {
+	State  string `json:"state"`
+	Visits int64  `json:"visits"`
+}
+
+// Report returns the summary of the recorded calls and state visits
+// for a simulation with the specified seed that ran for the specified
+// duration. Throughput is expressed in calls per second.
+func (r *Recorder) Report(seed int64, duration time.Duration) *Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	templates := make([]template, 0, len(r.templates))
+	for t := range r.templates {
+		templates = append(templates, t)
+	}
+	sort.Slice(templates, func(i, j int) bool {
+		if templates[i].url == templates[j].url {
+			return templates[i].method < templates[j].method
+		}
+		return templates[i].url < templates[j].url
+	})
+
+	report := &Report{
+		Seed:     seed,
+		Duration: duration,
+		Calls:    []CallSummary{},
+		States:   []StateSummary{},
+	}
+	for _, t := range templates {
+		s := r.templates[t]
+		samples := s.weightedSamples()
+		sortSamples(samples)
+		summary := CallSummary{
+			Method:    t.method,
+			URL:       t.url,
+			Count:     s.count,
+			Errors:    s.errors,
+			Retries:   s.retries,
+			ErrorRate: float64(s.errors) / float64(s.count),
+			P50:       percentile(samples, 50),
+			P90:       percentile(samples, 90),
+			P99:       percentile(samples, 99),
+			Max:       s.max,
+			Statuses:  make(map[string]int, len(s.statuses)),
+		}
+		for status, n := range s.statuses {
+			summary.Statuses[status] = n
+		}
+		for t, n := range s.transitions {
+			summary.Transitions = append(summary.Transitions, TransitionSummary{
+				State:  t.state,
+				Target: t.target,
+				Count:  n,
+			})
+		}
+		sort.Slice(summary.Transitions, func(i, j int) bool {
+			ti, tj := summary.Transitions[i], summary.Transitions[j]
+			if ti.State == tj.State {
+				return ti.Target < tj.Target
+			}
+			return ti.State < tj.State
+		})
+		if duration > 0 {
+			summary.Throughput = float64(s.count) / duration.Seconds()
+		}
+		report.Calls = append(report.Calls, summary)
+	}
+	for state, visits := range r.visits {
+		report.States = append(report.States, StateSummary{
+			State:  state,
+			Visits: visits,
+		})
+	}
+	sort.Slice(report.States, func(i, j int) bool {
+		return report.States[i].State < report.States[j].State
+	})
+	return report
+}
+
+// sample holds a sampled latency weighted by the number of calls it
+// represents.
+type sample struct {
+	duration time.Duration
+	weight   float64
+}
+
+// sortSamples sorts the samples by latency.
+func sortSamples(samples []sample) {
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i].duration < samples[j].duration
+	})
+}
+
+// percentile returns the p-th percentile of the sorted samples using
+// the nearest rank method.
+func percentile(sorted []sample, p float64) time.Duration {
+	total := 0.0
+	for _, s := range sorted {
+		total += s.weight
+	}
+	rank := math.Max(1, math.Ceil(p/100*total))
+	// allow for rounding errors in the sum of weights
+	const epsilon = 1e-9
+	n := 0.0
+	for _, s := range sorted {
+		n += s.weight
+		if n >= rank-epsilon {
+			return s.duration
+		}
+	}
+	return sorted[len(sorted)-1].duration
+}
+
+// WriteText writes the report in human readable form.
+func (r *Report) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "CALL\tCOUNT\tERRORS\tRETRIES\tERROR RATE\tTHROUGHPUT\tP50\tP90\tP99\tMAX\tSTATUSES\n")
+	for _, c := range r.Calls {
+		fmt.Fprintf(tw, "%s %s\t%d\t%d\t%d\t%.2f%%\t%.2f/s\t%v\t%v\t%v\t%v\t%s\n",
+			c.Method,
+			c.URL,
+			c.Count,
+			c.Errors,
+			c.Retries,
+			100*c.ErrorRate,
+			c.Throughput,
+			roundDuration(c.P50),
+			roundDuration(c.P90),
+			roundDuration(c.P99),
+			roundDuration(c.Max),
+			formatStatuses(c.Statuses),
+		)
+	}
+	fmt.Fprintf(tw, "\nSTATE\tVISITS\n")
+	for _, s := range r.States {
+		fmt.Fprintf(tw, "%s\t%d\n", s.State, s.Visits)
+	}
+	fmt.Fprintf(tw, "\nTRANSITION\tCALL\tCOUNT\n")
+	for _, c := range r.Calls {
+		for _, t := range c.Transitions {
+			fmt.Fprintf(tw, "%s -> %s\t%s %s\t%d\n", t.State, t.Target, c.Method, c.URL, t.Count)
+		}
+	}
+	if len(r.Thresholds) > 0 {
+		fmt.Fprintf(tw, "\nTHRESHOLD\tVALUE\tRESULT\n")
+		for _, t := range r.Thresholds {
+			result := "PASS"
+			if !t.Passed {
+				result = "FAIL"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", t.Threshold, t.Value, result)
+		}
+	}
+	return errors.Trace(tw.Flush())
+}
+
+// WriteJSON writes the report in JSON format.
+func (r *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return errors.Trace(encoder.Encode(r))
+}
+
+// WriteCSV writes the call summaries in CSV format followed, after an
+// empty line, by the state visits and, after another empty line, by
+// the number of calls performed by each transition. Durations are
+// expressed in seconds.
+func (r *Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"method", "url", "count", "errors", "retries", "error-rate", "throughput", "p50", "p90", "p99", "max", "statuses"})
+	for _, c := range r.Calls {
+		cw.Write([]string{
+			c.Method,
+			c.URL,
+			strconv.Itoa(c.Count),
+			strconv.Itoa(c.Errors),
+			strconv.Itoa(c.Retries),
+			formatFloat(c.ErrorRate),
+			formatFloat(c.Throughput),
+			formatFloat(c.P50.Seconds()),
+			formatFloat(c.P90.Seconds()),
+			formatFloat(c.P99.Seconds()),
+			formatFloat(c.Max.Seconds()),
+			formatStatuses(c.Statuses),
+		})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return errors.Trace(err)
+	}
+	if _, err := io.WriteString(w, "\n"); err != nil {
+		return errors.Trace(err)
+	}
+	cw.Write([]string{"state", "visits"})
+	for _, s := range r.States {
+		cw.Write([]string{
+			s.State,
+			strconv.FormatInt(s.Visits, 10),
+		})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return errors.Trace(err)
+	}
+	if _, err := io.WriteString(w, "\n"); err != nil {
+		return errors.Trace(err)
+	}
+	cw.Write([]string{"state", "target", "method", "url", "count"})
+	for _, c := range r.Calls {
+		for _, t := range c.Transitions {
+			cw.Write([]string{
+				t.State,
+				t.Target,
+				c.Method,
+				c.URL,
+				strconv.Itoa(t.Count),
+			})
+		}
+	}
+	cw.Flush()
+	return errors.Trace(cw.Error())
+}
+
+// formatStatuses formats the number of calls with each status, sorted
+// by status, e.g. "503:2 ok:8".
+func formatStatuses(statuses map[string]int) string {
+	keys := make([]string, 0, len(statuses))
+	for status := range statuses {
+		keys = append(keys, status)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, status := range keys {
+		parts[i] = status + ":" + strconv.Itoa(statuses[status])
+	}
+	return strings.Join(parts, " ")
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// roundDuration rounds durations for display.
+func roundDuration(d time.Duration) time.Duration {
+	switch {
+	case d > time.Second:
+		return d.Round(time.Millisecond)
+	case d > time.Millisecond:
+		return d.Round(time.Microsecond)
+	}
+	return d
+}
@@ -0,0 +1,211 @@
+// Copyright 2019 CanonicalLtd
+
+package report_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/errors"
+
+	"github.com/cloud-green/sisyphus/config"
+	"github.com/cloud-green/sisyphus/report"
+	"github.com/cloud-green/sisyphus/simulation"
+	"github.com/cloud-green/sisyphus/simulation/call"
+)
+
+func newRecorder() *report.Recorder {
+	r := report.NewRecorder()
+	login := config.Call{Method: "POST", URL: "http://test.com/login"}
+	home := config.Call{Method: "GET", URL: "http://test.com/{user}/home"}
+	for i := 1; i <= 10; i++ {
+		r.StateEntered("user", "login")
+		info := simulation.CallInfo{
+			State:    "login",
+			Target:   "home",
+			Call:     login,
+			Duration: time.Duration(i) * time.Millisecond,
+		}
+		if i%5 == 0 {
+			info.Err = errors.Trace(&call.StatusError{StatusCode: 500})
+		}
+		if i > 8 {
+			info.Target = "admin"
+		}
+		if i == 6 {
+			// retries the failed 5th call
+			info.Attempt = 2
+		}
+		r.Call(info)
+	}
+	for i := 0; i < 2; i++ {
+		r.StateEntered("user", "home")
+		r.Call(simulation.CallInfo{
+			State:    "home",
+			Target:   "logout",
+			Call:     home,
+			Duration: time.Second,
+		})
+	}
+	return r
+}
+
+func TestReport(t *testing.T) {
+	c := qt.New(t)
+
+	rep := newRecorder().Report(42, 4*time.Second)
+	c.Assert(rep, qt.DeepEquals, &report.Report{
+		Seed:     42,
+		Duration: 4 * time.Second,
+		Calls: []report.CallSummary{{
+			Method:     "POST",
+			URL:        "http://test.com/login",
+			Count:      10,
+			Errors:     2,
+			Retries:    1,
+			ErrorRate:  0.2,
+			Throughput: 2.5,
+			P50:        5 * time.Millisecond,
+			P90:        9 * time.Millisecond,
+			P99:        10 * time.Millisecond,
+			Max:        10 * time.Millisecond,
+			Statuses: map[string]int{
+				"500":           2,
+				report.StatusOK: 8,
+			},
+			Transitions: []report.TransitionSummary{{
+				State:  "login",
+				Target: "admin",
+				Count:  2,
+			}, {
+				State:  "login",
+				Target: "home",
+				Count:  8,
+			}},
+		}, {
+			Method:     "GET",
+			URL:        "http://test.com/{user}/home",
+			Count:      2,
+			Throughput: 0.5,
+			P50:        time.Second,
+			P90:        time.Second,
+			P99:        time.Second,
+			Max:        time.Second,
+			Statuses: map[string]int{
+				report.StatusOK: 2,
+			},
+			Transitions: []report.TransitionSummary{{
+				State:  "home",
+				Target: "logout",
+				Count:  2,
+			}},
+		}},
+		States: []report.StateSummary{{
+			State:  "home",
+			Visits: 2,
+		}, {
+			State:  "login",
+			Visits: 10,
+		}},
+	})
+}
+
+func TestReportText(t *testing.T) {
+	c := qt.New(t)
+
+	var buf bytes.Buffer
+	err := newRecorder().Report(42, 4*time.Second).WriteText(&buf)
+	c.Assert(err, qt.IsNil)
+	c.Assert(buf.String(), qt.Equals, `CALL                             COUNT  ERRORS  RETRIES  ERROR RATE  THROUGHPUT  P50  P90  P99   MAX   STATUSES
+POST http://test.com/login       10     2       1        20.00%      2.50/s      5ms  9ms  10ms  10ms  500:2 ok:8
+GET http://test.com/{user}/home  2      0       0        0.00%       0.50/s      1s   1s   1s    1s    ok:2
+
+STATE  VISITS
+home   2
+login  10
+
+TRANSITION      CALL                             COUNT
+login -> admin  POST http://test.com/login       2
+login -> home   POST http://test.com/login       8
+home -> logout  GET http://test.com/{user}/home  2
+`)
+}
+
+func TestReportCSV(t *testing.T) {
+	c := qt.New(t)
+
+	var buf bytes.Buffer
+	err := newRecorder().Report(42, 4*time.Second).WriteCSV(&buf)
+	c.Assert(err, qt.IsNil)
+	c.Assert(buf.String(), qt.Equals, `method,url,count,errors,retries,error-rate,throughput,p50,p90,p99,max,statuses
+POST,http://test.com/login,10,2,1,0.2,2.5,0.005,0.009,0.01,0.01,500:2 ok:8
+GET,http://test.com/{user}/home,2,0,0,0,0.5,1,1,1,1,ok:2
+
+state,visits
+home,2
+login,10
+
+state,target,method,url,count
+login,admin,POST,http://test.com/login,2
+login,home,POST,http://test.com/login,8
+home,logout,GET,http://test.com/{user}/home,2
+`)
+}
+
+func TestReportJSON(t *testing.T) {
+	c := qt.New(t)
+
+	rep := newRecorder().Report(42, 4*time.Second)
+	var buf bytes.Buffer
+	err := rep.WriteJSON(&buf)
+	c.Assert(err, qt.IsNil)
+	var decoded report.Report
+	err = json.Unmarshal(buf.Bytes(), &decoded)
+	c.Assert(err, qt.IsNil)
+	c.Assert(&decoded, qt.DeepEquals, rep)
+}
+
+func TestReportManyCalls(t *testing.T) {
+	c := qt.New(t)
+
+	r := report.NewRecorder()
+	callConfig := config.Call{Method: "GET", URL: "http://test.com/home"}
+	for i := 1; i <= 100000; i++ {
+		r.Call(simulation.CallInfo{
+			Call:     callConfig,
+			Duration: time.Duration(i) * time.Millisecond,
+		})
+	}
+	rep := r.Report(42, 100*time.Second)
+	c.Assert(rep.Calls, qt.HasLen, 1)
+	summary := rep.Calls[0]
+	c.Assert(summary.Count, qt.Equals, 100000)
+	c.Assert(summary.Throughput, qt.Equals, 1000.0)
+	c.Assert(summary.Max, qt.Equals, 100*time.Second)
+	// percentiles are estimated from a sample of the latencies
+	for _, p := range []struct {
+		value    time.Duration
+		expected time.Duration
+	}{
+		{summary.P50, 50 * time.Second},
+		{summary.P90, 90 * time.Second},
+		{summary.P99, 99 * time.Second},
+	} {
+		diff := p.value - p.expected
+		if diff < 0 {
+			diff = -diff
+		}
+		c.Assert(diff < 2*time.Second, qt.Equals, true, qt.Commentf("got %v, expected %v", p.value, p.expected))
+	}
+}
+
+func TestEmptyReport(t *testing.T) {
+	c := qt.New(t)
+
+	rep := report.NewRecorder().Report(1, 0)
+	c.Assert(rep.Calls, qt.HasLen, 0)
+	c.Assert(rep.States, qt.HasLen, 0)
+}
@@ -0,0 +1,197 @@
+// Copyright 2019 CanonicalLtd
+
+package report
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/juju/errors"
+
+	"github.com/cloud-green/sisyphus/config"
+)
+
+// ThresholdResult holds the result of checking a threshold.
+type ThresholdResult struct {
+	// Threshold describes the checked threshold.
+	Threshold string `json:"threshold"`
+	// Value holds the observed value of the checked metric.
+	Value string `json:"value"`
+	// Passed is true if the threshold was not breached.
+	Passed bool `json:"passed"`
+	// Abort is true if the simulation should be stopped once the
+	// threshold is breached.
+	Abort bool `json:"-"`
+}
+
+// Transition implements the simulation.Observer interface.
+func (r *Recorder) Transition(entity, from, to string, failure bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.transitions[to]++
+}
+
+// Check checks the thresholds against the calls and transitions
+// recorded so far. Thresholds are evaluated from the statistics kept
+// for each call template, so checking them repeatedly while the
+// simulation runs does not depend on the number of recorded calls.
+func (r *Recorder) Check(thresholds []config.Threshold) ([]ThresholdResult, error) {
+	results := make([]ThresholdResult, len(thresholds))
+	for i, t := range thresholds {
+		result, err := r.check(t)
+		if err != nil {
+			return nil, errors.Annotatef(err, "cannot check threshold %d", i)
+		}
+		results[i] = result
+	}
+	return results, nil
+}
+
+func (r *Recorder) check(t config.Threshold) (ThresholdResult, error) {
+	result := ThresholdResult{
+		Threshold: t.Name,
+		Abort:     t.Abort,
+	}
+	if result.Threshold == "" {
+		result.Threshold = describeThreshold(t)
+	}
+	switch t.Metric {
+	case config.LatencyThresholdMetric:
+		selected, err := r.selectCalls(t)
+		if err != nil {
+			return result, errors.Trace(err)
+		}
+		if selected.count == 0 {
+			result.Value = "no calls"
+			result.Passed = true
+			return result, nil
+		}
+		sortSamples(selected.samples)
+		latency := percentile(selected.samples, t.Percentile)
+		result.Value = roundDuration(latency).String()
+		result.Passed = latency <= t.MaxLatency
+	case config.ErrorRateThresholdMetric:
+		selected, err := r.selectCalls(t)
+		if err != nil {
+			return result, errors.Trace(err)
+		}
+		rate := 0.0
+		if selected.count > 0 {
+			rate = float64(selected.errors) / float64(selected.count)
+		}
+		result.Value = fmt.Sprintf("%s (%d/%d)", formatPercent(rate), selected.errors, selected.count)
+		result.Passed = rate <= t.MaxErrorRate
+	case config.TransitionsThresholdMetric:
+		r.mu.Lock()
+		n := r.transitions[t.State]
+		r.mu.Unlock()
+		result.Value = strconv.FormatInt(n, 10)
+		result.Passed = n >= int64(t.MinCount)
+	default:
+		return result, errors.Errorf("unknown metric %q", t.Metric)
+	}
+	return result, nil
+}
+
+// selectedCalls holds the statistics of the calls selected by a
+// threshold.
+type selectedCalls struct {
+	count   int
+	errors  int
+	samples []sample
+}
+
+// selectionKey identifies the calls selected by thresholds with the
+// same method and URL.
+type selectionKey struct {
+	method, url string
+}
+
+// selection holds the call templates selected by thresholds. Each
+// template is matched once, the first time the thresholds are checked
+// after its first call.
+type selection struct {
+	url *regexp.Regexp
+	// stats holds the statistics of the selected templates.
+	stats []*callStats
+	// checked holds the number of templates matched so far, in
+	// the order of their first call.
+	checked int
+}
+
+// selectCalls returns the statistics of the recorded calls selected by
+// the threshold, computed from the statistics of the selected call
+// templates.
+func (r *Recorder) selectCalls(t config.Threshold) (selectedCalls, error) {
+	var selected selectedCalls
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := selectionKey{t.Method, t.URL}
+	sel := r.selections[key]
+	if sel == nil {
+		url, err := regexp.Compile(t.URL)
+		if err != nil {
+			return selected, errors.Trace(err)
+		}
+		sel = &selection{
+			url: url,
+		}
+		r.selections[key] = sel
+	}
+	for _, tmpl := range r.order[sel.checked:] {
+		if (t.Method == "" || t.Method == tmpl.method) && sel.url.MatchString(tmpl.url) {
+			sel.stats = append(sel.stats, r.templates[tmpl])
+		}
+	}
+	sel.checked = len(r.order)
+	for _, s := range sel.stats {
+		selected.count += s.count
+		selected.errors += s.errors
+		if t.Metric == config.LatencyThresholdMetric {
+			selected.samples = append(selected.samples, s.weightedSamples()...)
+		}
+	}
+	return selected, nil
+}
+
+// describeThreshold returns a description of the threshold.
+func describeThreshold(t config.Threshold) string {
+	switch t.Metric {
+	case config.LatencyThresholdMetric:
+		return fmt.Sprintf("p%v latency of %s <= %v", t.Percentile, describeCalls(t), t.MaxLatency)
+	case config.ErrorRateThresholdMetric:
+		return fmt.Sprintf("error rate of %s <= %s", describeCalls(t), formatPercent(t.MaxErrorRate))
+	case config.TransitionsThresholdMetric:
+		return fmt.Sprintf("transitions to %s >= %d", t.State, t.MinCount)
+	}
+	return string(t.Metric)
+}
+
+// describeCalls describes the calls selected by the threshold.
+func describeCalls(t config.Threshold) string {
+	parts := []string{}
+	if t.Method != "" {
+		parts = append(parts, t.Method)
+	}
+	parts = append(parts, "calls")
+	if t.URL != "" {
+		parts = append(parts, "matching", t.URL)
+	}
+	return strings.Join(parts, " ")
+}
+
+func formatPercent(f float64) string {
+	return strconv.FormatFloat(100*f, 'g', 4, 64) + "%"
+}
+
+// Passed reports whether none of the thresholds was breached.
+func (r *Report) Passed() bool {
+	for _, t := range r.Thresholds {
+		if !t.Passed {
+			return false
+		}
+	}
+	return true
+}
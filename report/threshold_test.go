@@ -0,0 +1,193 @@
+// Copyright 2019 CanonicalLtd
+
+package report_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/errors"
+
+	"github.com/cloud-green/sisyphus/config"
+	"github.com/cloud-green/sisyphus/report"
+	"github.com/cloud-green/sisyphus/simulation"
+)
+
+func TestCheck(t *testing.T) {
+	c := qt.New(t)
+
+	r := newRecorder()
+	for i := 0; i < 3; i++ {
+		r.Transition("user", "login", "home", false)
+	}
+
+	tests := []struct {
+		about          string
+		threshold      config.Threshold
+		expectedResult report.ThresholdResult
+	}{{
+		about: "latency below threshold",
+		threshold: config.Threshold{
+			Metric:     config.LatencyThresholdMetric,
+			URL:        "/login$",
+			Percentile: 90,
+			MaxLatency: 10 * time.Millisecond,
+		},
+		expectedResult: report.ThresholdResult{
+			Threshold: "p90 latency of calls matching /login$ <= 10ms",
+			Value:     "9ms",
+			Passed:    true,
+		},
+	}, {
+		about: "latency above threshold",
+		threshold: config.Threshold{
+			Name:       "login latency",
+			Metric:     config.LatencyThresholdMetric,
+			Method:     "POST",
+			Percentile: 99.9,
+			MaxLatency: 5 * time.Millisecond,
+			Abort:      true,
+		},
+		expectedResult: report.ThresholdResult{
+			Threshold: "login latency",
+			Value:     "10ms",
+			Abort:     true,
+		},
+	}, {
+		about: "latency without calls",
+		threshold: config.Threshold{
+			Metric:     config.LatencyThresholdMetric,
+			URL:        "/checkout",
+			Percentile: 50,
+			MaxLatency: time.Millisecond,
+		},
+		expectedResult: report.ThresholdResult{
+			Threshold: "p50 latency of calls matching /checkout <= 1ms",
+			Value:     "no calls",
+			Passed:    true,
+		},
+	}, {
+		about: "error rate above threshold",
+		threshold: config.Threshold{
+			Metric:       config.ErrorRateThresholdMetric,
+			MaxErrorRate: 0.01,
+		},
+		expectedResult: report.ThresholdResult{
+			Threshold: "error rate of calls <= 1%",
+			Value:     "16.67% (2/12)",
+		},
+	}, {
+		about: "error rate below threshold",
+		threshold: config.Threshold{
+			Metric:       config.ErrorRateThresholdMetric,
+			Method:       "GET",
+			MaxErrorRate: 0,
+		},
+		expectedResult: report.ThresholdResult{
+			Threshold: "error rate of GET calls <= 0%",
+			Value:     "0% (0/2)",
+			Passed:    true,
+		},
+	}, {
+		about: "enough transitions",
+		threshold: config.Threshold{
+			Metric:   config.TransitionsThresholdMetric,
+			State:    "home",
+			MinCount: 3,
+		},
+		expectedResult: report.ThresholdResult{
+			Threshold: "transitions to home >= 3",
+			Value:     "3",
+			Passed:    true,
+		},
+	}, {
+		about: "not enough transitions",
+		threshold: config.Threshold{
+			Metric:   config.TransitionsThresholdMetric,
+			State:    "checkout",
+			MinCount: 1,
+		},
+		expectedResult: report.ThresholdResult{
+			Threshold: "transitions to checkout >= 1",
+			Value:     "0",
+		},
+	}}
+	for i, test := range tests {
+		c.Logf("running test %d: %s", i, test.about)
+		results, err := r.Check([]config.Threshold{test.threshold})
+		c.Assert(err, qt.IsNil)
+		c.Assert(results, qt.DeepEquals, []report.ThresholdResult{test.expectedResult})
+	}
+}
+
+func TestReportThresholds(t *testing.T) {
+	c := qt.New(t)
+
+	r := newRecorder()
+	rep := r.Report(42, 4*time.Second)
+	c.Assert(rep.Passed(), qt.Equals, true)
+
+	results, err := r.Check([]config.Threshold{{
+		Metric:     config.LatencyThresholdMetric,
+		Percentile: 50,
+		MaxLatency: time.Second,
+	}, {
+		Metric:       config.ErrorRateThresholdMetric,
+		MaxErrorRate: 0.1,
+	}})
+	c.Assert(err, qt.IsNil)
+	rep.Thresholds = results
+	c.Assert(rep.Passed(), qt.Equals, false)
+
+	var buf bytes.Buffer
+	err = rep.WriteText(&buf)
+	c.Assert(err, qt.IsNil)
+	out := buf.String()
+	c.Assert(out[strings.Index(out, "THRESHOLD"):], qt.Equals, `THRESHOLD                   VALUE          RESULT
+p50 latency of calls <= 1s  6ms            PASS
+error rate of calls <= 10%  16.67% (2/12)  FAIL
+`)
+}
+
+func TestCheckIncremental(t *testing.T) {
+	c := qt.New(t)
+
+	r := newRecorder()
+	thresholds := []config.Threshold{{
+		Metric:       config.ErrorRateThresholdMetric,
+		URL:          "/home$",
+		MaxErrorRate: 0,
+	}, {
+		Metric:     config.LatencyThresholdMetric,
+		URL:        "/home$",
+		Percentile: 50,
+		MaxLatency: time.Second,
+	}}
+	results, err := r.Check(thresholds)
+	c.Assert(err, qt.IsNil)
+	c.Assert(results[0].Value, qt.Equals, "0% (0/2)")
+	c.Assert(results[1].Value, qt.Equals, "1s")
+
+	// calls recorded after a check, including calls to new
+	// templates, are taken into account by the next check.
+	r.Call(simulation.CallInfo{
+		Call:     config.Call{Method: "GET", URL: "http://test.com/{user}/home"},
+		Duration: 2 * time.Second,
+		Err:      errors.New("failed"),
+	})
+	for i := 0; i < 2; i++ {
+		r.Call(simulation.CallInfo{
+			Call:     config.Call{Method: "GET", URL: "http://test.com/home"},
+			Duration: 3 * time.Second,
+		})
+	}
+	results, err = r.Check(thresholds)
+	c.Assert(err, qt.IsNil)
+	c.Assert(results[0].Value, qt.Equals, "20% (1/5)")
+	c.Assert(results[0].Passed, qt.Equals, false)
+	c.Assert(results[1].Value, qt.Equals, "2s")
+	c.Assert(results[1].Passed, qt.Equals, false)
+}
@@ -0,0 +1,292 @@
+// Copyright 2019 CanonicalLtd
+
+package call
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"mime/multipart"
+	"net/textproto"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/juju/errors"
+	yaml "gopkg.in/yaml.v1"
+
+	"github.com/cloud-green/sisyphus/config"
+)
+
+// requestBody returns the body of the request of the call and its
+// content type, or a nil body if the request has no body.
+func (c *httpCallBackend) requestBody(ctx context.Context, call config.Call, attributes Attributes) (io.ReadSeeker, string, error) {
+	fields := make(map[string]interface{})
+	for _, p := range call.Parameters {
+		if p.Type == config.BodyCallParameterType {
+			fields[p.Key] = attributes[p.Attribute]
+		}
+	}
+	body := call.Body
+	if body == nil {
+		if len(fields) == 0 {
+			return nil, "", nil
+		}
+		body = &config.Body{}
+	}
+	data, contentType, err := c.encodeBody(randFromContext(ctx), *body, fields, attributes)
+	if err != nil {
+		return nil, "", errors.Trace(err)
+	}
+	if body.ContentType != "" {
+		contentType = body.ContentType
+	}
+	return bytes.NewReader(data), contentType, nil
+}
+
+// encodeBody returns the encoded body, holding the body parameter
+// fields, and its default content type. Generated data is drawn from
+// the source of randomness.
+func (c *httpCallBackend) encodeBody(rnd *rand.Rand, body config.Body, fields map[string]interface{}, attributes Attributes) ([]byte, string, error) {
+	switch body.Type {
+	case config.TextBody:
+		text, _ := body.Template.(string)
+		if body.File != "" {
+			data, err := c.readFile(body.File)
+			if err != nil {
+				return nil, "", errors.Trace(err)
+			}
+			text = string(data)
+		}
+		return []byte(attributes.renderString(text)), "text/plain; charset=utf-8", nil
+	case config.BinaryBody:
+		if body.File != "" {
+			data, err := c.readFile(body.File)
+			if err != nil {
+				return nil, "", errors.Trace(err)
+			}
+			return data, "application/octet-stream", nil
+		}
+		return randomData(rnd, body.Size), "application/octet-stream", nil
+	}
+
+	template, err := c.template(body)
+	if err != nil {
+		return nil, "", errors.Trace(err)
+	}
+	value := render(template, attributes)
+	if value == nil {
+		value = make(map[string]interface{})
+	}
+	if len(fields) > 0 || body.Type == config.FormBody || body.Type == config.MultipartBody {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, "", errors.Errorf("%s body template is not an object", bodyType(body))
+		}
+		value = merge(object, fields)
+	}
+	switch body.Type {
+	case "", config.JSONBody:
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, "", errors.Trace(err)
+		}
+		return data, "application/json", nil
+	case config.FormBody:
+		values := make(url.Values)
+		for k, v := range value.(map[string]interface{}) {
+			for _, s := range fieldValues(v) {
+				values.Add(k, s)
+			}
+		}
+		return []byte(values.Encode()), "application/x-www-form-urlencoded", nil
+	case config.MultipartBody:
+		return encodeMultipart(rnd, value.(map[string]interface{}), body.Files, attributes)
+	}
+	return nil, "", errors.Errorf("unknown body type %q", body.Type)
+}
+
+// template returns the template of the body, read from its file if
+// specified.
+func (c *httpCallBackend) template(body config.Body) (interface{}, error) {
+	if body.File == "" {
+		return body.Template, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t, ok := c.templates[body.File]; ok {
+		return t, nil
+	}
+	data, err := ioutil.ReadFile(body.File)
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot read body template")
+	}
+	var t interface{}
+	if err := yaml.Unmarshal(data, &t); err != nil {
+		return nil, errors.Annotatef(err, "cannot parse body template %q", body.File)
+	}
+	if c.templates == nil {
+		c.templates = make(map[string]interface{})
+	}
+	c.templates[body.File] = t
+	return t, nil
+}
+
+// readFile returns the content of the file.
+func (c *httpCallBackend) readFile(name string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if data, ok := c.files[name]; ok {
+		return data, nil
+	}
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot read body file")
+	}
+	if c.files == nil {
+		c.files = make(map[string][]byte)
+	}
+	c.files[name] = data
+	return data, nil
+}
+
+// render returns the template with the placeholders in its strings
+// replaced by attribute values. Strings consisting of a single
+// placeholder are replaced by the value of the attribute.
+func render(template interface{}, attributes Attributes) interface{} {
+	switch t := template.(type) {
+	case string:
+		if match := attributePlaceholder.FindStringIndex(t); match != nil && match[0] == 0 && match[1] == len(t) {
+			if value, ok := attributes[t[1:len(t)-1]]; ok {
+				return value
+			}
+		}
+		return attributes.renderString(t)
+	case []interface{}:
+		values := make([]interface{}, len(t))
+		for i, e := range t {
+			values[i] = render(e, attributes)
+		}
+		return values
+	case map[interface{}]interface{}:
+		object := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			object[fmt.Sprint(k)] = render(e, attributes)
+		}
+		return object
+	case map[string]interface{}:
+		object := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			object[k] = render(e, attributes)
+		}
+		return object
+	}
+	return template
+}
+
+// merge returns an object holding the fields of both objects.
+func merge(object, fields map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(object)+len(fields))
+	for k, v := range object {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return merged
+}
+
+// fieldValues returns the form values of the field value.
+func fieldValues(v interface{}) []string {
+	switch v := v.(type) {
+	case nil:
+		return []string{""}
+	case []interface{}:
+		values := make([]string, len(v))
+		for i, e := range v {
+			values[i] = fmt.Sprint(e)
+		}
+		return values
+	case []string:
+		return v
+	}
+	return []string{fmt.Sprint(v)}
+}
+
+// encodeMultipart returns the multipart/form-data encoded fields and
+// files of random data drawn from the source of randomness.
+func encodeMultipart(rnd *rand.Rand, fields map[string]interface{}, files []config.FileUpload, attributes Attributes) ([]byte, string, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range fieldValues(fields[name]) {
+			if err := w.WriteField(name, value); err != nil {
+				return nil, "", errors.Trace(err)
+			}
+		}
+	}
+	for _, f := range files {
+		contentType := f.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name=%q; filename=%q`, f.Field, attributes.renderString(f.Name)))
+		header.Set("Content-Type", contentType)
+		part, err := w.CreatePart(header)
+		if err != nil {
+			return nil, "", errors.Trace(err)
+		}
+		if _, err := part.Write(randomData(rnd, f.Size)); err != nil {
+			return nil, "", errors.Trace(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, "", errors.Trace(err)
+	}
+	return buf.Bytes(), w.FormDataContentType(), nil
+}
+
+// randomData returns size bytes of random data drawn from the source
+// of randomness.
+func randomData(rnd *rand.Rand, size int) []byte {
+	data := make([]byte, size)
+	rnd.Read(data)
+	return data
+}
+
+type randKey struct{}
+
+// ContextWithRand returns a context holding the source of randomness
+// used to generate the random data sent by calls, so that simulations
+// with the same seed send the same data. The source must not be used
+// concurrently by other calls.
+func ContextWithRand(ctx context.Context, rnd *rand.Rand) context.Context {
+	return context.WithValue(ctx, randKey{}, rnd)
+}
+
+// randFromContext returns the source of randomness held by the
+// context or, if there is none, a source seeded with the current
+// time.
+func randFromContext(ctx context.Context) *rand.Rand {
+	if rnd, ok := ctx.Value(randKey{}).(*rand.Rand); ok && rnd != nil {
+		return rnd
+	}
+	return rand.New(rand.NewSource(time.Now().UnixNano()))
+}
+
+func bodyType(body config.Body) config.BodyType {
+	if body.Type == "" {
+		return config.JSONBody
+	}
+	return body.Type
+}
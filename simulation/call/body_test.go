@@ -0,0 +1,247 @@
+// Copyright 2019 CanonicalLtd
+
+package call_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	yaml "gopkg.in/yaml.v1"
+
+	"github.com/cloud-green/sisyphus/config"
+	"github.com/cloud-green/sisyphus/simulation/call"
+)
+
+func TestHTTPCallBackendBody(t *testing.T) {
+	c := qt.New(t)
+
+	dir, err := ioutil.TempDir("", "sisyphus")
+	c.Assert(err, qt.IsNil)
+	defer os.RemoveAll(dir)
+	templateFile := filepath.Join(dir, "order.yaml")
+	err = ioutil.WriteFile(templateFile, []byte("order:\n  id: '{order-id}'\n  note: order {order-id}\n"), 0644)
+	c.Assert(err, qt.IsNil)
+	dataFile := filepath.Join(dir, "data.bin")
+	err = ioutil.WriteFile(dataFile, []byte{0, 1, 2, 3}, 0644)
+	c.Assert(err, qt.IsNil)
+
+	attributes := call.Attributes{
+		"name":     "alice",
+		"age":      42,
+		"tags":     []interface{}{"a", "b"},
+		"order-id": 7,
+	}
+
+	tests := []struct {
+		about               string
+		call                string
+		expectedContentType string
+		expectedBody        string
+		expectedSize        int
+		expectedError       string
+	}{{
+		about: "json template",
+		call: `
+body:
+  template:
+    user:
+      name: "{name}"
+      age: "{age}"
+      greeting: hello {name}
+    tags: "{tags}"
+    items: [1, "{age}", "{unknown}"]
+`,
+		expectedContentType: "application/json",
+		expectedBody:        `{"items":[1,42,"{unknown}"],"tags":["a","b"],"user":{"age":42,"greeting":"hello alice","name":"alice"}}`,
+	}, {
+		about: "json template with body parameters",
+		call: `
+params:
+- type: body
+  attribute: age
+  key: age
+body:
+  template:
+    name: "{name}"
+`,
+		expectedContentType: "application/json",
+		expectedBody:        `{"age":42,"name":"alice"}`,
+	}, {
+		about: "json template file",
+		call: `
+body:
+  file: ` + templateFile + `
+`,
+		expectedContentType: "application/json",
+		expectedBody:        `{"order":{"id":7,"note":"order 7"}}`,
+	}, {
+		about: "form body",
+		call: `
+params:
+- type: body
+  attribute: name
+  key: user
+body:
+  type: form-urlencoded
+  template:
+    age: "{age}"
+    tag: "{tags}"
+`,
+		expectedContentType: "application/x-www-form-urlencoded",
+		expectedBody:        `age=42&tag=a&tag=b&user=alice`,
+	}, {
+		about: "text body",
+		call: `
+body:
+  type: text
+  template: "name={name}, age={age}, {unknown}"
+  content-type: text/csv
+`,
+		expectedContentType: "text/csv",
+		expectedBody:        `name=alice, age=42, {unknown}`,
+	}, {
+		about: "binary body from a file",
+		call: `
+body:
+  type: binary
+  file: ` + dataFile + `
+`,
+		expectedContentType: "application/octet-stream",
+		expectedBody:        "\x00\x01\x02\x03",
+	}, {
+		about: "generated binary body",
+		call: `
+body:
+  type: binary
+  size: 1024
+`,
+		expectedContentType: "application/octet-stream",
+		expectedSize:        1024,
+	}, {
+		about: "form body template not an object",
+		call: `
+body:
+  type: form-urlencoded
+  template: "{tags}"
+`,
+		expectedError: `form-urlencoded body template is not an object`,
+	}, {
+		about: "missing template file",
+		call: `
+body:
+  file: ` + filepath.Join(dir, "missing.yaml") + `
+`,
+		expectedError: `cannot read body template: .*`,
+	}}
+
+	for i, test := range tests {
+		c.Logf("running test %d: %s", i, test.about)
+		var callConfig config.Call
+		err := yaml.Unmarshal([]byte(test.call), &callConfig)
+		c.Assert(err, qt.IsNil)
+		callConfig.Method = "POST"
+		callConfig.URL = "/v1/test"
+
+		client := &testHTTPClient{
+			responseStatus: http.StatusOK,
+		}
+		backend := call.NewHTTPCallBackend(client)
+		_, err = backend.Do(context.Background(), callConfig, attributes)
+		if test.expectedError != "" {
+			c.Assert(err, qt.ErrorMatches, test.expectedError)
+			continue
+		}
+		c.Assert(err, qt.IsNil)
+		c.Assert(client.call.Header.Get("Content-Type"), qt.Equals, test.expectedContentType)
+		if test.expectedSize > 0 {
+			c.Assert(client.call.Body, qt.HasLen, test.expectedSize)
+		} else {
+			c.Assert(string(client.call.Body), qt.Equals, test.expectedBody)
+		}
+	}
+}
+
+func TestHTTPCallBackendMultipartBody(t *testing.T) {
+	c := qt.New(t)
+
+	var callConfig config.Call
+	err := yaml.Unmarshal([]byte(`
+method: POST
+url: /v1/upload
+params:
+- type: body
+  attribute: name
+  key: owner
+body:
+  type: multipart
+  template:
+    description: photo of {name}
+  files:
+  - field: photo
+    name: "{name}.png"
+    size: 2048
+    content-type: image/png
+  - field: attachment
+    name: notes.bin
+    size: 10
+`), &callConfig)
+	c.Assert(err, qt.IsNil)
+
+	client := &testHTTPClient{
+		responseStatus: http.StatusOK,
+	}
+	backend := call.NewHTTPCallBackend(client)
+	_, err = backend.Do(context.Background(), callConfig, call.Attributes{"name": "alice"})
+	c.Assert(err, qt.IsNil)
+
+	mediaType, params, err := mime.ParseMediaType(client.call.Header.Get("Content-Type"))
+	c.Assert(err, qt.IsNil)
+	c.Assert(mediaType, qt.Equals, "multipart/form-data")
+	form, err := multipart.NewReader(bytes.NewReader(client.call.Body), params["boundary"]).ReadForm(1 << 20)
+	c.Assert(err, qt.IsNil)
+	c.Assert(form.Value, qt.DeepEquals, map[string][]string{
+		"description": {"photo of alice"},
+		"owner":       {"alice"},
+	})
+	c.Assert(form.File["photo"], qt.HasLen, 1)
+	c.Assert(form.File["photo"][0].Filename, qt.Equals, "alice.png")
+	c.Assert(form.File["photo"][0].Header.Get("Content-Type"), qt.Equals, "image/png")
+	c.Assert(form.File["photo"][0].Size, qt.Equals, int64(2048))
+	c.Assert(form.File["attachment"], qt.HasLen, 1)
+	c.Assert(form.File["attachment"][0].Header.Get("Content-Type"), qt.Equals, "application/octet-stream")
+	c.Assert(form.File["attachment"][0].Size, qt.Equals, int64(10))
+}
+
+func TestHTTPCallBackendSeededBody(t *testing.T) {
+	c := qt.New(t)
+
+	callConfig := config.Call{
+		Method: "POST",
+		URL:    "/v1/upload",
+		Body: &config.Body{
+			Type: config.BinaryBody,
+			Size: 64,
+		},
+	}
+	body := func(seed int64) []byte {
+		client := &testHTTPClient{
+			responseStatus: http.StatusOK,
+		}
+		ctx := call.ContextWithRand(context.Background(), rand.New(rand.NewSource(seed)))
+		_, err := call.NewHTTPCallBackend(client).Do(ctx, callConfig, call.Attributes{})
+		c.Assert(err, qt.IsNil)
+		return client.call.Body
+	}
+	// the same source of randomness generates the same data
+	c.Assert(body(1), qt.DeepEquals, body(1))
+	c.Assert(body(1), qt.Not(qt.DeepEquals), body(2))
+}
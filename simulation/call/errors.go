@@ -0,0 +1,110 @@
+// Copyright 2019 CanonicalLtd
+
+package call
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// Error causes returned by ErrorCause.
+const (
+	CauseTimeout   = "timeout"
+	CauseCanceled  = "canceled"
+	CauseSendError = "send-error"
+	CauseThrottled = "throttled"
+	CauseExpect    = "expect"
+	CauseOther     = "other"
+)
+
+// ErrThrottled is returned for calls that were not performed because
+// they exceeded a rate limit.
+var ErrThrottled = errors.New("call throttled")
+
+// StatusError is returned by the http call backend when the
+// response has an unexpected status code.
+type StatusError struct {
+	StatusCode int
+}
+
+// Error implements the error interface.
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("received status code %v", e.StatusCode)
+}
+
+// TimeoutError is returned for calls that did not complete within
+// their timeout.
+type TimeoutError struct {
+	Timeout time.Duration
+}
+
+// Error implements the error interface.
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("call timed out after %v", e.Timeout)
+}
+
+// ExpectationError is returned by the http call backend when the
+// response fails an assertion of the call's expect criteria.
+type ExpectationError struct {
+	// Assertion holds the name of the failed assertion.
+	Assertion string
+	// Message describes the failure.
+	Message string
+}
+
+// Error implements the error interface.
+func (e *ExpectationError) Error() string {
+	return fmt.Sprintf("expectation %q failed: %s", e.Assertion, e.Message)
+}
+
+// SendError is returned by the kafka call backend when a message
+// could not be sent.
+type SendError struct {
+	Topic string
+	Err   error
+}
+
+// Error implements the error interface.
+func (e *SendError) Error() string {
+	return fmt.Sprintf("failed to send to topic %q: %v", e.Topic, e.Err)
+}
+
+// ErrorCause classifies the error returned by a call backend. It
+// returns the status code for unexpected http responses, CauseTimeout,
+// CauseCanceled, CauseSendError for failures to send kafka messages,
+// CauseExpect followed by a colon and the name of the failed assertion
+// (e.g. expect:body.id), CauseThrottled or CauseOther. It returns an
+// empty string for a nil error.
+func ErrorCause(err error) string {
+	if err == nil {
+		return ""
+	}
+	switch cause := errors.Cause(err).(type) {
+	case *StatusError:
+		return strconv.Itoa(cause.StatusCode)
+	case *TimeoutError:
+		return CauseTimeout
+	case *SendError:
+		return CauseSendError
+	case *ExpectationError:
+		return CauseExpect + ":" + cause.Assertion
+	case net.Error:
+		if cause.Timeout() {
+			return CauseTimeout
+		}
+	}
+	switch errors.Cause(err) {
+	case context.DeadlineExceeded:
+		return CauseTimeout
+	case context.Canceled:
+		return CauseCanceled
+	case ErrThrottled:
+		return CauseThrottled
+	}
+	return CauseOther
+}
@@ -0,0 +1,72 @@
+// Copyright 2019 CanonicalLtd
+
+package call_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/errors"
+
+	"github.com/cloud-green/sisyphus/simulation/call"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestErrorCause(t *testing.T) {
+	c := qt.New(t)
+
+	tests := []struct {
+		about         string
+		err           error
+		expectedCause string
+	}{{
+		about: "no error",
+	}, {
+		about:         "status error",
+		err:           errors.Trace(&call.StatusError{StatusCode: 503}),
+		expectedCause: "503",
+	}, {
+		about:         "send error",
+		err:           errors.Trace(&call.SendError{Topic: "test", Err: errors.New("broken")}),
+		expectedCause: call.CauseSendError,
+	}, {
+		about:         "expectation error",
+		err:           errors.Trace(&call.ExpectationError{Assertion: "body.id", Message: "not found"}),
+		expectedCause: "expect:body.id",
+	}, {
+		about:         "timeout error",
+		err:           errors.Wrap(context.DeadlineExceeded, &call.TimeoutError{Timeout: time.Second}),
+		expectedCause: call.CauseTimeout,
+	}, {
+		about:         "context deadline exceeded",
+		err:           errors.Trace(context.DeadlineExceeded),
+		expectedCause: call.CauseTimeout,
+	}, {
+		about:         "network timeout",
+		err:           errors.Trace(timeoutError{}),
+		expectedCause: call.CauseTimeout,
+	}, {
+		about:         "context canceled",
+		err:           context.Canceled,
+		expectedCause: call.CauseCanceled,
+	}, {
+		about:         "throttled",
+		err:           errors.Trace(call.ErrThrottled),
+		expectedCause: call.CauseThrottled,
+	}, {
+		about:         "other error",
+		err:           errors.New("something went wrong"),
+		expectedCause: call.CauseOther,
+	}}
+	for i, test := range tests {
+		c.Logf("running test %d: %s", i, test.about)
+		c.Assert(call.ErrorCause(test.err), qt.Equals, test.expectedCause)
+	}
+}
@@ -0,0 +1,223 @@
+// Copyright 2019 CanonicalLtd
+
+package call
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/juju/errors"
+
+	"github.com/cloud-green/sisyphus/config"
+	"github.com/cloud-green/sisyphus/jsonpath"
+)
+
+// check returns an ExpectationError if the response, received after
+// the specified latency, fails an assertion of the expectation.
+func (r *httpResponse) check(e *config.Expect, latency time.Duration) error {
+	if e == nil {
+		return nil
+	}
+	if e.MaxLatency > 0 && latency > e.MaxLatency {
+		return &ExpectationError{
+			Assertion: "latency",
+			Message:   fmt.Sprintf("latency %v exceeds %v", latency, e.MaxLatency),
+		}
+	}
+	for _, a := range e.Headers {
+		var value interface{}
+		values := r.Header[http.CanonicalHeaderKey(a.Key)]
+		if len(values) > 0 {
+			value = values[0]
+		}
+		if msg := assert(a, value, len(values) > 0); msg != "" {
+			return &ExpectationError{
+				Assertion: a.AssertionName("header"),
+				Message:   msg,
+			}
+		}
+	}
+	for _, a := range e.Body {
+		value, ok, err := r.bodyValue(a)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if msg := assert(a, value, ok); msg != "" {
+			return &ExpectationError{
+				Assertion: a.AssertionName("body"),
+				Message:   msg,
+			}
+		}
+	}
+	return nil
+}
+
+// bodyValue returns the value of the body the assertion applies to: the
+// JSON value at its key, the decoded JSON body for schema assertions
+// without a key, or the body text otherwise.
+func (r *httpResponse) bodyValue(a config.Assertion) (interface{}, bool, error) {
+	if a.Key == "" && a.Schema == nil {
+		body, err := r.readBody()
+		if err != nil {
+			return nil, false, errors.Trace(err)
+		}
+		return string(body), true, nil
+	}
+	path := "$"
+	if a.Key != "" {
+		path = a.Key
+	}
+	p, err := jsonpath.Parse(path)
+	if err != nil {
+		return nil, false, errors.Annotatef(err, "invalid path %q", a.Key)
+	}
+	doc, err := r.json()
+	if err != nil {
+		return nil, false, errors.Trace(&ExpectationError{
+			Assertion: a.AssertionName("body"),
+			Message:   "body is not valid JSON",
+		})
+	}
+	value, ok := p.Select(doc)
+	return value, ok, nil
+}
+
+// assert returns a description of the failure of the assertion on the
+// value, or an empty string if it holds. The ok argument reports
+// whether the response holds the value.
+func assert(a config.Assertion, value interface{}, ok bool) string {
+	if !ok {
+		return "value not found"
+	}
+	if a.Equals != nil && !equal(value, a.Equals) {
+		return fmt.Sprintf("got %v, expected %v", value, a.Equals)
+	}
+	if a.Matches != "" {
+		re, err := regexp.Compile(a.Matches)
+		if err != nil {
+			return fmt.Sprintf("invalid regular expression %q", a.Matches)
+		}
+		if !re.MatchString(fmt.Sprint(value)) {
+			return fmt.Sprintf("value does not match %q", a.Matches)
+		}
+	}
+	if a.Schema != nil {
+		path := "$"
+		if a.Key != "" {
+			path = a.Key
+		}
+		return checkSchema(path, value, a.Schema)
+	}
+	return ""
+}
+
+// equal reports whether the response value equals the expected value.
+// Numbers are compared by value and strings are compared to the
+// expected value formatted as a string.
+func equal(value, expected interface{}) bool {
+	if s, ok := value.(string); ok {
+		return s == fmt.Sprint(expected)
+	}
+	v, ok1 := number(value)
+	e, ok2 := number(expected)
+	if ok1 && ok2 {
+		return v == e
+	}
+	return reflect.DeepEqual(value, expected)
+}
+
+func number(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// checkSchema returns a description of the first difference between the
+// value at the path and the schema, or an empty string if the value
+// conforms to the schema.
+func checkSchema(path string, value interface{}, s *config.Schema) string {
+	if s.Type != "" && !hasType(value, s.Type) {
+		return fmt.Sprintf("%s: expected %s, got %s", path, s.Type, jsonType(value))
+	}
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			if equal(value, e) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Sprintf("%s: unexpected value %v", path, value)
+		}
+	}
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := value[name]; !ok {
+				return fmt.Sprintf("%s: missing field %q", path, name)
+			}
+		}
+		names := make([]string, 0, len(s.Properties))
+		for name := range s.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if v, ok := value[name]; ok && s.Properties[name] != nil {
+				if msg := checkSchema(path+"."+name, v, s.Properties[name]); msg != "" {
+					return msg
+				}
+			}
+		}
+	case []interface{}:
+		if s.Items == nil {
+			break
+		}
+		for i, v := range value {
+			if msg := checkSchema(fmt.Sprintf("%s[%d]", path, i), v, s.Items); msg != "" {
+				return msg
+			}
+		}
+	}
+	return ""
+}
+
+// hasType reports whether the value has the JSON schema type.
+func hasType(value interface{}, t string) bool {
+	if t == "integer" {
+		f, ok := number(value)
+		return ok && f == math.Trunc(f)
+	}
+	return jsonType(value) == t
+}
+
+// jsonType returns the JSON schema type of the value.
+func jsonType(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case int, int64, float64:
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
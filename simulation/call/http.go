@@ -3,12 +3,12 @@
 package call
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/juju/errors"
 
@@ -30,8 +30,32 @@ func NewHTTPCallBackend(client HTTPClient) *httpCallBackend {
 	}
 }
 
+// NewSessionHTTPCallBackend returns a new call backend that makes http
+// calls using a client per session, so that sessions do not share
+// cookies. The newClient function is called to create the client of
+// each session and of calls made without a session; isolated clients
+// must not share connections with other clients.
+func NewSessionHTTPCallBackend(newClient func(isolated bool) HTTPClient) *httpCallBackend {
+	return &httpCallBackend{
+		client:    newClient(false),
+		newClient: newClient,
+	}
+}
+
 type httpCallBackend struct {
-	client HTTPClient
+	client    HTTPClient
+	newClient func(isolated bool) HTTPClient
+
+	// mu protects the body templates and files read by the
+	// backend.
+	mu        sync.Mutex
+	templates map[string]interface{}
+	files     map[string][]byte
+}
+
+// Name returns the name of the backend.
+func (c *httpCallBackend) Name() string {
+	return string(config.HTTPCallBackend)
 }
 
 // Do implements the CallBackend interface.
@@ -46,24 +70,17 @@ func (c *httpCallBackend) Do(ctx context.Context, call config.Call, attributes A
 	resultAttributes := attributes
 
 	url := attributes.renderString(call.URL)
-	var reader io.ReadSeeker
-	bodyContent := make(map[string]interface{})
-	for _, p := range call.Parameters {
-		if p.Type == config.BodyCallParameterType {
-			bodyContent[p.Key] = attributes[p.Attribute]
-		}
-	}
-	if len(bodyContent) > 0 {
-		data, err := json.Marshal(bodyContent)
-		if err != nil {
-			return resultAttributes, errors.Trace(err)
-		}
-		reader = bytes.NewReader(data)
+	reader, contentType, err := c.requestBody(ctx, call, attributes)
+	if err != nil {
+		return resultAttributes, errors.Trace(err)
 	}
 	request, err := http.NewRequest(call.Method, url, nil)
 	if err != nil {
 		return resultAttributes, errors.Trace(err)
 	}
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
 	queryValues := request.URL.Query()
 	for _, p := range call.Parameters {
 		switch p.Type {
@@ -77,29 +94,51 @@ func (c *httpCallBackend) Do(ctx context.Context, call config.Call, attributes A
 		}
 	}
 	request.URL.RawQuery = queryValues.Encode()
-	response, err := c.client.DoWithBody(request, reader)
+	start := time.Now()
+	response, err := c.sessionClient(ctx).DoWithBody(request.WithContext(ctx), reader)
+	latency := time.Since(start)
 	if err != nil {
 		return resultAttributes, errors.Trace(err)
 	}
-	if response.StatusCode != http.StatusOK {
-		return resultAttributes, errors.Errorf("received status code %v", response.StatusCode)
+	resp := &httpResponse{Response: response}
+	defer resp.close()
+	if !call.Expect.AcceptsStatus(response.StatusCode) {
+		return resultAttributes, errors.Trace(&StatusError{StatusCode: response.StatusCode})
+	}
+	if err := resp.check(call.Expect, latency); err != nil {
+		return resultAttributes, errors.Trace(err)
 	}
-	if len(call.Results) > 0 {
-		if response.Body == nil {
-			return resultAttributes, errors.Errorf("did no receive any response data")
+	for _, r := range call.Results {
+		if r.Expr != "" {
+			// computed by the simulation
+			continue
 		}
-		decoder := json.NewDecoder(response.Body)
-		values := make(map[string]string)
-		if err = decoder.Decode(&values); err != nil {
-			return resultAttributes, errors.Annotate(err, "failed to unmarshal response body")
+		value, ok, err := resp.result(r)
+		if err != nil {
+			return resultAttributes, errors.Trace(err)
 		}
-		for _, r := range call.Results {
-			value, ok := values[r.Key]
-			if !ok {
-				return resultAttributes, errors.Errorf("key %q not found in the response body", r.Key)
+		if !ok {
+			switch {
+			case r.Default != nil:
+				value = r.Default
+			case r.Optional:
+				continue
+			default:
+				return resultAttributes, errors.Trace(notFoundError(r))
 			}
-			resultAttributes[r.Attribute] = value
 		}
+		resultAttributes[r.Attribute] = value
 	}
 	return resultAttributes, nil
 }
+
+// sessionClient returns the client used for calls made in the context.
+func (c *httpCallBackend) sessionClient(ctx context.Context) HTTPClient {
+	session := SessionFromContext(ctx)
+	if c.newClient == nil || session == nil {
+		return c.client
+	}
+	return session.value(c, func() interface{} {
+		return c.newClient(session.IsolateConnections)
+	}).(HTTPClient)
+}
@@ -5,10 +5,12 @@ package call_test
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"testing"
+	"time"
 
 	qt "github.com/frankban/quicktest"
 	"github.com/juju/errors"
@@ -25,6 +27,7 @@ func TestHTTPCallBackend(t *testing.T) {
 		config             config.Call
 		attributes         call.Attributes
 		responseStatus     int
+		responseHeader     http.Header
 		responseBody       []byte
 		responseError      error
 		expectedCall       httpCall
@@ -127,12 +130,34 @@ func TestHTTPCallBackend(t *testing.T) {
 			URL:    "/v1/test",
 			Method: "GET",
 			Body:   []byte(`{"username":"test-value"}`),
-			Header: http.Header{},
+			Header: http.Header{"Content-Type": {"application/json"}},
 		},
 		expectedAttributes: call.Attributes(map[string]interface{}{
 			"test-attribute1":     "test-value",
 			"authorization-token": "123456",
 		}),
+	}, {
+		about: "results computed from expressions are not read from the response",
+		attributes: call.Attributes(map[string]interface{}{
+			"test-attribute1": "test-value",
+		}),
+		config: config.Call{
+			Method: "GET",
+			URL:    "/v1/test",
+			Results: []config.CallResult{{
+				Attribute: "computed",
+				Expr:      "upper({test-attribute1})",
+			}},
+		},
+		responseStatus: http.StatusOK,
+		expectedCall: httpCall{
+			URL:    "/v1/test",
+			Method: "GET",
+			Header: http.Header{},
+		},
+		expectedAttributes: call.Attributes(map[string]interface{}{
+			"test-attribute1": "test-value",
+		}),
 	}, {
 		about: "a POST call with parameters and results - missing results",
 		attributes: call.Attributes(map[string]interface{}{
@@ -154,6 +179,178 @@ func TestHTTPCallBackend(t *testing.T) {
 		responseStatus: http.StatusOK,
 		responseBody:   []byte(`{}`),
 		expectedError:  `key "token" not found in the response body`,
+	}, {
+		about:      "results with paths and typed values",
+		attributes: call.Attributes(map[string]interface{}{}),
+		config: config.Call{
+			Method: "GET",
+			URL:    "/v1/items",
+			Results: []config.CallResult{{
+				Key:       "data.items[0].id",
+				Attribute: "first-id",
+			}, {
+				Key:       "data.items[*].id",
+				Attribute: "ids",
+			}, {
+				Key:       "length(data.items)",
+				Attribute: "count",
+			}, {
+				Key:       "data.total",
+				Attribute: "total",
+			}, {
+				Key:       "data.more",
+				Attribute: "more",
+			}, {
+				Key:       "data.owner",
+				Attribute: "owner",
+			}},
+		},
+		responseStatus: http.StatusOK,
+		responseBody:   []byte(`{"data": {"items": [{"id": 12345678}, {"id": 2}], "total": 9.5, "more": false, "owner": {"name": "alice"}}}`),
+		expectedCall: httpCall{
+			URL:    "/v1/items",
+			Method: "GET",
+			Header: http.Header{},
+		},
+		expectedAttributes: call.Attributes(map[string]interface{}{
+			"first-id": 12345678,
+			"ids":      []interface{}{12345678, 2},
+			"count":    2,
+			"total":    9.5,
+			"more":     false,
+			"owner":    map[string]interface{}{"name": "alice"},
+		}),
+	}, {
+		about:      "optional results and defaults",
+		attributes: call.Attributes(map[string]interface{}{}),
+		config: config.Call{
+			Method: "GET",
+			URL:    "/v1/items",
+			Results: []config.CallResult{{
+				Key:       "next",
+				Attribute: "next",
+				Optional:  true,
+			}, {
+				Key:       "page",
+				Attribute: "page",
+				Default:   1,
+			}, {
+				Key:       "items[0]",
+				Attribute: "item",
+				Optional:  true,
+			}},
+		},
+		responseStatus: http.StatusOK,
+		responseBody:   []byte(`{"items": ["a"]}`),
+		expectedCall: httpCall{
+			URL:    "/v1/items",
+			Method: "GET",
+			Header: http.Header{},
+		},
+		expectedAttributes: call.Attributes(map[string]interface{}{
+			"page": 1,
+			"item": "a",
+		}),
+	}, {
+		about:      "results from a non-object response",
+		attributes: call.Attributes(map[string]interface{}{}),
+		config: config.Call{
+			Method: "GET",
+			URL:    "/v1/items",
+			Results: []config.CallResult{{
+				Key:       "$[1]",
+				Attribute: "second",
+			}},
+		},
+		responseStatus: http.StatusOK,
+		responseBody:   []byte(`["a", "b"]`),
+		expectedCall: httpCall{
+			URL:    "/v1/items",
+			Method: "GET",
+			Header: http.Header{},
+		},
+		expectedAttributes: call.Attributes(map[string]interface{}{
+			"second": "b",
+		}),
+	}, {
+		about:      "results from headers, cookies, status and raw body",
+		attributes: call.Attributes(map[string]interface{}{}),
+		config: config.Call{
+			Method: "POST",
+			URL:    "/v1/items",
+			Results: []config.CallResult{{
+				Source:    config.HeaderResultSource,
+				Key:       "location",
+				Attribute: "location",
+			}, {
+				Source:    config.CookieResultSource,
+				Key:       "csrf",
+				Attribute: "csrf-token",
+			}, {
+				Source:    config.StatusResultSource,
+				Attribute: "status",
+			}, {
+				Source:    config.RawBodyResultSource,
+				Key:       `name="session" value="(\w+)"`,
+				Attribute: "session",
+			}, {
+				Source:    config.RawBodyResultSource,
+				Key:       `<title>.*</title>`,
+				Attribute: "title",
+			}, {
+				Source:    config.HeaderResultSource,
+				Key:       "X-Request-Id",
+				Attribute: "request-id",
+				Optional:  true,
+			}},
+		},
+		responseStatus: http.StatusOK,
+		responseHeader: http.Header{
+			"Location":   {"/v1/items/42"},
+			"Set-Cookie": {"session=abc; Path=/", "csrf=xyz; Path=/; HttpOnly"},
+		},
+		responseBody: []byte(`<html><title>Items</title><input name="session" value="s3cr3t"></html>`),
+		expectedCall: httpCall{
+			URL:    "/v1/items",
+			Method: "POST",
+			Header: http.Header{},
+		},
+		expectedAttributes: call.Attributes(map[string]interface{}{
+			"location":   "/v1/items/42",
+			"csrf-token": "xyz",
+			"status":     200,
+			"session":    "s3cr3t",
+			"title":      "<title>Items</title>",
+		}),
+	}, {
+		about:      "missing header",
+		attributes: call.Attributes(map[string]interface{}{}),
+		config: config.Call{
+			Method: "POST",
+			URL:    "/v1/items",
+			Results: []config.CallResult{{
+				Source:    config.HeaderResultSource,
+				Key:       "Location",
+				Attribute: "location",
+			}},
+		},
+		responseStatus: http.StatusOK,
+		expectedError:  `header "Location" not found in the response`,
+	}, {
+		about:      "raw body not matching",
+		attributes: call.Attributes(map[string]interface{}{}),
+		config: config.Call{
+			Method: "POST",
+			URL:    "/v1/items",
+			Results: []config.CallResult{{
+				Source:    config.RawBodyResultSource,
+				Key:       `id=(\d+)`,
+				Attribute: "id",
+			}},
+		},
+		responseStatus: http.StatusOK,
+		responseBody:   []byte(`no id`),
+		expectedError:  `pattern "id=\(\\\\d\+\)" does not match the response body`,
 	}, {
 		about: "a POST call - does not return 200",
 		attributes: call.Attributes(map[string]interface{}{
@@ -201,6 +398,7 @@ func TestHTTPCallBackend(t *testing.T) {
 		c.Logf("running test %d: %s", i, test.about)
 		client := &testHTTPClient{
 			responseStatus: test.responseStatus,
+			responseHeader: test.responseHeader,
 			responseBody:   test.responseBody,
 			responseError:  test.responseError,
 		}
@@ -218,6 +416,219 @@ func TestHTTPCallBackend(t *testing.T) {
 
 }
 
+func TestHTTPCallBackendExpect(t *testing.T) {
+	c := qt.New(t)
+
+	tests := []struct {
+		about          string
+		expect         *config.Expect
+		responseStatus int
+		responseHeader http.Header
+		responseBody   string
+		expectedError  string
+	}{{
+		about:          "status 200 is accepted by default",
+		responseStatus: http.StatusOK,
+		responseBody:   `{}`,
+	}, {
+		about:          "accepted status codes, classes and ranges",
+		expect:         &config.Expect{Status: []string{"201", "3xx", "400-403"}},
+		responseStatus: http.StatusFound,
+	}, {
+		about:          "unexpected status code",
+		expect:         &config.Expect{Status: []string{"201", "3xx", "400-403"}},
+		responseStatus: http.StatusOK,
+		expectedError:  `received status code 200`,
+	}, {
+		about: "body and header assertions hold",
+		expect: &config.Expect{
+			Status: []string{"2xx"},
+			Body: []config.Assertion{{
+				Key:    "data.id",
+				Equals: 42,
+			}, {
+				Key:     "data.name",
+				Matches: "^it",
+			}, {
+				Matches: `"id": *42`,
+			}, {
+				Schema: &config.Schema{
+					Type:     "object",
+					Required: []string{"data"},
+					Properties: map[string]*config.Schema{
+						"data": {
+							Type: "object",
+							Properties: map[string]*config.Schema{
+								"id":   {Type: "integer"},
+								"tags": {Type: "array", Items: &config.Schema{Enum: []interface{}{"a", "b"}}},
+							},
+						},
+					},
+				},
+			}},
+			Headers: []config.Assertion{{
+				Key:    "content-type",
+				Equals: "application/json",
+			}, {
+				Key: "X-Request-Id",
+			}},
+		},
+		responseStatus: http.StatusCreated,
+		responseHeader: http.Header{
+			"Content-Type": {"application/json"},
+			"X-Request-Id": {"1"},
+		},
+		responseBody: `{"data": {"id": 42, "name": "item", "tags": ["a", "b"]}}`,
+	}, {
+		about: "body value not equal",
+		expect: &config.Expect{
+			Body: []config.Assertion{{
+				Key:    "data.id",
+				Equals: 43,
+			}},
+		},
+		responseStatus: http.StatusOK,
+		responseBody:   `{"data": {"id": 42}}`,
+		expectedError:  `expectation "body.data.id" failed: got 42, expected 43`,
+	}, {
+		about: "missing body value",
+		expect: &config.Expect{
+			Body: []config.Assertion{{
+				Name: "has-id",
+				Key:  "data.id",
+			}},
+		},
+		responseStatus: http.StatusOK,
+		responseBody:   `{"data": {}}`,
+		expectedError:  `expectation "has-id" failed: value not found`,
+	}, {
+		about: "body not matching",
+		expect: &config.Expect{
+			Body: []config.Assertion{{
+				Matches: "welcome",
+			}},
+		},
+		responseStatus: http.StatusOK,
+		responseBody:   `<html>error</html>`,
+		expectedError:  `expectation "body" failed: value does not match "welcome"`,
+	}, {
+		about: "body not conforming to the schema",
+		expect: &config.Expect{
+			Body: []config.Assertion{{
+				Key: "items",
+				Schema: &config.Schema{
+					Type: "array",
+					Items: &config.Schema{
+						Type:     "object",
+						Required: []string{"id"},
+					},
+				},
+			}},
+		},
+		responseStatus: http.StatusOK,
+		responseBody:   `{"items": [{"id": 1}, {"name": "second"}]}`,
+		expectedError:  `expectation "body.items" failed: items\[1\]: missing field "id"`,
+	}, {
+		about: "body not JSON",
+		expect: &config.Expect{
+			Body: []config.Assertion{{
+				Key: "id",
+			}},
+		},
+		responseStatus: http.StatusOK,
+		responseBody:   `not json`,
+		expectedError:  `expectation "body.id" failed: body is not valid JSON`,
+	}, {
+		about: "header not matching",
+		expect: &config.Expect{
+			Headers: []config.Assertion{{
+				Key:     "Content-Type",
+				Matches: "json",
+			}},
+		},
+		responseStatus: http.StatusOK,
+		responseHeader: http.Header{
+			"Content-Type": {"text/html"},
+		},
+		expectedError: `expectation "header.Content-Type" failed: value does not match "json"`,
+	}}
+
+	for i, test := range tests {
+		c.Logf("running test %d: %s", i, test.about)
+		client := &testHTTPClient{
+			responseStatus: test.responseStatus,
+			responseHeader: test.responseHeader,
+			responseBody:   []byte(test.responseBody),
+		}
+		backend := call.NewHTTPCallBackend(client)
+
+		_, err := backend.Do(context.Background(), config.Call{
+			Method: "GET",
+			URL:    "/v1/test",
+			Expect: test.expect,
+		}, call.Attributes{})
+		if test.expectedError != "" {
+			c.Assert(err, qt.ErrorMatches, test.expectedError)
+		} else {
+			c.Assert(err, qt.IsNil)
+		}
+	}
+}
+
+func TestHTTPCallBackendSessions(t *testing.T) {
+	c := qt.New(t)
+
+	var clients []*testHTTPClient
+	var isolated []bool
+	backend := call.NewSessionHTTPCallBackend(func(isolate bool) call.HTTPClient {
+		client := &testHTTPClient{
+			responseStatus: http.StatusOK,
+		}
+		clients = append(clients, client)
+		isolated = append(isolated, isolate)
+		return client
+	})
+	session1 := call.NewSession(false)
+	session2 := call.NewSession(true)
+	for i, ctx := range []context.Context{
+		context.Background(),
+		call.ContextWithSession(context.Background(), session1),
+		call.ContextWithSession(context.Background(), session2),
+		call.ContextWithSession(context.Background(), session1),
+	} {
+		_, err := backend.Do(ctx, config.Call{
+			Method: "GET",
+			URL:    fmt.Sprintf("/v1/test/%d", i),
+		}, call.Attributes{})
+		c.Assert(err, qt.IsNil)
+	}
+	c.Assert(isolated, qt.DeepEquals, []bool{false, false, true})
+	c.Assert(clients[0].call.URL, qt.Equals, "/v1/test/0")
+	c.Assert(clients[1].call.URL, qt.Equals, "/v1/test/3")
+	c.Assert(clients[2].call.URL, qt.Equals, "/v1/test/2")
+}
+
+func TestHTTPCallBackendContext(t *testing.T) {
+	c := qt.New(t)
+
+	backend := call.NewHTTPCallBackend(blockingHTTPClient{})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := backend.Do(ctx, config.Call{
+		Method: "GET",
+		URL:    "/v1/test",
+	}, call.Attributes{})
+	c.Assert(errors.Cause(err), qt.Equals, context.DeadlineExceeded)
+}
+
+// blockingHTTPClient blocks until the context of the request is done.
+type blockingHTTPClient struct{}
+
+func (blockingHTTPClient) DoWithBody(req *http.Request, body io.ReadSeeker) (*http.Response, error) {
+	<-req.Context().Done()
+	return nil, req.Context().Err()
+}
+
 type httpCall struct {
 	URL    string
 	Method string
@@ -227,6 +638,7 @@ type httpCall struct {
 
 type testHTTPClient struct {
 	responseStatus int
+	responseHeader http.Header
 	responseBody   []byte
 	responseError  error
 	call           httpCall
@@ -251,6 +663,7 @@ func (c *testHTTPClient) DoWithBody(req *http.Request, body io.ReadSeeker) (*htt
 	return &http.Response{
 		StatusCode: c.responseStatus,
 		Status:     http.StatusText(c.responseStatus),
+		Header:     c.responseHeader,
 		Body:       ioutil.NopCloser(bytes.NewReader(c.responseBody)),
 	}, c.responseError
 }
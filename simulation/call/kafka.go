@@ -32,6 +32,11 @@ type kafkaCallBackend struct {
 	producer sarama.SyncProducer
 }
 
+// Name returns the name of the backend.
+func (c *kafkaCallBackend) Name() string {
+	return string(config.KafkaCallBackend)
+}
+
 // Do implements the CallBackend interface.
 func (c *kafkaCallBackend) Do(ctx context.Context, call config.Call, attributes Attributes) (Attributes, error) {
 	bodyContent := make(map[string]interface{})
@@ -71,9 +76,21 @@ func (c *kafkaCallBackend) Do(ctx context.Context, call config.Call, attributes
 			})
 		}
 	}
-	_, _, err = c.producer.SendMessage(msg)
+	// the producer does not support cancellation, so the call
+	// returns once the context is done, without waiting for the
+	// message to be sent.
+	sent := make(chan error, 1)
+	go func() {
+		_, _, err := c.producer.SendMessage(msg)
+		sent <- err
+	}()
+	select {
+	case err = <-sent:
+	case <-ctx.Done():
+		return attributes, errors.Trace(ctx.Err())
+	}
 	if err != nil {
-		return attributes, errors.Annotatef(err, "failed to send to topic %q", topic)
+		return attributes, errors.Trace(&SendError{Topic: fmt.Sprintf("%v", topic), Err: err})
 	}
 	return attributes, nil
 }
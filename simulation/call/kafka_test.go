@@ -5,10 +5,12 @@ package call_test
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/Shopify/sarama"
 	qt "github.com/frankban/quicktest"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/juju/errors"
 
 	"github.com/cloud-green/sisyphus/config"
 	"github.com/cloud-green/sisyphus/simulation/call"
@@ -101,6 +103,34 @@ func TestKafkaCallBackend(t *testing.T) {
 
 }
 
+func TestKafkaCallBackendContext(t *testing.T) {
+	c := qt.New(t)
+
+	producer := &blockingProducer{
+		unblock: make(chan struct{}),
+	}
+	defer close(producer.unblock)
+	backend := call.NewKafkaCallBackend(producer)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := backend.Do(ctx, config.Call{}, call.Attributes{
+		"message-key":   "test-key",
+		"message-topic": "test-topic",
+	})
+	c.Assert(errors.Cause(err), qt.Equals, context.DeadlineExceeded)
+}
+
+// blockingProducer blocks sending messages until unblocked.
+type blockingProducer struct {
+	sarama.SyncProducer
+	unblock chan struct{}
+}
+
+func (p *blockingProducer) SendMessage(msg *sarama.ProducerMessage) (partition int32, offset int64, err error) {
+	<-p.unblock
+	return 0, 0, nil
+}
+
 type testProducer struct {
 	sarama.SyncProducer
 	message       *sarama.ProducerMessage
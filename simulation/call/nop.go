@@ -16,6 +16,11 @@ func NewNOPCallBackend() *nopCallBackend {
 
 type nopCallBackend struct{}
 
+// Name returns the name of the backend.
+func (c *nopCallBackend) Name() string {
+	return string(config.NOPCallBackend)
+}
+
 // Do implements the CallBackend interface.
 func (c *nopCallBackend) Do(ctx context.Context, call config.Call, attributes Attributes) (Attributes, error) {
 	return attributes, nil
@@ -0,0 +1,157 @@
+// Copyright 2019 CanonicalLtd
+
+package call
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/juju/errors"
+
+	"github.com/cloud-green/sisyphus/config"
+	"github.com/cloud-green/sisyphus/jsonpath"
+)
+
+// httpResponse holds an http response from which call results are read.
+// The body is read and decoded once, when first needed.
+type httpResponse struct {
+	*http.Response
+
+	body    []byte
+	read    bool
+	doc     interface{}
+	decoded bool
+}
+
+// result returns the value of the call result and true, or false if
+// the response does not hold the value.
+func (r *httpResponse) result(cr config.CallResult) (interface{}, bool, error) {
+	switch cr.Source {
+	case "", config.BodyResultSource:
+		path, err := jsonpath.Parse(cr.Key)
+		if err != nil {
+			return nil, false, errors.Annotatef(err, "invalid path %q", cr.Key)
+		}
+		doc, err := r.json()
+		if err != nil {
+			return nil, false, errors.Trace(err)
+		}
+		value, ok := path.Select(doc)
+		return value, ok, nil
+	case config.HeaderResultSource:
+		values := r.Header[http.CanonicalHeaderKey(cr.Key)]
+		if len(values) == 0 {
+			return nil, false, nil
+		}
+		return values[0], true, nil
+	case config.CookieResultSource:
+		for _, c := range r.Cookies() {
+			if c.Name == cr.Key {
+				return c.Value, true, nil
+			}
+		}
+		return nil, false, nil
+	case config.StatusResultSource:
+		return r.StatusCode, true, nil
+	case config.RawBodyResultSource:
+		re, err := regexp.Compile(cr.Key)
+		if err != nil {
+			return nil, false, errors.Annotatef(err, "invalid regular expression %q", cr.Key)
+		}
+		body, err := r.readBody()
+		if err != nil {
+			return nil, false, errors.Trace(err)
+		}
+		match := re.FindSubmatch(body)
+		switch {
+		case match == nil:
+			return nil, false, nil
+		case len(match) > 1:
+			return string(match[1]), true, nil
+		}
+		return string(match[0]), true, nil
+	}
+	return nil, false, errors.Errorf("unknown result source %q", cr.Source)
+}
+
+// readBody returns the body of the response.
+func (r *httpResponse) readBody() ([]byte, error) {
+	if r.read {
+		return r.body, nil
+	}
+	if r.Body == nil {
+		return nil, errors.Errorf("did no receive any response data")
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, errors.Annotate(err, "failed to read response body")
+	}
+	r.body, r.read = body, true
+	return body, nil
+}
+
+// json returns the decoded JSON body of the response.
+func (r *httpResponse) json() (interface{}, error) {
+	if r.decoded {
+		return r.doc, nil
+	}
+	body, err := r.readBody()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, errors.Annotate(err, "failed to unmarshal response body")
+	}
+	r.doc, r.decoded = typed(doc), true
+	return r.doc, nil
+}
+
+// close closes the body of the response.
+func (r *httpResponse) close() {
+	if r.Body != nil {
+		r.Body.Close()
+	}
+}
+
+// notFoundError returns the error for a result the response does not
+// hold.
+func notFoundError(cr config.CallResult) error {
+	switch cr.Source {
+	case config.HeaderResultSource:
+		return errors.Errorf("header %q not found in the response", cr.Key)
+	case config.CookieResultSource:
+		return errors.Errorf("cookie %q not found in the response", cr.Key)
+	case config.RawBodyResultSource:
+		return errors.Errorf("pattern %q does not match the response body", cr.Key)
+	}
+	return errors.Errorf("key %q not found in the response body", cr.Key)
+}
+
+// typed converts the numbers of the decoded JSON value to ints, if
+// they are integers that fit, or float64s.
+func typed(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := strconv.ParseInt(string(v), 10, 0); err == nil {
+			return int(i)
+		}
+		f, _ := v.Float64()
+		return f
+	case []interface{}:
+		for i, e := range v {
+			v[i] = typed(e)
+		}
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = typed(e)
+		}
+	}
+	return v
+}
@@ -0,0 +1,75 @@
+// Copyright 2019 CanonicalLtd
+
+package call
+
+import (
+	"context"
+	"sync"
+)
+
+// Session holds the state shared by the calls of the entities using
+// the session, such as the http client holding their cookies. The
+// simulation passes the session to call backends in the context of
+// each call.
+type Session struct {
+	// IsolateConnections specifies that the session uses its own
+	// connections.
+	IsolateConnections bool
+
+	mu     sync.Mutex
+	values map[interface{}]interface{}
+}
+
+// NewSession returns a new session.
+func NewSession(isolateConnections bool) *Session {
+	return &Session{
+		IsolateConnections: isolateConnections,
+	}
+}
+
+// value returns the value stored in the session under the key, calling
+// create to create it the first time.
+func (s *Session) value(key interface{}, create func() interface{}) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v, ok := s.values[key]; ok {
+		return v
+	}
+	if s.values == nil {
+		s.values = make(map[interface{}]interface{})
+	}
+	v := create()
+	s.values[key] = v
+	return v
+}
+
+// Close closes the idle connections of sessions with isolated
+// connections. The session remains usable.
+func (s *Session) Close() {
+	if !s.IsolateConnections {
+		// the connections are shared with other sessions
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range s.values {
+		if c, ok := v.(interface {
+			CloseIdleConnections()
+		}); ok {
+			c.CloseIdleConnections()
+		}
+	}
+}
+
+type sessionKey struct{}
+
+// ContextWithSession returns a context holding the session.
+func ContextWithSession(ctx context.Context, s *Session) context.Context {
+	return context.WithValue(ctx, sessionKey{}, s)
+}
+
+// SessionFromContext returns the session held by the context, or nil.
+func SessionFromContext(ctx context.Context) *Session {
+	s, _ := ctx.Value(sessionKey{}).(*Session)
+	return s
+}
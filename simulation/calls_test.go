@@ -0,0 +1,297 @@
+// Copyright 2019 CanonicalLtd
+
+package simulation_test
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/errors"
+	yaml "gopkg.in/yaml.v1"
+
+	"github.com/cloud-green/sisyphus/config"
+	"github.com/cloud-green/sisyphus/simulation"
+	"github.com/cloud-green/sisyphus/simulation/call"
+)
+
+var callsSim = `
+root-entities:
+- entity: user
+entities:
+  user:
+    initial_state: home
+    attributes:
+      name:
+        type: string
+        string-value: alice
+state:
+  done:
+  failed:
+`
+
+// failingCallBackend fails calls to the specified URLs and returns
+// the URL of each call as the value of its results.
+type failingCallBackend struct {
+	mu         sync.Mutex
+	fail       map[string]bool
+	calls      []string
+	attributes []call.Attributes
+}
+
+func (b *failingCallBackend) Do(ctx context.Context, callConfig config.Call, attributes call.Attributes) (call.Attributes, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.calls = append(b.calls, callConfig.URL)
+	b.attributes = append(b.attributes, copyAttributes(attributes))
+	if b.fail[callConfig.URL] {
+		return attributes, errors.New("call failed")
+	}
+	attrs := copyAttributes(attributes)
+	for _, r := range callConfig.Results {
+		attrs[r.Attribute] = callConfig.URL
+	}
+	return attrs, nil
+}
+
+func copyAttributes(attributes call.Attributes) call.Attributes {
+	attrs := make(call.Attributes, len(attributes))
+	for k, v := range attributes {
+		attrs[k] = v
+	}
+	return attrs
+}
+
+// transitionObserver records the target states of transitions.
+type transitionObserver struct {
+	simulation.NopObserver
+
+	mu      sync.Mutex
+	targets []string
+}
+
+func (o *transitionObserver) Transition(entity, from, to string, failure bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.targets = append(o.targets, to)
+}
+
+func TestCallSequences(t *testing.T) {
+	c := qt.New(t)
+
+	tests := []struct {
+		about              string
+		state              string
+		fail               []string
+		expectedCalls      []string
+		unordered          bool
+		expectedAttributes call.Attributes
+		expectedTransition string
+	}{{
+		about: "calls are performed in order with results of earlier calls",
+		state: `
+transitions:
+- state: done
+  probability: 1
+  on-failure: failed
+  call:
+    url: /page
+    results:
+    - key: page
+      attribute: page
+  calls:
+  - url: /assets
+    results:
+    - key: assets
+      attribute: assets
+  - url: /event
+`,
+		expectedCalls: []string{"/page", "/assets", "/event"},
+		expectedAttributes: call.Attributes{
+			"name":   "alice",
+			"page":   "/page",
+			"assets": "/assets",
+		},
+		expectedTransition: "done",
+	}, {
+		about: "calls stop on the first failure",
+		state: `
+transitions:
+- state: done
+  probability: 1
+  on-failure: failed
+  calls:
+  - url: /page
+  - url: /assets
+  - url: /event
+`,
+		fail:          []string{"/assets"},
+		expectedCalls: []string{"/page", "/assets"},
+		expectedAttributes: call.Attributes{
+			"name": "alice",
+		},
+		expectedTransition: "failed",
+	}, {
+		about: "calls continue on failure",
+		state: `
+transitions:
+- state: done
+  probability: 1
+  on-failure: failed
+  continue-on-failure: true
+  calls:
+  - url: /page
+  - url: /assets
+  - url: /event
+`,
+		fail:          []string{"/assets"},
+		expectedCalls: []string{"/page", "/assets", "/event"},
+		expectedAttributes: call.Attributes{
+			"name": "alice",
+		},
+		expectedTransition: "failed",
+	}, {
+		about: "parallel calls",
+		state: `
+transitions:
+- state: done
+  probability: 1
+  on-failure: failed
+  calls:
+  - url: /page
+  - parallel:
+    - url: /style.css
+      results:
+      - key: style
+        attribute: style
+    - url: /script.js
+      results:
+      - key: script
+        attribute: script
+  - url: /event
+`,
+		expectedCalls: []string{"/page", "/style.css", "/script.js", "/event"},
+		unordered:     true,
+		expectedAttributes: call.Attributes{
+			"name":   "alice",
+			"style":  "/style.css",
+			"script": "/script.js",
+		},
+		expectedTransition: "done",
+	}, {
+		about: "failing parallel calls",
+		state: `
+transitions:
+- state: done
+  probability: 1
+  on-failure: failed
+  calls:
+  - parallel:
+    - url: /style.css
+    - url: /script.js
+  - url: /event
+`,
+		fail:               []string{"/script.js"},
+		expectedCalls:      []string{"/style.css", "/script.js"},
+		unordered:          true,
+		expectedTransition: "failed",
+	}}
+
+	for i, test := range tests {
+		c.Logf("running test %d: %s", i, test.about)
+
+		var simConfig config.Config
+		err := yaml.Unmarshal([]byte(callsSim), &simConfig)
+		c.Assert(err, qt.IsNil)
+		var state config.State
+		err = yaml.Unmarshal([]byte(test.state), &state)
+		c.Assert(err, qt.IsNil)
+		simConfig.States["home"] = state
+
+		callBackend := &failingCallBackend{
+			fail: make(map[string]bool),
+		}
+		for _, url := range test.fail {
+			callBackend.fail[url] = true
+		}
+		sim, err := simulation.New(simConfig, callBackend)
+		c.Assert(err, qt.IsNil)
+		observer := &transitionObserver{}
+		sim.Observer = observer
+		err = sim.Start(context.Background())
+		c.Assert(err, qt.IsNil)
+		err = sim.Wait()
+		c.Assert(err, qt.IsNil)
+
+		if test.unordered {
+			// parallel calls are performed in any order
+			sort.Strings(callBackend.calls)
+			sort.Strings(test.expectedCalls)
+		}
+		c.Assert(callBackend.calls, qt.DeepEquals, test.expectedCalls)
+		if test.expectedAttributes != nil {
+			c.Assert(callBackend.attributes[len(callBackend.attributes)-1], qt.DeepEquals, test.expectedAttributes)
+		}
+		c.Assert(observer.targets, qt.DeepEquals, []string{test.expectedTransition})
+	}
+}
+
+func TestSkippedCallSequence(t *testing.T) {
+	c := qt.New(t)
+
+	var simConfig config.Config
+	err := yaml.Unmarshal([]byte(callsSim), &simConfig)
+	c.Assert(err, qt.IsNil)
+	var state config.State
+	err = yaml.Unmarshal([]byte(`
+timer:
+  type: fixed
+  interval: 250ms
+transitions:
+- state: done
+  probability: 1
+  on-failure: failed
+  calls:
+  - method: POST
+    url: /order
+    results:
+    - key: order
+      attribute: order
+  - parallel:
+    - url: /status
+    - url: /style.css
+  - url: /status
+`), &state)
+	c.Assert(err, qt.IsNil)
+	simConfig.States["home"] = state
+	simConfig.RateLimits = []config.RateLimit{{
+		URL:     "/status$",
+		Rate:    1,
+		OnLimit: config.SkipLimitAction,
+	}}
+
+	callBackend := &failingCallBackend{}
+	sim, err := simulation.New(simConfig, callBackend)
+	c.Assert(err, qt.IsNil)
+	sim.Clock = simulation.NewVirtualClock(epoch)
+	observer := &transitionObserver{}
+	sim.Observer = observer
+	err = sim.Start(context.Background())
+	c.Assert(err, qt.IsNil)
+	err = sim.Wait()
+	c.Assert(err, qt.IsNil)
+
+	// the last call is skipped until a token is available, and the
+	// transition resumes without performing the earlier calls again.
+	calls := callBackend.calls
+	sort.Strings(calls[1:3])
+	c.Assert(calls, qt.DeepEquals, []string{"/order", "/status", "/style.css", "/status"})
+	c.Assert(callBackend.attributes[3], qt.DeepEquals, call.Attributes{
+		"name":  "alice",
+		"order": "/order",
+	})
+	c.Assert(observer.targets, qt.DeepEquals, []string{"done"})
+	c.Assert(sim.Stats().Transitions, qt.Equals, int64(1))
+}
@@ -0,0 +1,200 @@
+// Copyright 2019 CanonicalLtd
+
+package simulation
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+)
+
+// Clock defines the interface used by the simulation to measure
+// time and wait for timers to fire.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// Sleep blocks until the duration elapses or the context
+	// is cancelled, in which case it returns the context's error.
+	Sleep(ctx context.Context, d time.Duration) error
+	// Started is called when a simulation goroutine is started.
+	Started()
+	// Stopped is called when a simulation goroutine exits.
+	Stopped()
+}
+
+// RealClock is a clock that uses wall clock time.
+var RealClock Clock = realClock{}
+
+type realClock struct{}
+
+// Now implements the Clock interface.
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// Sleep implements the Clock interface.
+func (realClock) Sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Started implements the Clock interface.
+func (realClock) Started() {}
+
+// Stopped implements the Clock interface.
+func (realClock) Stopped() {}
+
+// NewVirtualClock returns a new discrete event clock, whose time starts
+// at the specified time. The virtual clock does not advance on its own:
+// once all simulation goroutines are sleeping, it jumps to the time of
+// the earliest scheduled wake up. This allows simulations to run
+// as fast as the call backend permits.
+func NewVirtualClock(start time.Time) *VirtualClock {
+	return &VirtualClock{
+		now: start,
+	}
+}
+
+// VirtualClock implements a discrete event clock.
+type VirtualClock struct {
+	mu       sync.Mutex
+	now      time.Time
+	running  int
+	seq      int64
+	sleepers sleeperHeap
+}
+
+// Now implements the Clock interface.
+func (c *VirtualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Sleep implements the Clock interface.
+func (c *VirtualClock) Sleep(ctx context.Context, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	s := &sleeper{
+		ctx:   ctx,
+		wake:  c.now.Add(d),
+		seq:   c.seq,
+		woken: make(chan struct{}),
+	}
+	c.seq++
+	heap.Push(&c.sleepers, s)
+	c.running--
+	c.advance()
+	c.mu.Unlock()
+
+	select {
+	case <-s.woken:
+		return ctx.Err()
+	case <-ctx.Done():
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		select {
+		case <-s.woken:
+			// the sleeper was woken up concurrently and has
+			// already been marked as running.
+		default:
+			heap.Remove(&c.sleepers, s.index)
+			c.running++
+		}
+		return ctx.Err()
+	}
+}
+
+// Started implements the Clock interface.
+func (c *VirtualClock) Started() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.running++
+}
+
+// Stopped implements the Clock interface.
+func (c *VirtualClock) Stopped() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.running--
+	c.advance()
+}
+
+// advance moves the clock to the earliest wake up time and wakes
+// all sleepers scheduled at that time, if no goroutine is running.
+// Sleepers whose context has been cancelled are woken first without
+// advancing the clock. It must be called with the mutex held.
+func (c *VirtualClock) advance() {
+	if c.running > 0 || len(c.sleepers) == 0 {
+		return
+	}
+	for i, s := range c.sleepers {
+		if s.ctx.Err() != nil {
+			heap.Remove(&c.sleepers, i)
+			c.running++
+			close(s.woken)
+			return
+		}
+	}
+	wake := c.sleepers[0].wake
+	if wake.After(c.now) {
+		c.now = wake
+	}
+	for len(c.sleepers) > 0 && !c.sleepers[0].wake.After(wake) {
+		s := heap.Pop(&c.sleepers).(*sleeper)
+		c.running++
+		close(s.woken)
+	}
+}
+
+type sleeper struct {
+	ctx   context.Context
+	wake  time.Time
+	seq   int64
+	index int
+	woken chan struct{}
+}
+
+// sleeperHeap implements heap.Interface ordering sleepers by their
+// wake up time and, for equal times, by the order in which they
+// started sleeping.
+type sleeperHeap []*sleeper
+
+func (h sleeperHeap) Len() int {
+	return len(h)
+}
+
+func (h sleeperHeap) Less(i, j int) bool {
+	if h[i].wake.Equal(h[j].wake) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].wake.Before(h[j].wake)
+}
+
+func (h sleeperHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *sleeperHeap) Push(x interface{}) {
+	s := x.(*sleeper)
+	s.index = len(*h)
+	*h = append(*h, s)
+}
+
+func (h *sleeperHeap) Pop() interface{} {
+	old := *h
+	s := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return s
+}
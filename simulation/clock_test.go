@@ -0,0 +1,193 @@
+// Copyright 2019 CanonicalLtd
+
+package simulation_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	yaml "gopkg.in/yaml.v1"
+
+	"github.com/cloud-green/sisyphus/config"
+	"github.com/cloud-green/sisyphus/simulation"
+)
+
+var (
+	epoch = time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	timedSim = `
+root-entities:
+- entity: user
+  cardinality: "2"
+  timer:
+    type: fixed
+    interval: 1h
+entities:
+  user:
+    initial_state: login
+state:
+  login:
+    timer:
+      type: fixed
+      interval: 30m
+    transitions:
+    - state: home
+      probability: 1
+      call:
+        url: http://test.com/login
+  home:
+    timer:
+      type: fixed
+      interval: 24h
+    transitions:
+    - state: logout
+      probability: 1
+      call:
+        url: http://test.com/logout
+  logout:
+`
+)
+
+func TestVirtualClock(t *testing.T) {
+	c := qt.New(t)
+
+	clock := simulation.NewVirtualClock(epoch)
+
+	var mu sync.Mutex
+	var woken []time.Duration
+	var wg sync.WaitGroup
+	for _, d := range []time.Duration{3 * time.Hour, time.Hour, 2 * time.Hour, time.Hour} {
+		clock.Started()
+		wg.Add(1)
+		go func(d time.Duration) {
+			defer wg.Done()
+			defer clock.Stopped()
+			err := clock.Sleep(context.Background(), d)
+			c.Check(err, qt.IsNil)
+			mu.Lock()
+			defer mu.Unlock()
+			c.Check(clock.Now().Sub(epoch), qt.Equals, d)
+			woken = append(woken, d)
+		}(d)
+	}
+	wg.Wait()
+	c.Assert(woken, qt.DeepEquals, []time.Duration{time.Hour, time.Hour, 2 * time.Hour, 3 * time.Hour})
+	c.Assert(clock.Now(), qt.Equals, epoch.Add(3*time.Hour))
+}
+
+func TestVirtualClockDoesNotAdvanceWhileRunning(t *testing.T) {
+	c := qt.New(t)
+
+	clock := simulation.NewVirtualClock(epoch)
+	// a running goroutine prevents the clock from advancing
+	clock.Started()
+
+	clock.Started()
+	done := make(chan error)
+	go func() {
+		defer clock.Stopped()
+		done <- clock.Sleep(context.Background(), time.Hour)
+	}()
+	select {
+	case <-done:
+		c.Fatalf("clock advanced while a goroutine was running")
+	case <-time.After(10 * time.Millisecond):
+	}
+	c.Assert(clock.Now(), qt.Equals, epoch)
+
+	clock.Stopped()
+	c.Assert(<-done, qt.IsNil)
+	c.Assert(clock.Now(), qt.Equals, epoch.Add(time.Hour))
+}
+
+func TestVirtualClockCancel(t *testing.T) {
+	c := qt.New(t)
+
+	clock := simulation.NewVirtualClock(epoch)
+	clock.Started()
+	clock.Started()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		defer clock.Stopped()
+		done <- clock.Sleep(ctx, time.Hour)
+	}()
+	cancel()
+	c.Assert(<-done, qt.Equals, context.Canceled)
+	c.Assert(clock.Now(), qt.Equals, epoch)
+	clock.Stopped()
+}
+
+func TestSimulationVirtualClock(t *testing.T) {
+	c := qt.New(t)
+
+	var simConfig config.Config
+	err := yaml.Unmarshal([]byte(timedSim), &simConfig)
+	c.Assert(err, qt.IsNil)
+
+	callBackend := &testCallBackend{}
+	sim, err := simulation.New(simConfig, callBackend)
+	c.Assert(err, qt.IsNil)
+	clock := simulation.NewVirtualClock(epoch)
+	sim.Clock = clock
+	err = sim.Start(context.Background())
+	c.Assert(err, qt.IsNil)
+	err = sim.Wait()
+	c.Assert(err, qt.IsNil)
+
+	// the second user is created after 2h, logs in after another
+	// 30m and logs out a day later.
+	c.Assert(clock.Now(), qt.Equals, epoch.Add(26*time.Hour+30*time.Minute))
+	c.Assert(callBackend.calls, qt.HasLen, 4)
+	c.Assert(sim.Stats().Duration < time.Second, qt.Equals, true)
+}
+
+func TestSimulationVirtualClockDuration(t *testing.T) {
+	c := qt.New(t)
+
+	var simConfig config.Config
+	err := yaml.Unmarshal([]byte(timedSim), &simConfig)
+	c.Assert(err, qt.IsNil)
+	simConfig.Limits.Duration = 10 * time.Hour
+
+	callBackend := &testCallBackend{}
+	sim, err := simulation.New(simConfig, callBackend)
+	c.Assert(err, qt.IsNil)
+	clock := simulation.NewVirtualClock(epoch)
+	sim.Clock = clock
+	err = sim.Start(context.Background())
+	c.Assert(err, qt.IsNil)
+	err = sim.Wait()
+	c.Assert(err, qt.IsNil)
+
+	c.Assert(clock.Now(), qt.Equals, epoch.Add(10*time.Hour))
+	c.Assert(callBackend.calls, qt.HasLen, 2)
+}
+
+func TestSimulationVirtualClockFinishesBeforeDuration(t *testing.T) {
+	c := qt.New(t)
+
+	var simConfig config.Config
+	err := yaml.Unmarshal([]byte(timedSim), &simConfig)
+	c.Assert(err, qt.IsNil)
+	simConfig.Limits.Duration = 48 * time.Hour
+
+	callBackend := &testCallBackend{}
+	sim, err := simulation.New(simConfig, callBackend)
+	c.Assert(err, qt.IsNil)
+	clock := simulation.NewVirtualClock(epoch)
+	sim.Clock = clock
+	err = sim.Start(context.Background())
+	c.Assert(err, qt.IsNil)
+	err = sim.Wait()
+	c.Assert(err, qt.IsNil)
+
+	// the clock does not advance to the duration limit once all
+	// entities have finished.
+	c.Assert(clock.Now(), qt.Equals, epoch.Add(26*time.Hour+30*time.Minute))
+	c.Assert(callBackend.calls, qt.HasLen, 4)
+}
@@ -0,0 +1,200 @@
+// Copyright 2019 CanonicalLtd
+
+package simulation_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	yaml "gopkg.in/yaml.v1"
+
+	"github.com/cloud-green/sisyphus/config"
+	"github.com/cloud-green/sisyphus/simulation"
+	"github.com/cloud-green/sisyphus/simulation/call"
+)
+
+var computedSim = `
+root-entities:
+- entity: user
+entities:
+  user:
+    initial_state: browse
+    attributes:
+      price:
+        type: random_float
+        min: 2.5
+        max: 2.5
+      quantity:
+        type: int
+        value: 4
+      total:
+        type: expr
+        expr: "{price} * {quantity}"
+      welcome:
+        type: expr
+        expr: "upper({name}) + ':' + string({total})"
+      name:
+        type: string
+        string-value: alice
+state:
+  browse:
+    attributes:
+      visits:
+        type: expr
+        expr: "default({visits}, 0) + 1"
+    transitions:
+    - state: browse
+      probability: 1
+      when: "{visits} < 3"
+      call:
+        url: http://test.com/browse
+    fallback:
+      state: checkout
+      call:
+        url: http://test.com/order
+        results:
+        - key: amount
+          attribute: amount
+        - attribute: charged
+          expr: "number({amount}) + {total}"
+  checkout:
+    transitions:
+    - state: done
+      probability: 1
+      call:
+        url: http://test.com/checkout
+  done:
+`
+
+func TestComputedAttributes(t *testing.T) {
+	c := qt.New(t)
+
+	var simConfig config.Config
+	err := yaml.Unmarshal([]byte(computedSim), &simConfig)
+	c.Assert(err, qt.IsNil)
+
+	callBackend := &testCallBackend{
+		responseAttributes: map[string]call.Attributes{
+			"http://test.com/order": {"amount": "5"},
+		},
+	}
+	sim, err := simulation.New(simConfig, callBackend)
+	c.Assert(err, qt.IsNil)
+	err = sim.Start(context.Background())
+	c.Assert(err, qt.IsNil)
+	err = sim.Wait()
+	c.Assert(err, qt.IsNil)
+
+	c.Assert(callBackend.calls, qt.HasLen, 4)
+	var urls []string
+	for _, call := range callBackend.calls {
+		urls = append(urls, call.URL)
+	}
+	c.Assert(urls, qt.DeepEquals, []string{
+		"http://test.com/browse",
+		"http://test.com/browse",
+		"http://test.com/order",
+		"http://test.com/checkout",
+	})
+
+	attributes := callBackend.attributes[3]
+	c.Assert(attributes["total"], qt.Equals, 10.0)
+	c.Assert(attributes["welcome"], qt.Equals, "ALICE:10")
+	c.Assert(attributes["visits"], qt.Equals, 3.0)
+	c.Assert(attributes["charged"], qt.Equals, 15.0)
+}
+
+func TestComputedResultError(t *testing.T) {
+	c := qt.New(t)
+
+	var simConfig config.Config
+	err := yaml.Unmarshal([]byte(computedSim), &simConfig)
+	c.Assert(err, qt.IsNil)
+
+	// the order call does not return an amount, so the charged
+	// result cannot be computed and the call fails.
+	callBackend := &testCallBackend{}
+	sim, err := simulation.New(simConfig, callBackend)
+	c.Assert(err, qt.IsNil)
+	err = sim.Start(context.Background())
+	c.Assert(err, qt.IsNil)
+	err = sim.Wait()
+	c.Assert(err, qt.IsNil)
+
+	c.Assert(callBackend.calls, qt.HasLen, 4)
+	c.Assert(callBackend.attributes[3]["error"], qt.Matches, `.*cannot compute result "charged".*`)
+}
+
+var computedCardinalitySim = `
+root-entities:
+- entity: user
+entities:
+  user:
+    initial_state: done
+    attributes:
+      users:
+        type: int
+        value: 3
+      devices:
+        type: expr
+        expr: "{users} * {ratio}"
+    subordinates:
+    - entity: device
+      cardinality: devices
+  device:
+    initial_state: sync
+state:
+  sync:
+    transitions:
+    - state: done
+      probability: 1
+      call:
+        url: http://test.com/sync
+  done:
+`
+
+func TestComputedCardinality(t *testing.T) {
+	c := qt.New(t)
+
+	tests := []struct {
+		about         string
+		ratio         float64
+		expectedCalls int
+		expectedError string
+	}{{
+		about:         "integral value",
+		ratio:         2,
+		expectedCalls: 6,
+	}, {
+		about:         "zero",
+		ratio:         0,
+		expectedCalls: 0,
+	}, {
+		about:         "fractional value",
+		ratio:         0.5,
+		expectedError: `cardinality 1.5 is not an integer`,
+	}}
+	for i, test := range tests {
+		c.Logf("running test %d: %s", i, test.about)
+		var simConfig config.Config
+		err := yaml.Unmarshal([]byte(computedCardinalitySim), &simConfig)
+		c.Assert(err, qt.IsNil)
+		simConfig.Constants = map[string]interface{}{
+			"ratio": test.ratio,
+		}
+
+		callBackend := &testCallBackend{}
+		sim, err := simulation.New(simConfig, callBackend)
+		c.Assert(err, qt.IsNil)
+		err = sim.Start(context.Background())
+		c.Assert(err, qt.IsNil)
+		err = sim.Wait()
+		if test.expectedError != "" {
+			c.Assert(err, qt.ErrorMatches, test.expectedError)
+			continue
+		}
+		c.Assert(err, qt.IsNil)
+		c.Assert(callBackend.calls, qt.HasLen, test.expectedCalls)
+	}
+}
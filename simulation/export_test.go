@@ -0,0 +1,71 @@
+// Copyright 2019 CanonicalLtd
+
+package simulation
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/cloud-green/sisyphus/config"
+)
+
+// TimerDurations returns n successive intervals of a timer started at
+// the specified time.
+func TimerDurations(c config.Timer, seed int64, start time.Time, n int) ([]time.Duration, error) {
+	traces, err := loadTraces(config.Config{
+		RootEntities: []config.EntitySet{{Timer: c}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	t := newTimer(c, rand.New(rand.NewSource(seed)), &Simulation{
+		Clock:  RealClock,
+		traces: traces,
+	})
+	durations := make([]time.Duration, n)
+	now := start
+	for i := range durations {
+		durations[i] = t.duration(now)
+		now = now.Add(durations[i])
+	}
+	return durations, nil
+}
+
+// TraceTimerDurations returns n successive intervals of each of the
+// specified number of timers replaying the same trace, taking intervals
+// from the timers in turn.
+func TraceTimerDurations(filename string, timers, n int) ([][]time.Duration, error) {
+	c := config.Timer{
+		Type: config.TraceTimer,
+		File: filename,
+	}
+	traces, err := loadTraces(config.Config{
+		RootEntities: []config.EntitySet{{Timer: c}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	sim := &Simulation{
+		Clock:  RealClock,
+		traces: traces,
+	}
+	ts := make([]*timer, timers)
+	durations := make([][]time.Duration, timers)
+	for i := range ts {
+		ts[i] = newTimer(c, rand.New(rand.NewSource(int64(i))), sim)
+		durations[i] = make([]time.Duration, n)
+	}
+	for j := 0; j < n; j++ {
+		for i, t := range ts {
+			durations[i][j] = t.duration(time.Time{})
+		}
+	}
+	return durations, nil
+}
+
+// StageTarget returns the target number of entities and the interval
+// until the target changes the elapsed time into a stage that starts
+// with the specified number of entities.
+func StageTarget(s config.Stage, from int, elapsed time.Duration) (int, time.Duration) {
+	return stage(s).target(from, elapsed), stage(s).interval(from, elapsed)
+}
@@ -0,0 +1,56 @@
+// Copyright 2019 CanonicalLtd
+
+package simulation
+
+import (
+	"sync"
+
+	"github.com/juju/errors"
+
+	"github.com/cloud-green/sisyphus/expr"
+	"github.com/cloud-green/sisyphus/simulation/call"
+)
+
+// expressions holds parsed expressions, so that each expression of
+// the configuration is parsed once.
+type expressions struct {
+	mu    sync.Mutex
+	exprs map[string]*expr.Expr
+}
+
+// parse returns the parsed expression.
+func (es *expressions) parse(s string) (*expr.Expr, error) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if e, ok := es.exprs[s]; ok {
+		return e, nil
+	}
+	e, err := expr.Parse(s)
+	if err != nil {
+		return nil, errors.Annotatef(err, "invalid expression %q", s)
+	}
+	if es.exprs == nil {
+		es.exprs = make(map[string]*expr.Expr)
+	}
+	es.exprs[s] = e
+	return e, nil
+}
+
+// env returns the environment in which expressions are evaluated
+// against the attributes.
+func (s *Simulation) env(attributes call.Attributes) expr.Env {
+	return expr.Env{
+		Attributes: attributes,
+		Now:        s.Clock.Now,
+	}
+}
+
+// eval evaluates the expression against the attributes.
+func (s *Simulation) eval(es string, attributes call.Attributes) (interface{}, error) {
+	e, err := s.exprs.parse(es)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	value, err := e.Eval(s.env(attributes))
+	return value, errors.Trace(err)
+}
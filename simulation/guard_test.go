@@ -0,0 +1,200 @@
+// Copyright 2019 CanonicalLtd
+
+package simulation_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	yaml "gopkg.in/yaml.v1"
+
+	"github.com/cloud-green/sisyphus/config"
+	"github.com/cloud-green/sisyphus/simulation"
+)
+
+var guardedSim = `
+root-entities:
+- entity: user
+entities:
+  user:
+    initial_state: cart
+    attributes:
+      cart-size:
+        type: int
+        value: 2
+      name:
+        type: string
+        string-value: alice
+state:
+  done:
+`
+
+// runCartState runs guardedSim with the specified configuration of
+// the cart state and returns the URLs of the performed calls.
+func runCartState(c *qt.C, data string) []string {
+	var simConfig config.Config
+	err := yaml.Unmarshal([]byte(guardedSim), &simConfig)
+	c.Assert(err, qt.IsNil)
+	var state config.State
+	err = yaml.Unmarshal([]byte(data), &state)
+	c.Assert(err, qt.IsNil)
+	simConfig.States["cart"] = state
+
+	callBackend := &testCallBackend{}
+	sim, err := simulation.New(simConfig, callBackend)
+	c.Assert(err, qt.IsNil)
+	err = sim.Start(context.Background())
+	c.Assert(err, qt.IsNil)
+	err = sim.Wait()
+	c.Assert(err, qt.IsNil)
+
+	var urls []string
+	for _, call := range callBackend.calls {
+		urls = append(urls, call.URL)
+	}
+	return urls
+}
+
+func TestGuards(t *testing.T) {
+	c := qt.New(t)
+
+	tests := []struct {
+		about        string
+		state        string
+		expectedURLs []string
+	}{{
+		about: "only transitions whose guard is true are chosen",
+		state: `
+transitions:
+- state: done
+  probability: 1
+  when: "{cart-size} == 0"
+  call:
+    url: http://test.com/empty
+- state: done
+  probability: 1
+  when: "{cart-size} > 0 && exists({name})"
+  call:
+    url: http://test.com/checkout
+`,
+		expectedURLs: []string{"http://test.com/checkout"},
+	}, {
+		about: "transitions without guard are eligible",
+		state: `
+transitions:
+- state: done
+  probability: 1
+  when: "{cart-size} > 2"
+  call:
+    url: http://test.com/checkout
+- state: done
+  probability: 1
+  call:
+    url: http://test.com/browse
+`,
+		expectedURLs: []string{"http://test.com/browse"},
+	}, {
+		about: "fallback",
+		state: `
+transitions:
+- state: done
+  probability: 1
+  when: "{cart-size} > 2"
+  call:
+    url: http://test.com/checkout
+fallback:
+  state: done
+  call:
+    url: http://test.com/fallback
+`,
+		expectedURLs: []string{"http://test.com/fallback"},
+	}, {
+		about: "guards that cannot be evaluated are false",
+		state: `
+transitions:
+- state: done
+  probability: 1
+  when: "{name} > 2 || {name} == 'alice'"
+  call:
+    url: http://test.com/checkout
+fallback:
+  state: done
+  call:
+    url: http://test.com/fallback
+`,
+		expectedURLs: []string{"http://test.com/fallback"},
+	}, {
+		about: "without fallback the entity finishes",
+		state: `
+transitions:
+- state: done
+  probability: 1
+  when: "{cart-size} > 2"
+  call:
+    url: http://test.com/checkout
+`,
+	}}
+	for i, test := range tests {
+		c.Logf("running test %d: %s", i, test.about)
+
+		c.Assert(runCartState(c, test.state), qt.DeepEquals, test.expectedURLs)
+	}
+}
+
+func TestWeights(t *testing.T) {
+	c := qt.New(t)
+
+	tests := []struct {
+		about        string
+		state        string
+		expectedURLs []string
+	}{{
+		about: "weights depending on attributes",
+		state: `
+transitions:
+- state: done
+  probability: "({cart-size} - 2) * 0.3"
+  call:
+    url: http://test.com/browse
+- state: done
+  probability: "{cart-size} / 2"
+  call:
+    url: http://test.com/checkout
+`,
+		expectedURLs: []string{"http://test.com/checkout"},
+	}, {
+		about: "transitions whose weight cannot be evaluated are not eligible",
+		state: `
+transitions:
+- state: done
+  probability: "{name} * 0.3"
+  call:
+    url: http://test.com/browse
+- state: done
+  probability: 0.1
+  call:
+    url: http://test.com/checkout
+`,
+		expectedURLs: []string{"http://test.com/checkout"},
+	}, {
+		about: "transitions with negative weights are not eligible",
+		state: `
+transitions:
+- state: done
+  probability: "-{cart-size}"
+  call:
+    url: http://test.com/browse
+fallback:
+  state: done
+  call:
+    url: http://test.com/fallback
+`,
+		expectedURLs: []string{"http://test.com/fallback"},
+	}}
+	for i, test := range tests {
+		c.Logf("running test %d: %s", i, test.about)
+
+		c.Assert(runCartState(c, test.state), qt.DeepEquals, test.expectedURLs)
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2019 CanonicalLtd
+
+package simulation_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	yaml "gopkg.in/yaml.v1"
+
+	"github.com/cloud-green/sisyphus/config"
+	"github.com/cloud-green/sisyphus/simulation"
+)
+
+var lifetimeSim = `
+root-entities:
+- entity: user
+entities:
+  user:
+    initial_state: active
+    subordinates:
+    - entity: device
+  device:
+    initial_state: online
+state:
+  active:
+    timer:
+      type: fixed
+      interval: 1m
+    transitions:
+    - state: active
+      probability: 1
+      call:
+        method: GET
+        url: http://test.com/ping
+  online:
+    timer:
+      type: fixed
+      interval: 1m
+    transitions:
+    - state: online
+      probability: 1
+  logged-out:
+  logout-failed:
+`
+
+func TestEntityLifetime(t *testing.T) {
+	c := qt.New(t)
+
+	logout := &config.Transition{
+		State:     "logged-out",
+		OnFailure: "logout-failed",
+		Call: config.Call{
+			Method: "POST",
+			URL:    "http://test.com/logout",
+		},
+	}
+	tests := []struct {
+		about            string
+		lifetime         config.Timer
+		maxTransitions   int
+		onExpire         *config.Transition
+		expectedURLs     []string
+		expectedDuration time.Duration
+	}{{
+		about: "lifetime",
+		lifetime: config.Timer{
+			Type:     config.FixedTimer,
+			Interval: 10*time.Minute + 30*time.Second,
+		},
+		onExpire:         logout,
+		expectedURLs:     append(repeat("http://test.com/ping", 10), "http://test.com/logout"),
+		expectedDuration: 10*time.Minute + 30*time.Second,
+	}, {
+		about:            "max transitions",
+		maxTransitions:   3,
+		onExpire:         logout,
+		expectedURLs:     append(repeat("http://test.com/ping", 3), "http://test.com/logout"),
+		expectedDuration: 3 * time.Minute,
+	}, {
+		about: "lifetime without on-expire transition",
+		lifetime: config.Timer{
+			Type:     config.FixedTimer,
+			Interval: 5*time.Minute + 30*time.Second,
+		},
+		expectedURLs:     repeat("http://test.com/ping", 5),
+		expectedDuration: 5*time.Minute + 30*time.Second,
+	}, {
+		about: "lifetime elapses before max transitions",
+		lifetime: config.Timer{
+			Type:     config.FixedTimer,
+			Interval: 90 * time.Second,
+		},
+		maxTransitions:   3,
+		onExpire:         logout,
+		expectedURLs:     []string{"http://test.com/ping", "http://test.com/logout"},
+		expectedDuration: 90 * time.Second,
+	}}
+	for i, test := range tests {
+		c.Logf("running test %d: %s", i, test.about)
+
+		var simConfig config.Config
+		err := yaml.Unmarshal([]byte(lifetimeSim), &simConfig)
+		c.Assert(err, qt.IsNil)
+		user := simConfig.Entities["user"]
+		user.Lifetime = test.lifetime
+		user.MaxTransitions = test.maxTransitions
+		user.OnExpire = test.onExpire
+		simConfig.Entities["user"] = user
+
+		callBackend := &testCallBackend{}
+		sim, err := simulation.New(simConfig, callBackend)
+		c.Assert(err, qt.IsNil)
+		clock := simulation.NewVirtualClock(epoch)
+		sim.Clock = clock
+		observer := &entityObserver{clock: clock}
+		sim.Observer = observer
+		err = sim.Start(context.Background())
+		c.Assert(err, qt.IsNil)
+		err = sim.Wait()
+		c.Assert(err, qt.IsNil)
+
+		var urls []string
+		for _, call := range callBackend.calls {
+			urls = append(urls, call.URL)
+		}
+		c.Assert(urls, qt.DeepEquals, test.expectedURLs)
+		// the subordinate device is stopped when the user expires
+		c.Assert(observer.finished, qt.DeepEquals, []time.Duration{test.expectedDuration, test.expectedDuration})
+		c.Assert(clock.Now().Sub(epoch), qt.Equals, test.expectedDuration)
+	}
+}
+
+func repeat(s string, n int) []string {
+	ss := make([]string, n)
+	for i := range ss {
+		ss[i] = s
+	}
+	return ss
+}
@@ -0,0 +1,154 @@
+// Copyright 2019 CanonicalLtd
+
+package simulation
+
+import (
+	"time"
+
+	"github.com/cloud-green/sisyphus/config"
+)
+
+// Observer defines the interface used by the simulation to report
+// events occurring during its execution. Methods may be called
+// concurrently.
+type Observer interface {
+	// EntityCreated is called when an entity is created.
+	EntityCreated(entity string)
+	// EntityFinished is called when an entity reaches a state
+	// without transitions or stops because of an error or
+	// the simulation stopping.
+	EntityFinished(entity string)
+	// StateEntered is called when an entity enters a state.
+	StateEntered(entity, state string)
+	// StateExited is called when an entity leaves a state.
+	StateExited(entity, state string)
+	// Transition is called when an entity transitions from one
+	// state to another. Failure is true if the on-failure transition
+	// was taken because the transition call failed.
+	Transition(entity, from, to string, failure bool)
+	// Call is called when a call performed using the call backend
+	// completes.
+	Call(info CallInfo)
+	// Throttled is called when a call exceeds a rate limit.
+	Throttled(info ThrottleInfo)
+}
+
+// CallInfo describes a completed call.
+type CallInfo struct {
+	// Backend holds the name of the call backend used to perform
+	// the call, as reported by the backend.
+	Backend config.CallBackend
+	// Entity holds the name of the entity performing the call.
+	Entity string
+	// State holds the state the transition was taken from.
+	State string
+	// Target holds the target state of the transition.
+	Target string
+	// Call holds the call configuration.
+	Call config.Call
+	// Start holds the time the call was started.
+	Start time.Time
+	// Duration holds the duration of the call.
+	Duration time.Duration
+	// Err holds the error returned by the call backend.
+	Err error
+	// Attempt holds the number of the attempt, starting at 1.
+	// Attempts after the first are retries of a failed call.
+	Attempt int
+}
+
+// ThrottleInfo describes a call that exceeded a rate limit.
+type ThrottleInfo struct {
+	// Backend holds the name of the call backend used to perform
+	// the call, as reported by the backend.
+	Backend config.CallBackend
+	// Call holds the call configuration.
+	Call config.Call
+	// Action holds the action taken.
+	Action config.LimitAction
+	// Wait holds the time the call waited for the limit to
+	// permit it.
+	Wait time.Duration
+}
+
+// Observers returns an observer that notifies all specified observers
+// of each event.
+func Observers(observers ...Observer) Observer {
+	return multiObserver(observers)
+}
+
+type multiObserver []Observer
+
+// EntityCreated implements the Observer interface.
+func (m multiObserver) EntityCreated(entity string) {
+	for _, o := range m {
+		o.EntityCreated(entity)
+	}
+}
+
+// EntityFinished implements the Observer interface.
+func (m multiObserver) EntityFinished(entity string) {
+	for _, o := range m {
+		o.EntityFinished(entity)
+	}
+}
+
+// StateEntered implements the Observer interface.
+func (m multiObserver) StateEntered(entity, state string) {
+	for _, o := range m {
+		o.StateEntered(entity, state)
+	}
+}
+
+// StateExited implements the Observer interface.
+func (m multiObserver) StateExited(entity, state string) {
+	for _, o := range m {
+		o.StateExited(entity, state)
+	}
+}
+
+// Transition implements the Observer interface.
+func (m multiObserver) Transition(entity, from, to string, failure bool) {
+	for _, o := range m {
+		o.Transition(entity, from, to, failure)
+	}
+}
+
+// Call implements the Observer interface.
+func (m multiObserver) Call(info CallInfo) {
+	for _, o := range m {
+		o.Call(info)
+	}
+}
+
+// Throttled implements the Observer interface.
+func (m multiObserver) Throttled(info ThrottleInfo) {
+	for _, o := range m {
+		o.Throttled(info)
+	}
+}
+
+// NopObserver implements an Observer that ignores all events. It may
+// be embedded by observers interested only in some events.
+type NopObserver struct{}
+
+// EntityCreated implements the Observer interface.
+func (NopObserver) EntityCreated(entity string) {}
+
+// EntityFinished implements the Observer interface.
+func (NopObserver) EntityFinished(entity string) {}
+
+// StateEntered implements the Observer interface.
+func (NopObserver) StateEntered(entity, state string) {}
+
+// StateExited implements the Observer interface.
+func (NopObserver) StateExited(entity, state string) {}
+
+// Transition implements the Observer interface.
+func (NopObserver) Transition(entity, from, to string, failure bool) {}
+
+// Call implements the Observer interface.
+func (NopObserver) Call(info CallInfo) {}
+
+// Throttled implements the Observer interface.
+func (NopObserver) Throttled(info ThrottleInfo) {}
@@ -0,0 +1,131 @@
+// Copyright 2019 CanonicalLtd
+
+package simulation
+
+import (
+	"context"
+	"math/big"
+	"time"
+
+	"github.com/cloud-green/sisyphus/config"
+)
+
+const (
+	// profileInterval holds the maximum interval at which a load
+	// profile replaces finished entities.
+	profileInterval = time.Second
+)
+
+// runProfile creates and retires entities following the load profile
+// of the entity set.
+func (e *entitySet) runProfile(ctx context.Context, sim *Simulation, cfg config.Entity) {
+	p := &profile{
+		entitySet: e,
+		config:    cfg,
+	}
+	start := sim.Clock.Now()
+	from := 0
+	for _, c := range e.Stages {
+		s := stage(c)
+		for {
+			elapsed := sim.Clock.Now().Sub(start)
+			if elapsed > s.Duration {
+				elapsed = s.Duration
+			}
+			p.reconcile(ctx, sim, s.target(from, elapsed))
+			if elapsed == s.Duration {
+				break
+			}
+			if err := sim.Clock.Sleep(ctx, s.interval(from, elapsed)); err != nil {
+				// the simulation or the parent entity
+				// has been stopped
+				return
+			}
+		}
+		// the next stage starts when this stage ends, so that
+		// delays do not accumulate
+		start = start.Add(s.Duration)
+		from = s.Target
+	}
+}
+
+// profile holds the entities created by a load profile.
+type profile struct {
+	*entitySet
+	config config.Entity
+	// live holds live entities in order of their creation.
+	live []*entity
+}
+
+// reconcile creates or retires entities so that the number of live
+// entities equals the target.
+func (p *profile) reconcile(ctx context.Context, sim *Simulation, target int) {
+	// forget entities that have finished
+	live := p.live[:0]
+	for _, e := range p.live {
+		if !e.isFinished() {
+			live = append(live, e)
+		}
+	}
+	p.live = live
+
+	for len(p.live) < target {
+		entityCtx, cancel := context.WithCancel(ctx)
+		e := createEntity(entityCtx, cancel, p.Entity, p.config, copyAttributes(p.attributes), p.session, sim, newRand(p.rnd))
+		if e == nil {
+			cancel()
+			return
+		}
+		p.live = append(p.live, e)
+	}
+	// the most recently created entities are retired first
+	for len(p.live) > target {
+		e := p.live[len(p.live)-1]
+		e.cancel()
+		p.live = p.live[:len(p.live)-1]
+	}
+}
+
+type stage config.Stage
+
+// target returns the number of live entities the elapsed time into
+// a stage that starts with the specified number of entities.
+func (s stage) target(from int, elapsed time.Duration) int {
+	if s.Duration == 0 {
+		return s.Target
+	}
+	return from + int(mulDiv(int64(s.Target-from), int64(elapsed), int64(s.Duration), false))
+}
+
+// interval returns the time until the target number of entities of the
+// stage changes or, if sooner, until finished entities are replaced.
+func (s stage) interval(from int, elapsed time.Duration) time.Duration {
+	interval := profileInterval
+	if delta := s.Target - from; delta != 0 {
+		if delta < 0 {
+			delta = -delta
+		}
+		// the time at which the target changes next
+		n := mulDiv(int64(elapsed), int64(delta), int64(s.Duration), false) + 1
+		next := time.Duration(mulDiv(n, int64(s.Duration), int64(delta), true))
+		if next-elapsed < interval {
+			interval = next - elapsed
+		}
+	}
+	if remaining := s.Duration - elapsed; remaining < interval {
+		interval = remaining
+	}
+	return interval
+}
+
+// mulDiv returns a*b/c, rounded towards zero or, if up is true and the
+// result is positive, upwards. The intermediate product does not
+// overflow, so that stages may be long and have many entities.
+func mulDiv(a, b, c int64, up bool) int64 {
+	var q, r big.Int
+	q.QuoRem(new(big.Int).Mul(big.NewInt(a), big.NewInt(b)), big.NewInt(c), &r)
+	if up && r.Sign() > 0 {
+		q.Add(&q, big.NewInt(1))
+	}
+	return q.Int64()
+}
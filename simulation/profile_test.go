@@ -0,0 +1,162 @@
+// Copyright 2019 CanonicalLtd
+
+package simulation_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	yaml "gopkg.in/yaml.v1"
+
+	"github.com/cloud-green/sisyphus/config"
+	"github.com/cloud-green/sisyphus/simulation"
+)
+
+var profileSim = `
+root-entities:
+- entity: user
+  stages:
+  - duration: 10m
+    target: 10
+  - duration: 5m
+    target: 10
+  - target: 20
+  - duration: 2m
+    target: 20
+  - duration: 5m
+    target: 0
+entities:
+  user:
+    initial_state: active
+state:
+  active:
+    timer:
+      type: fixed
+      interval: 1m
+    transitions:
+    - state: active
+      probability: 1
+      call:
+        method: GET
+        url: http://test.com/ping
+`
+
+var replacingProfileSim = `
+root-entities:
+- entity: user
+  stages:
+  - target: 2
+  - duration: 3m
+    target: 2
+entities:
+  user:
+    initial_state: login
+state:
+  login:
+    timer:
+      type: fixed
+      interval: 50s
+    transitions:
+    - state: done
+      probability: 1
+  done:
+`
+
+// entityObserver records when entities are created and finish.
+type entityObserver struct {
+	simulation.NopObserver
+
+	clock    simulation.Clock
+	mu       sync.Mutex
+	created  []time.Duration
+	finished []time.Duration
+}
+
+func (o *entityObserver) EntityCreated(entity string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.created = append(o.created, o.clock.Now().Sub(epoch))
+}
+
+func (o *entityObserver) EntityFinished(entity string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.finished = append(o.finished, o.clock.Now().Sub(epoch))
+}
+
+func runProfile(c *qt.C, data string) (*simulation.VirtualClock, *entityObserver) {
+	var simConfig config.Config
+	err := yaml.Unmarshal([]byte(data), &simConfig)
+	c.Assert(err, qt.IsNil)
+
+	sim, err := simulation.New(simConfig, &testCallBackend{})
+	c.Assert(err, qt.IsNil)
+	clock := simulation.NewVirtualClock(epoch)
+	sim.Clock = clock
+	observer := &entityObserver{clock: clock}
+	sim.Observer = observer
+	err = sim.Start(context.Background())
+	c.Assert(err, qt.IsNil)
+	err = sim.Wait()
+	c.Assert(err, qt.IsNil)
+	return clock, observer
+}
+
+func TestProfile(t *testing.T) {
+	c := qt.New(t)
+
+	clock, observer := runProfile(c, profileSim)
+
+	var expectedCreated []time.Duration
+	// ramp up to 10 entities over 10 minutes
+	for i := 1; i <= 10; i++ {
+		expectedCreated = append(expectedCreated, time.Duration(i)*time.Minute)
+	}
+	// spike to 20 entities after 15 minutes
+	for i := 0; i < 10; i++ {
+		expectedCreated = append(expectedCreated, 15*time.Minute)
+	}
+	c.Assert(observer.created, qt.DeepEquals, expectedCreated)
+
+	// entities are retired during the 5 minute ramp down starting
+	// after 17 minutes
+	var expectedFinished []time.Duration
+	for i := 1; i <= 20; i++ {
+		expectedFinished = append(expectedFinished, 17*time.Minute+time.Duration(i)*15*time.Second)
+	}
+	c.Assert(observer.finished, qt.DeepEquals, expectedFinished)
+	c.Assert(clock.Now(), qt.Equals, epoch.Add(22*time.Minute))
+}
+
+func TestProfileReplacesFinishedEntities(t *testing.T) {
+	c := qt.New(t)
+
+	clock, observer := runProfile(c, replacingProfileSim)
+
+	// entities finish after 50 seconds and are replaced until
+	// the profile ends after 3 minutes.
+	c.Assert(observer.created, qt.HasLen, 8)
+	c.Assert(observer.finished, qt.HasLen, 8)
+	c.Assert(clock.Now().After(epoch.Add(3*time.Minute)), qt.Equals, true)
+}
+
+func TestLongStage(t *testing.T) {
+	c := qt.New(t)
+
+	s := config.Stage{
+		Duration: 1000 * time.Hour,
+		Target:   100000000,
+	}
+	// the target changes every 36ms
+	target, interval := simulation.StageTarget(s, 0, 500*time.Hour)
+	c.Assert(target, qt.Equals, 50000000)
+	c.Assert(interval, qt.Equals, 36*time.Millisecond)
+
+	s.Target = 0
+	target, interval = simulation.StageTarget(s, 100000000, 250*time.Hour+time.Millisecond)
+	c.Assert(target, qt.Equals, 75000000)
+	c.Assert(interval, qt.Equals, 35*time.Millisecond)
+}
@@ -0,0 +1,230 @@
+// Copyright 2019 CanonicalLtd
+
+package simulation
+
+import (
+	"context"
+	"math"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+
+	"github.com/cloud-green/sisyphus/config"
+	"github.com/cloud-green/sisyphus/simulation/call"
+)
+
+var (
+	// skippedError is returned for calls that are skipped because
+	// they exceeded a rate limit.
+	skippedError = errors.New("skipped")
+)
+
+// newRateLimiters returns rate limiters for the configured limits.
+func newRateLimiters(configs []config.RateLimit) (rateLimiters, error) {
+	limiters := make(rateLimiters, len(configs))
+	for i, c := range configs {
+		url, err := regexp.Compile(c.URL)
+		if err != nil {
+			return nil, errors.Annotatef(err, "invalid rate limit url %q", c.URL)
+		}
+		l := &rateLimiter{
+			RateLimit: c,
+			url:       url,
+		}
+		if l.OnLimit == "" {
+			l.OnLimit = config.WaitLimitAction
+		}
+		if c.Rate > 0 {
+			burst := c.Burst
+			if burst == 0 {
+				burst = 1
+			}
+			l.bucket = &tokenBucket{
+				rate:  c.Rate,
+				burst: float64(burst),
+			}
+		}
+		if c.MaxInFlight > 0 {
+			l.inFlight = make(chan struct{}, c.MaxInFlight)
+		}
+		limiters[i] = l
+	}
+	return limiters, nil
+}
+
+type rateLimiters []*rateLimiter
+
+// acquire waits until all limits selecting the call permit it. It
+// returns a function that must be called once the call completes. If
+// a limit is exceeded and its action is skip, it returns skippedError;
+// if the action is fail, it returns call.ErrThrottled. Limits acquired
+// for a call that is not performed are given back.
+func (ls rateLimiters) acquire(ctx context.Context, sim *Simulation, callConfig config.Call) (func(), error) {
+	var acquired []*rateLimiter
+	release := func() {
+		for _, l := range acquired {
+			l.release()
+		}
+	}
+	// refuse releases the acquired limits, returning their tokens
+	// as the call is not performed.
+	refuse := func() {
+		for _, l := range acquired {
+			l.release()
+			l.cancel()
+		}
+	}
+	for _, l := range ls {
+		if !l.selects(callConfig) {
+			continue
+		}
+		wait, ok, err := l.acquire(ctx, sim.Clock)
+		if err != nil {
+			refuse()
+			return nil, errors.Trace(err)
+		}
+		if wait > 0 || !ok {
+			sim.Observer.Throttled(ThrottleInfo{
+				Backend: sim.backend,
+				Call:    callConfig,
+				Action:  l.OnLimit,
+				Wait:    wait,
+			})
+		}
+		if !ok {
+			refuse()
+			if l.OnLimit == config.SkipLimitAction {
+				return nil, skippedError
+			}
+			return nil, errors.Trace(call.ErrThrottled)
+		}
+		acquired = append(acquired, l)
+	}
+	return release, nil
+}
+
+type rateLimiter struct {
+	config.RateLimit
+	url *regexp.Regexp
+	// bucket limits the rate of calls, if set.
+	bucket *tokenBucket
+	// inFlight limits the number of concurrent calls, if set.
+	inFlight chan struct{}
+}
+
+// selects returns true if the limit applies to the call.
+func (l *rateLimiter) selects(callConfig config.Call) bool {
+	return (l.Method == "" || l.Method == callConfig.Method) && l.url.MatchString(callConfig.URL)
+}
+
+// acquire acquires permission to perform a call, waiting if the limit's
+// action is wait. It returns the time spent waiting and whether the
+// call is permitted.
+func (l *rateLimiter) acquire(ctx context.Context, clock Clock) (time.Duration, bool, error) {
+	start := clock.Now()
+	if l.bucket != nil {
+		if l.OnLimit == config.WaitLimitAction {
+			if wait := l.bucket.reserve(clock.Now()); wait > 0 {
+				if err := clock.Sleep(ctx, wait); err != nil {
+					// the call is not performed
+					l.bucket.cancel()
+					return clock.Now().Sub(start), false, contextDoneError
+				}
+			}
+		} else if !l.bucket.take(clock.Now()) {
+			return 0, false, nil
+		}
+	}
+	if l.inFlight != nil {
+		if l.OnLimit == config.WaitLimitAction {
+			select {
+			case l.inFlight <- struct{}{}:
+			case <-ctx.Done():
+				l.cancel()
+				return clock.Now().Sub(start), false, contextDoneError
+			}
+		} else {
+			select {
+			case l.inFlight <- struct{}{}:
+			default:
+				l.cancel()
+				return 0, false, nil
+			}
+		}
+	}
+	return clock.Now().Sub(start), true, nil
+}
+
+// release releases the in-flight call slot.
+func (l *rateLimiter) release() {
+	if l.inFlight != nil {
+		<-l.inFlight
+	}
+}
+
+// cancel returns the token taken for a call that is not performed.
+func (l *rateLimiter) cancel() {
+	if l.bucket != nil {
+		l.bucket.cancel()
+	}
+}
+
+// tokenBucket implements the token bucket algorithm: tokens are added
+// to the bucket at the specified rate up to the bucket's capacity, the
+// burst, and each call takes a token.
+type tokenBucket struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// fill adds tokens accumulated since the bucket was last filled. The
+// bucket starts full.
+func (b *tokenBucket) fill(now time.Time) {
+	if b.last.IsZero() {
+		b.tokens = b.burst
+	} else if now.After(b.last) {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	if now.After(b.last) {
+		b.last = now
+	}
+}
+
+// take takes a token, if one is available.
+func (b *tokenBucket) take(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.fill(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// reserve takes a token, even if none is available, and returns the
+// time until the token becomes available.
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.fill(now)
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// cancel returns a token that was reserved for a call that is not
+// performed.
+func (b *tokenBucket) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = math.Min(b.burst, b.tokens+1)
+}
@@ -0,0 +1,295 @@
+// Copyright 2019 CanonicalLtd
+
+package simulation_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	yaml "gopkg.in/yaml.v1"
+
+	"github.com/cloud-green/sisyphus/config"
+	"github.com/cloud-green/sisyphus/simulation"
+	"github.com/cloud-green/sisyphus/simulation/call"
+)
+
+var rateLimitedSim = `
+root-entities:
+- entity: user
+  cardinality: "4"
+entities:
+  user:
+    initial_state: login
+state:
+  login:
+    timer:
+      type: fixed
+      interval: 250ms
+    transitions:
+    - state: done
+      probability: 1
+      on-failure: error
+      call:
+        method: POST
+        url: http://test.com/login
+  done:
+  error:
+`
+
+// throttleObserver records throttled calls and the number of times
+// states are entered.
+type throttleObserver struct {
+	simulation.NopObserver
+
+	mu        sync.Mutex
+	throttled []simulation.ThrottleInfo
+	entered   int
+}
+
+func (o *throttleObserver) StateEntered(entity, state string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.entered++
+}
+
+func (o *throttleObserver) Throttled(info simulation.ThrottleInfo) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.throttled = append(o.throttled, info)
+}
+
+func (o *throttleObserver) total(action config.LimitAction) (int, time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	n := 0
+	var wait time.Duration
+	for _, info := range o.throttled {
+		if info.Action == action {
+			n++
+			wait += info.Wait
+		}
+	}
+	return n, wait
+}
+
+func TestRateLimit(t *testing.T) {
+	c := qt.New(t)
+
+	tests := []struct {
+		about               string
+		rateLimit           config.RateLimit
+		expectedDuration    time.Duration
+		expectedCalls       int64
+		expectedFailedCalls int64
+		expectedTransitions int64
+		expectedThrottled   int
+		expectedWait        time.Duration
+	}{{
+		about: "calls wait for the rate limit",
+		rateLimit: config.RateLimit{
+			Rate: 2,
+		},
+		// the first call is performed when the timers fire
+		// and the others every 500ms.
+		expectedDuration:    1750 * time.Millisecond,
+		expectedCalls:       4,
+		expectedTransitions: 4,
+		expectedThrottled:   3,
+		expectedWait:        3 * time.Second,
+	}, {
+		about: "burst",
+		rateLimit: config.RateLimit{
+			URL:   "/login$",
+			Rate:  2,
+			Burst: 2,
+		},
+		expectedDuration:    1250 * time.Millisecond,
+		expectedCalls:       4,
+		expectedTransitions: 4,
+		expectedThrottled:   2,
+		expectedWait:        1500 * time.Millisecond,
+	}, {
+		about: "limit does not apply to other calls",
+		rateLimit: config.RateLimit{
+			Method: "GET",
+			Rate:   1,
+		},
+		expectedDuration:    250 * time.Millisecond,
+		expectedCalls:       4,
+		expectedTransitions: 4,
+	}, {
+		about: "transitions exceeding the limit are skipped",
+		rateLimit: config.RateLimit{
+			Rate:    1,
+			OnLimit: config.SkipLimitAction,
+		},
+		// entities retry the transition every 250ms and
+		// one call is performed every second.
+		expectedDuration:    3250 * time.Millisecond,
+		expectedCalls:       4,
+		expectedTransitions: 4,
+		// 3 calls are skipped 4 times, 2 calls 4 times
+		// and 1 call 4 times.
+		expectedThrottled: 24,
+	}, {
+		about: "calls exceeding the limit fail",
+		rateLimit: config.RateLimit{
+			Rate:    1,
+			OnLimit: config.FailLimitAction,
+		},
+		expectedDuration:    250 * time.Millisecond,
+		expectedCalls:       4,
+		expectedFailedCalls: 3,
+		expectedTransitions: 4,
+		expectedThrottled:   3,
+	}}
+	for i, test := range tests {
+		c.Logf("running test %d: %s", i, test.about)
+
+		var simConfig config.Config
+		err := yaml.Unmarshal([]byte(rateLimitedSim), &simConfig)
+		c.Assert(err, qt.IsNil)
+		simConfig.RateLimits = []config.RateLimit{test.rateLimit}
+
+		callBackend := &testCallBackend{}
+		sim, err := simulation.New(simConfig, callBackend)
+		c.Assert(err, qt.IsNil)
+		clock := simulation.NewVirtualClock(epoch)
+		sim.Clock = clock
+		observer := &throttleObserver{}
+		sim.Observer = observer
+		err = sim.Start(context.Background())
+		c.Assert(err, qt.IsNil)
+		err = sim.Wait()
+		c.Assert(err, qt.IsNil)
+
+		c.Assert(clock.Now().Sub(epoch), qt.Equals, test.expectedDuration)
+		stats := sim.Stats()
+		c.Assert(stats.Calls, qt.Equals, test.expectedCalls)
+		c.Assert(stats.FailedCalls, qt.Equals, test.expectedFailedCalls)
+		c.Assert(stats.Transitions, qt.Equals, test.expectedTransitions)
+		action := test.rateLimit.OnLimit
+		if action == "" {
+			action = config.WaitLimitAction
+		}
+		throttled, wait := observer.total(action)
+		c.Assert(throttled, qt.Equals, test.expectedThrottled)
+		c.Assert(wait, qt.Equals, test.expectedWait)
+		// skipped transitions do not enter the state again
+		c.Assert(observer.entered, qt.Equals, 8)
+	}
+}
+
+// concurrencyCallBackend records the maximum number of concurrent
+// calls.
+type concurrencyCallBackend struct {
+	mu       sync.Mutex
+	inFlight int
+	max      int
+	calls    int
+}
+
+func (b *concurrencyCallBackend) Do(ctx context.Context, callConfig config.Call, attributes call.Attributes) (call.Attributes, error) {
+	b.mu.Lock()
+	b.inFlight++
+	b.calls++
+	if b.inFlight > b.max {
+		b.max = b.inFlight
+	}
+	b.mu.Unlock()
+	time.Sleep(5 * time.Millisecond)
+	b.mu.Lock()
+	b.inFlight--
+	b.mu.Unlock()
+	return attributes, nil
+}
+
+func TestMaxInFlight(t *testing.T) {
+	c := qt.New(t)
+
+	var simConfig config.Config
+	err := yaml.Unmarshal([]byte(rateLimitedSim), &simConfig)
+	c.Assert(err, qt.IsNil)
+	simConfig.RootEntities[0].Cardinality = "20"
+	simConfig.RateLimits = []config.RateLimit{{
+		MaxInFlight: 3,
+	}}
+
+	callBackend := &concurrencyCallBackend{}
+	sim, err := simulation.New(simConfig, callBackend)
+	c.Assert(err, qt.IsNil)
+	err = sim.Start(context.Background())
+	c.Assert(err, qt.IsNil)
+	err = sim.Wait()
+	c.Assert(err, qt.IsNil)
+
+	c.Assert(callBackend.calls, qt.Equals, 20)
+	c.Assert(callBackend.max, qt.Equals, 3)
+}
+
+var statusSim = `
+root-entities:
+- entity: user
+  cardinality: "2"
+entities:
+  user:
+    initial_state: active
+state:
+  active:
+    timer:
+      type: fixed
+      interval: 100ms
+    transitions:
+    - state: active
+      probability: 1
+      call:
+        method: GET
+        url: http://test.com/status
+`
+
+// slowCallBackend counts calls, which take some time so that calls
+// of entities woken at the same time are performed concurrently.
+type slowCallBackend struct {
+	mu    sync.Mutex
+	calls int
+}
+
+func (b *slowCallBackend) Do(ctx context.Context, callConfig config.Call, attributes call.Attributes) (call.Attributes, error) {
+	time.Sleep(2 * time.Millisecond)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.calls++
+	return attributes, nil
+}
+
+func TestRateLimitWithMaxInFlight(t *testing.T) {
+	c := qt.New(t)
+
+	var simConfig config.Config
+	err := yaml.Unmarshal([]byte(statusSim), &simConfig)
+	c.Assert(err, qt.IsNil)
+	simConfig.Limits.Duration = 10 * time.Second
+	simConfig.RateLimits = []config.RateLimit{{
+		Rate:        2,
+		Burst:       2,
+		MaxInFlight: 1,
+		OnLimit:     config.SkipLimitAction,
+	}}
+
+	callBackend := &slowCallBackend{}
+	sim, err := simulation.New(simConfig, callBackend)
+	c.Assert(err, qt.IsNil)
+	sim.Clock = simulation.NewVirtualClock(epoch)
+	err = sim.Start(context.Background())
+	c.Assert(err, qt.IsNil)
+	err = sim.Wait()
+	c.Assert(err, qt.IsNil)
+
+	// entities attempt 20 calls per second, more than the rate: the
+	// tokens of calls refused by the in-flight limit are not lost, so
+	// calls are performed at the rate, after the initial burst.
+	c.Assert(callBackend.calls >= 21 && callBackend.calls <= 22, qt.Equals, true, qt.Commentf("%d calls", callBackend.calls))
+}
@@ -0,0 +1,89 @@
+// Copyright 2019 CanonicalLtd
+
+package simulation
+
+import (
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+
+	"github.com/cloud-green/sisyphus/config"
+	"github.com/cloud-green/sisyphus/simulation/call"
+)
+
+const defaultMultiplier = 2
+
+// retryPolicy decides whether and when failed calls are retried.
+type retryPolicy struct {
+	*config.Retry
+	rnd *rand.Rand
+}
+
+// retry returns the delay before the next attempt and true if the call
+// should be attempted again after the specified attempt failed with
+// the error.
+func (p retryPolicy) retry(attempt int, err error) (time.Duration, bool) {
+	if p.Retry == nil || err == nil || attempt >= p.MaxAttempts {
+		return 0, false
+	}
+	switch errors.Cause(err) {
+	case contextDoneError, skippedError:
+		// the call was not performed
+		return 0, false
+	}
+	if !p.retries(call.ErrorCause(err)) {
+		return 0, false
+	}
+	return p.delay(attempt), true
+}
+
+// retries reports whether calls failing with the error cause are
+// retried.
+func (p retryPolicy) retries(cause string) bool {
+	if cause == call.CauseCanceled {
+		// the simulation is stopping
+		return false
+	}
+	if len(p.On) == 0 {
+		return true
+	}
+	for _, on := range p.On {
+		if on == cause {
+			return true
+		}
+		// any failed expectation
+		if on == call.CauseExpect && strings.HasPrefix(cause, call.CauseExpect+":") {
+			return true
+		}
+		// classes of status codes, e.g. 5xx
+		if strings.HasSuffix(on, "xx") && len(cause) == 3 && cause[0] == on[0] && cause[0] >= '1' && cause[0] <= '5' {
+			return true
+		}
+	}
+	return false
+}
+
+// delay returns the delay after the specified attempt.
+func (p retryPolicy) delay(attempt int) time.Duration {
+	d := float64(p.Delay)
+	if p.Backoff == config.ExponentialBackoff {
+		multiplier := p.Multiplier
+		if multiplier == 0 {
+			multiplier = defaultMultiplier
+		}
+		d *= math.Pow(multiplier, float64(attempt-1))
+		if p.MaxDelay > 0 && d > float64(p.MaxDelay) {
+			d = float64(p.MaxDelay)
+		}
+	}
+	if p.Jitter > 0 {
+		d *= 1 + p.Jitter*(2*p.rnd.Float64()-1)
+	}
+	if d >= math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(d)
+}
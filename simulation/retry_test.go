@@ -0,0 +1,179 @@
+// Copyright 2019 CanonicalLtd
+
+package simulation_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/errors"
+	yaml "gopkg.in/yaml.v1"
+
+	"github.com/cloud-green/sisyphus/config"
+	"github.com/cloud-green/sisyphus/simulation"
+	"github.com/cloud-green/sisyphus/simulation/call"
+)
+
+// flakyCallBackend fails the first failures calls to each URL with
+// the status code and records the time of each call.
+type flakyCallBackend struct {
+	clock    simulation.Clock
+	status   int
+	failures int
+
+	mu    sync.Mutex
+	calls map[string][]time.Duration
+}
+
+func (b *flakyCallBackend) Do(ctx context.Context, callConfig config.Call, attributes call.Attributes) (call.Attributes, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.calls == nil {
+		b.calls = make(map[string][]time.Duration)
+	}
+	b.calls[callConfig.URL] = append(b.calls[callConfig.URL], b.clock.Now().Sub(epoch))
+	if len(b.calls[callConfig.URL]) <= b.failures {
+		return attributes, errors.Trace(&call.StatusError{StatusCode: b.status})
+	}
+	return attributes, nil
+}
+
+func TestRetry(t *testing.T) {
+	c := qt.New(t)
+
+	tests := []struct {
+		about              string
+		call               string
+		status             int
+		failures           int
+		expectedCalls      map[string][]time.Duration
+		expectedRetries    int64
+		expectedTransition string
+	}{{
+		about: "fixed backoff",
+		call: `
+url: /login
+retry:
+  max-attempts: 3
+  delay: 2s
+`,
+		status:   503,
+		failures: 2,
+		expectedCalls: map[string][]time.Duration{
+			"/login": {0, 2 * time.Second, 4 * time.Second},
+		},
+		expectedRetries:    2,
+		expectedTransition: "done",
+	}, {
+		about: "exponential backoff",
+		call: `
+url: /login
+retry:
+  max-attempts: 5
+  backoff: exponential
+  delay: 1s
+  max-delay: 5s
+`,
+		status:   503,
+		failures: 5,
+		expectedCalls: map[string][]time.Duration{
+			"/login": {0, time.Second, 3 * time.Second, 7 * time.Second, 12 * time.Second},
+		},
+		expectedRetries:    4,
+		expectedTransition: "failed",
+	}, {
+		about: "retry on status classes",
+		call: `
+url: /login
+retry:
+  max-attempts: 3
+  delay: 1s
+  on: [timeout, 5xx]
+`,
+		status:   500,
+		failures: 1,
+		expectedCalls: map[string][]time.Duration{
+			"/login": {0, time.Second},
+		},
+		expectedRetries:    1,
+		expectedTransition: "done",
+	}, {
+		about: "no retry on other errors",
+		call: `
+url: /login
+retry:
+  max-attempts: 3
+  delay: 1s
+  on: ["503", timeout]
+`,
+		status:   404,
+		failures: 1,
+		expectedCalls: map[string][]time.Duration{
+			"/login": {0},
+		},
+		expectedTransition: "failed",
+	}, {
+		about: "retried parallel calls",
+		call: `
+parallel:
+- url: /style.css
+  retry:
+    max-attempts: 2
+    delay: 1s
+- url: /script.js
+  retry:
+    max-attempts: 2
+    delay: 3s
+`,
+		status:   503,
+		failures: 1,
+		expectedCalls: map[string][]time.Duration{
+			"/style.css": {0, time.Second},
+			"/script.js": {0, 3 * time.Second},
+		},
+		expectedRetries:    2,
+		expectedTransition: "done",
+	}}
+
+	for i, test := range tests {
+		c.Logf("running test %d: %s", i, test.about)
+
+		var simConfig config.Config
+		err := yaml.Unmarshal([]byte(callsSim), &simConfig)
+		c.Assert(err, qt.IsNil)
+		var callConfig config.Call
+		err = yaml.Unmarshal([]byte(test.call), &callConfig)
+		c.Assert(err, qt.IsNil)
+		simConfig.States["home"] = config.State{
+			Transitions: []config.Transition{{
+				State:       "done",
+				Probability: "1",
+				OnFailure:   "failed",
+				Call:        callConfig,
+			}},
+		}
+
+		clock := simulation.NewVirtualClock(epoch)
+		callBackend := &flakyCallBackend{
+			clock:    clock,
+			status:   test.status,
+			failures: test.failures,
+		}
+		sim, err := simulation.New(simConfig, callBackend)
+		c.Assert(err, qt.IsNil)
+		sim.Clock = clock
+		observer := &transitionObserver{}
+		sim.Observer = observer
+		err = sim.Start(context.Background())
+		c.Assert(err, qt.IsNil)
+		err = sim.Wait()
+		c.Assert(err, qt.IsNil)
+
+		c.Assert(callBackend.calls, qt.DeepEquals, test.expectedCalls)
+		c.Assert(sim.Stats().Retries, qt.Equals, test.expectedRetries)
+		c.Assert(observer.targets, qt.DeepEquals, []string{test.expectedTransition})
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2019 CanonicalLtd
+
+package simulation_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	yaml "gopkg.in/yaml.v1"
+
+	"github.com/cloud-green/sisyphus/config"
+	"github.com/cloud-green/sisyphus/simulation"
+	"github.com/cloud-green/sisyphus/simulation/call"
+)
+
+var sessionSim = `
+root-entities:
+- entity: user
+  cardinality: "2"
+- entity: bot
+  cardinality: "2"
+entities:
+  user:
+    initial_state: home
+    attributes:
+      name:
+        type: random_string
+    subordinates:
+    - entity: device
+      cardinality: "2"
+  device:
+    initial_state: home
+    session:
+      scope: parent
+  bot:
+    initial_state: home
+    attributes:
+      name:
+        type: string
+        string-value: bot
+    session:
+      scope: shared
+state:
+  home:
+    transitions:
+    - state: done
+      probability: 1
+      call:
+        url: /home
+  done:
+`
+
+// sessionCallBackend records the sessions of calls by the name
+// attribute of the calling entity.
+type sessionCallBackend struct {
+	mu       sync.Mutex
+	sessions map[string][]*call.Session
+}
+
+func (b *sessionCallBackend) Do(ctx context.Context, callConfig config.Call, attributes call.Attributes) (call.Attributes, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	name := attributes["name"].(string)
+	b.sessions[name] = append(b.sessions[name], call.SessionFromContext(ctx))
+	return attributes, nil
+}
+
+func TestSessions(t *testing.T) {
+	c := qt.New(t)
+
+	var simConfig config.Config
+	err := yaml.Unmarshal([]byte(sessionSim), &simConfig)
+	c.Assert(err, qt.IsNil)
+
+	callBackend := &sessionCallBackend{
+		sessions: make(map[string][]*call.Session),
+	}
+	sim, err := simulation.New(simConfig, callBackend)
+	c.Assert(err, qt.IsNil)
+	err = sim.Start(context.Background())
+	c.Assert(err, qt.IsNil)
+	err = sim.Wait()
+	c.Assert(err, qt.IsNil)
+
+	// each user and its devices share a session, distinct from the
+	// sessions of other users, and bots share a single session.
+	c.Assert(callBackend.sessions, qt.HasLen, 3)
+	seen := make(map[*call.Session]bool)
+	for name, sessions := range callBackend.sessions {
+		if name == "bot" {
+			c.Assert(sessions, qt.HasLen, 2)
+		} else {
+			// the user and its devices
+			c.Assert(sessions, qt.HasLen, 3)
+		}
+		for _, s := range sessions {
+			c.Assert(s, qt.Not(qt.IsNil))
+			c.Assert(s, qt.Equals, sessions[0])
+		}
+		c.Assert(seen[sessions[0]], qt.Equals, false)
+		seen[sessions[0]] = true
+	}
+}
+
+var closedSessionSim = `
+root-entities:
+- entity: user
+  cardinality: "2"
+entities:
+  user:
+    initial_state: home
+    session:
+      isolate-connections: true
+    subordinates:
+    - entity: device
+      cardinality: "2"
+      timer:
+        type: fixed
+        interval: 10ms
+  device:
+    initial_state: sync
+    session:
+      scope: parent
+state:
+  home:
+    transitions:
+    - state: done
+      probability: 1
+      call:
+        method: GET
+        url: /home
+  sync:
+    timer:
+      type: fixed
+      interval: 10ms
+    transitions:
+    - state: done
+      probability: 1
+      call:
+        url: /sync
+  done:
+`
+
+// closingHTTPClient records whether its connections were closed and
+// whether it was used once closed.
+type closingHTTPClient struct {
+	mu               sync.Mutex
+	closed           int
+	callsAfterClosed int
+}
+
+func (c *closingHTTPClient) DoWithBody(req *http.Request, body io.ReadSeeker) (*http.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed > 0 {
+		c.callsAfterClosed++
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+	}, nil
+}
+
+func (c *closingHTTPClient) CloseIdleConnections() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed++
+}
+
+func TestSessionsClosed(t *testing.T) {
+	c := qt.New(t)
+
+	var simConfig config.Config
+	err := yaml.Unmarshal([]byte(closedSessionSim), &simConfig)
+	c.Assert(err, qt.IsNil)
+
+	var mu sync.Mutex
+	var clients []*closingHTTPClient
+	callBackend := call.NewSessionHTTPCallBackend(func(isolated bool) call.HTTPClient {
+		mu.Lock()
+		defer mu.Unlock()
+		client := &closingHTTPClient{}
+		if isolated {
+			// the client of a user session
+			clients = append(clients, client)
+		}
+		return client
+	})
+	sim, err := simulation.New(simConfig, callBackend)
+	c.Assert(err, qt.IsNil)
+	err = sim.Start(context.Background())
+	c.Assert(err, qt.IsNil)
+	err = sim.Wait()
+	c.Assert(err, qt.IsNil)
+
+	// each user and its devices share a session, which is closed
+	// once the user and its devices finish.
+	c.Assert(clients, qt.HasLen, 2)
+	for _, client := range clients {
+		c.Assert(client.closed, qt.Equals, 1)
+		c.Assert(client.callsAfterClosed, qt.Equals, 0)
+	}
+}
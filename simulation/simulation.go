@@ -7,14 +7,17 @@ import (
 	"fmt"
 	"math"
 	"math/rand"
+	"sort"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/juju/errors"
 	"github.com/juju/utils"
 	"github.com/juju/zaputil"
 	"github.com/juju/zaputil/zapctx"
+	"go.uber.org/zap"
 	"gopkg.in/macaroon-bakery.v1/httpbakery"
 
 	"github.com/cloud-green/sisyphus/config"
@@ -25,9 +28,12 @@ var (
 	contextDoneError = errors.New("done")
 )
 
-func init() {
-	rand.Seed(time.Now().UnixNano())
-}
+const (
+	// defaultGracePeriod holds the time in-flight calls are given to
+	// complete once the simulation is stopped, unless specified
+	// in the configuration.
+	defaultGracePeriod = 10 * time.Second
+)
 
 // CallBackend defines the interface used by the simulation to perform
 // state transition calls.
@@ -35,27 +41,63 @@ type CallBackend interface {
 	Do(context.Context, config.Call, call.Attributes) (call.Attributes, error)
 }
 
+// NamedCallBackend is implemented by call backends that report their
+// name, which identifies the backend in observations of calls.
+type NamedCallBackend interface {
+	CallBackend
+	Name() string
+}
+
+// unknownBackend identifies call backends that do not report their
+// name.
+const unknownBackend = config.CallBackend("unknown")
+
+// backendName returns the name identifying the call backend in
+// observations of calls.
+func backendName(b CallBackend) config.CallBackend {
+	if b, ok := b.(NamedCallBackend); ok && b.Name() != "" {
+		return config.CallBackend(b.Name())
+	}
+	return unknownBackend
+}
+
 // New returns a new simulation based on the provided configuration.
+// The configuration is validated, but the simulation is not started
+// until Start is called. If the configuration does not specify a seed,
+// a seed is derived from the current time; the seed used is available
+// as the Seed field of the simulation.
 func New(config config.Config, callBackend CallBackend) (*Simulation, error) {
-	ctx, cancel := context.WithCancel(context.Background())
-
-	s := &Simulation{
+	if err := config.Validate(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if config.Seed == 0 {
+		config.Seed = time.Now().UnixNano()
+	}
+	return &Simulation{
 		Config:      config,
 		Attributes:  config.Constants,
 		CallBackend: callBackend,
-		ctx:         ctx,
-		stop:        cancel,
+		Clock:       RealClock,
+		Observer:    NopObserver{},
 		client:      httpbakery.NewClient(),
-	}
-
-	// start creating root entity sets
-	for _, entitySet := range config.RootEntities {
-		newEntitySet(ctx, entitySet, copyAttributes(s.Attributes), s)
-	}
-
-	s.wg.Wait()
+		finished:    make(chan struct{}),
+	}, nil
+}
 
-	return s, nil
+// Stats holds counters describing the execution of a simulation.
+type Stats struct {
+	// Entities holds the number of created entities.
+	Entities int64
+	// Transitions holds the number of performed state transitions.
+	Transitions int64
+	// Calls holds the number of performed calls.
+	Calls int64
+	// FailedCalls holds the number of calls that returned an error.
+	FailedCalls int64
+	// Retries holds the number of calls that retried a failed call.
+	Retries int64
+	// Duration holds the duration of the simulation.
+	Duration time.Duration
 }
 
 // Simulation represents a simulation
@@ -64,20 +106,199 @@ type Simulation struct {
 	CallBackend
 	call.Attributes
 
-	ctx    context.Context
-	wg     sync.WaitGroup
-	errors chan error
-	stop   func()
-	client *httpbakery.Client
+	// Clock holds the clock used by simulation timers. It defaults
+	// to RealClock and may be replaced before the simulation
+	// is started.
+	Clock Clock
+
+	// Observer is notified of events occurring during the execution
+	// of the simulation. It defaults to NopObserver and may be replaced
+	// before the simulation is started.
+	Observer Observer
+
+	ctx      context.Context
+	callCtx  context.Context
+	wg       sync.WaitGroup
+	stop     func()
+	client   *httpbakery.Client
+	finished chan struct{}
+	limiters rateLimiters
+	traces   map[string]*trace
+	exprs    expressions
+	// session holds the session shared by entities with the shared
+	// session scope.
+	session *sessionRef
+	// backend identifies the call backend in observations of calls.
+	backend config.CallBackend
+	// stopLimit stops the goroutine enforcing the duration limit.
+	stopLimit func()
+	// running holds the number of running goroutines of the
+	// simulation.
+	running int64
+
+	mu       sync.Mutex
+	started  bool
+	start    time.Time
+	duration time.Duration
+	err      error
+
+	entities    int64
+	transitions int64
+	calls       int64
+	failedCalls int64
+	retries     int64
+}
+
+// Start starts the simulation in the background. The simulation runs
+// until all entities reach a state without transitions, any of the
+// configured limits is reached, Stop is called or the context is
+// cancelled.
+func (s *Simulation) Start(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return errors.New("simulation already started")
+	}
+	limiters, err := newRateLimiters(s.RateLimits)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	s.limiters = limiters
+	traces, err := loadTraces(s.Config)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	s.traces = traces
+	s.session = &sessionRef{
+		session: call.NewSession(false),
+	}
+	s.backend = backendName(s.CallBackend)
+	s.started = true
+	s.start = time.Now()
+
+	s.ctx, s.stop = context.WithCancel(ctx)
+	// calls are performed using a separate context, so that in-flight
+	// calls are given a grace period to complete once the simulation
+	// is stopped.
+	callCtx, cancelCalls := context.WithCancel(zapctx.WithLogger(context.Background(), zapctx.Logger(ctx)))
+	s.callCtx = callCtx
+
+	gracePeriod := s.Limits.GracePeriod
+	if gracePeriod == 0 {
+		gracePeriod = defaultGracePeriod
+	}
+	go func(ctx context.Context) {
+		<-ctx.Done()
+		select {
+		case <-time.After(gracePeriod):
+			cancelCalls()
+		case <-callCtx.Done():
+		}
+	}(s.ctx)
+
+	// the clock must not advance and the simulation must not
+	// finish until all root entity sets have been started
+	s.add()
+	defer s.done()
+
+	// the duration limit is stopped once the simulation finishes,
+	// before a virtual clock can advance to it
+	limitCtx, stopLimit := context.WithCancel(s.ctx)
+	s.stopLimit = stopLimit
+	if s.Limits.Duration > 0 {
+		s.Clock.Started()
+		go func(ctx context.Context) {
+			defer s.Clock.Stopped()
+			if s.Clock.Sleep(ctx, s.Limits.Duration) == nil {
+				s.limitReached("duration")
+			}
+		}(limitCtx)
+	}
+
+	// start creating root entity sets, each with its own source
+	// of randomness derived from the simulation seed
+	rnd := rand.New(rand.NewSource(s.Seed))
+	for _, entitySet := range s.RootEntities {
+		newEntitySet(s.ctx, entitySet, copyAttributes(s.Attributes), nil, s, newRand(rnd))
+	}
+
+	go func() {
+		s.wg.Wait()
+		s.stop()
+		cancelCalls()
+
+		s.mu.Lock()
+		s.duration = time.Since(s.start)
+		s.mu.Unlock()
+		close(s.finished)
+	}()
+	return nil
+}
+
+// Stop stops the simulation. In-flight calls are given the configured
+// grace period to complete. Stop does not wait for the simulation to
+// finish.
+func (s *Simulation) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stop != nil {
+		s.stop()
+	}
+}
+
+// Wait waits for the simulation to finish and returns the first
+// error that occurred during its execution.
+func (s *Simulation) Wait() error {
+	s.mu.Lock()
+	started := s.started
+	s.mu.Unlock()
+	if !started {
+		return errors.New("simulation not started")
+	}
+	<-s.finished
+	return s.Err()
+}
+
+// Err returns the first error that occurred during execution of the
+// simulation or nil if no error has occurred so far.
+func (s *Simulation) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Stats returns the simulation counters.
+func (s *Simulation) Stats() Stats {
+	s.mu.Lock()
+	duration := s.duration
+	if s.started && duration == 0 {
+		duration = time.Since(s.start)
+	}
+	s.mu.Unlock()
+	return Stats{
+		Entities:    atomic.LoadInt64(&s.entities),
+		Transitions: atomic.LoadInt64(&s.transitions),
+		Calls:       atomic.LoadInt64(&s.calls),
+		FailedCalls: atomic.LoadInt64(&s.failedCalls),
+		Retries:     atomic.LoadInt64(&s.retries),
+		Duration:    duration,
+	}
 }
 
 // add means that a go routing should be added to the wait group
 func (s *Simulation) add() {
+	s.Clock.Started()
+	atomic.AddInt64(&s.running, 1)
 	s.wg.Add(1)
 }
 
 // done means that a go routing has exited
 func (s *Simulation) done() {
+	if atomic.AddInt64(&s.running, -1) == 0 {
+		// the simulation has finished
+		s.stopLimit()
+	}
+	s.Clock.Stopped()
 	s.wg.Done()
 }
 
@@ -86,18 +307,158 @@ func (s *Simulation) done() {
 func (s *Simulation) error(err error) {
 	if errors.Cause(err) != contextDoneError {
 		zapctx.Error(s.ctx, "an error occured", zaputil.Error(err))
+		s.mu.Lock()
+		if s.err == nil {
+			s.err = err
+		}
+		s.mu.Unlock()
 	}
 	s.stop()
 }
 
-func newEntitySet(ctx context.Context, config config.EntitySet, attributes call.Attributes, sim *Simulation) {
+// limitReached means that the named limit has been reached and the
+// simulation should be stopped.
+func (s *Simulation) limitReached(limit string) {
+	if s.ctx.Err() == nil {
+		zapctx.Info(s.ctx, "limit reached, stopping simulation", zap.String("limit", limit))
+	}
+	s.stop()
+}
+
+// transition records a state transition. It returns false if the
+// transition would exceed the maximum number of transitions.
+func (s *Simulation) transition() bool {
+	n := atomic.AddInt64(&s.transitions, 1)
+	if s.Limits.MaxTransitions > 0 && n > int64(s.Limits.MaxTransitions) {
+		atomic.AddInt64(&s.transitions, -1)
+		s.limitReached("max-transitions")
+		return false
+	}
+	return true
+}
+
+// skipTransition reverts recording of a transition that was skipped.
+func (s *Simulation) skipTransition() {
+	atomic.AddInt64(&s.transitions, -1)
+}
+
+// call performs the call of the transition from the state to the
+// target state using the call backend, retrying it according to its
+// retry policy.
+func (s *Simulation) call(ctx context.Context, state *State, target string, callConfig config.Call, attributes call.Attributes) (call.Attributes, error) {
+	policy := retryPolicy{
+		Retry: callConfig.Retry,
+		rnd:   state.rnd,
+	}
+	for attempt := 1; ; attempt++ {
+		result, err := s.attempt(ctx, state, target, callConfig, attributes, attempt)
+		delay, ok := policy.retry(attempt, err)
+		if !ok {
+			return result, err
+		}
+		zapctx.Debug(ctx, "retrying call", zap.String("url", callConfig.URL), zap.Int("attempt", attempt), zap.Duration("delay", delay), zaputil.Error(err))
+		if err := s.Clock.Sleep(ctx, delay); err != nil {
+			return result, contextDoneError
+		}
+	}
+}
+
+// attempt performs a single attempt of the call. Calls that would
+// exceed the maximum number of calls are not performed. Calls exceeding
+// rate limits wait, are skipped or fail depending on the limit.
+func (s *Simulation) attempt(ctx context.Context, state *State, target string, callConfig config.Call, attributes call.Attributes, attempt int) (call.Attributes, error) {
+	n := atomic.AddInt64(&s.calls, 1)
+	if s.Limits.MaxCalls > 0 && n > int64(s.Limits.MaxCalls) {
+		atomic.AddInt64(&s.calls, -1)
+		s.limitReached("max-calls")
+		return attributes, contextDoneError
+	}
+	release, err := s.limiters.acquire(ctx, s, callConfig)
+	if err != nil && errors.Cause(err) != call.ErrThrottled {
+		// the call was skipped or the simulation stopped
+		// while waiting
+		atomic.AddInt64(&s.calls, -1)
+		return attributes, errors.Trace(err)
+	}
+	start := time.Now()
+	if err == nil {
+		attributes, err = s.do(state, callConfig, attributes)
+		release()
+		if err == nil {
+			err = s.computeResults(callConfig, attributes)
+		}
+	}
+	s.Observer.Call(CallInfo{
+		Backend:  s.backend,
+		Entity:   state.entity.name,
+		State:    state.name,
+		Target:   target,
+		Call:     callConfig,
+		Start:    start,
+		Duration: time.Since(start),
+		Err:      err,
+		Attempt:  attempt,
+	})
+	if attempt > 1 {
+		atomic.AddInt64(&s.retries, 1)
+	}
+	if err != nil {
+		atomic.AddInt64(&s.failedCalls, 1)
+	}
+	return attributes, err
+}
+
+// do performs the call in the session of the entity using the call
+// backend within the timeout of the call. Random data sent by the call
+// is drawn from the source of randomness of the state.
+func (s *Simulation) do(state *State, callConfig config.Call, attributes call.Attributes) (call.Attributes, error) {
+	ctx := call.ContextWithSession(s.callCtx, state.entity.session.session)
+	ctx = call.ContextWithRand(ctx, state.rnd)
+	timeout := callConfig.Timeout
+	if timeout == 0 {
+		timeout = s.CallTimeout
+	}
+	if timeout == 0 {
+		return s.Do(ctx, callConfig, attributes)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	attributes, err := s.Do(ctx, callConfig, attributes)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		err = errors.Wrap(err, &call.TimeoutError{Timeout: timeout})
+	}
+	return attributes, err
+}
+
+// computeResults sets the call results computed from expressions.
+func (s *Simulation) computeResults(callConfig config.Call, attributes call.Attributes) error {
+	for _, r := range callConfig.Results {
+		if r.Expr == "" {
+			continue
+		}
+		value, err := s.eval(r.Expr, attributes)
+		if err != nil {
+			return errors.Annotatef(err, "cannot compute result %q", r.Attribute)
+		}
+		attributes[r.Attribute] = value
+	}
+	return nil
+}
+
+func newEntitySet(ctx context.Context, config config.EntitySet, attributes call.Attributes, session *sessionRef, sim *Simulation, rnd *rand.Rand) {
 	es := &entitySet{
 		EntitySet:  config,
 		attributes: attributes,
+		session:    session,
+		rnd:        rnd,
 	}
+	// the entity set uses the session of the parent entity until
+	// it stops creating entities
+	session.acquire()
 	sim.add()
 	go func(ctx context.Context) {
 		defer sim.done()
+		defer session.release()
 		es.create(ctx, sim)
 	}(ctx)
 }
@@ -105,6 +466,10 @@ func newEntitySet(ctx context.Context, config config.EntitySet, attributes call.
 type entitySet struct {
 	config.EntitySet
 	attributes call.Attributes
+	// session holds the session of the parent entity, or nil for
+	// root entity sets.
+	session *sessionRef
+	rnd     *rand.Rand
 }
 
 func (e *entitySet) create(ctx context.Context, sim *Simulation) {
@@ -114,6 +479,12 @@ func (e *entitySet) create(ctx context.Context, sim *Simulation) {
 		sim.error(errors.NotFoundf("entity %q", e.Entity))
 		return
 	}
+	// entity sets with a load profile create and retire entities
+	// following the profile's stages
+	if len(e.Stages) > 0 {
+		e.runProfile(ctx, sim, cfg)
+		return
+	}
 	// determine how many entities are to be created
 	c := cardinality(e.Cardinality)
 	numberOfEntities, err := c.Value(e.attributes)
@@ -123,83 +494,296 @@ func (e *entitySet) create(ctx context.Context, sim *Simulation) {
 	}
 	// create the timer that determines the cadence of entity
 	// creation
-	timer := newTimer(e.Timer)
+	timer := newTimer(e.Timer, e.rnd, sim)
 	for i := 0; i < numberOfEntities; i++ {
-		err = timer.Next(ctx)
-		if err != nil {
-			sim.error(errors.Trace(err))
+		if err := timer.Next(ctx); err != nil {
+			// the simulation or the parent entity
+			// has been stopped
 			return
 		}
-		createEntity(ctx, cfg, copyAttributes(e.attributes), sim)
+		createEntity(ctx, nil, e.Entity, cfg, copyAttributes(e.attributes), e.session, sim, newRand(e.rnd))
 	}
 	return
 }
 
-func createEntity(ctx context.Context, config config.Entity, attributes call.Attributes, sim *Simulation) {
+// entity holds the runtime state of a created entity.
+type entity struct {
+	name   string
+	config config.Entity
+	// ctx is the context of the entity and its subordinates.
+	ctx context.Context
+	// cancel cancels the entity's context stopping the entity and
+	// its subordinates. It is nil for entities that cannot be
+	// stopped individually.
+	cancel func()
+	// stop stops the entity's state machine, if the entity
+	// has a lifetime.
+	stop func()
+	// transitions holds the number of transitions performed by
+	// the entity. It is only accessed by the state machine.
+	transitions int
+	// expired is set to 1 once the entity expires.
+	expired int32
+	// finished is set to 1 once the entity's state machine
+	// finishes.
+	finished int32
+	// session holds the session of the entity's calls.
+	session *sessionRef
+}
+
+// expire is called when the entity's lifetime elapses or it reaches
+// the maximum number of transitions. It stops the state machine, which
+// then performs the on-expire transition.
+func (e *entity) expire() {
+	atomic.StoreInt32(&e.expired, 1)
+	if e.stop != nil {
+		e.stop()
+	}
+}
+
+// isExpired returns true if the entity has expired.
+func (e *entity) isExpired() bool {
+	return atomic.LoadInt32(&e.expired) == 1
+}
+
+// finish is called when the entity's state machine finishes.
+func (e *entity) finish(sim *Simulation) {
+	atomic.StoreInt32(&e.finished, 1)
+	if e.stop != nil {
+		// release the lifetime timer
+		e.stop()
+	}
+	if e.cancel != nil && (len(e.config.Subordinates) == 0 || e.isExpired()) {
+		// release the context, unless subordinates
+		// still use it. Subordinates of expired entities
+		// are stopped.
+		e.cancel()
+	}
+	// the session is closed once the subordinates using it finish
+	e.session.release()
+	sim.Observer.EntityFinished(e.name)
+}
+
+// isFinished returns true if the entity's state machine has finished.
+func (e *entity) isFinished() bool {
+	return atomic.LoadInt32(&e.finished) == 1
+}
+
+// expires returns true if the entity expires after a lifetime
+// or a maximum number of transitions.
+func expires(config config.Entity) bool {
+	return config.Lifetime.Type != "" || config.MaxTransitions > 0
+}
+
+// createEntity creates an entity, its subordinates and starts its state
+// machine. The cancel function, if not nil, must cancel ctx. The parent
+// session holds the session of the parent entity, if any. It returns
+// nil if the entity could not be created.
+func createEntity(ctx context.Context, cancel func(), name string, config config.Entity, attributes call.Attributes, parent *sessionRef, sim *Simulation, rnd *rand.Rand) *entity {
+	atomic.AddInt64(&sim.entities, 1)
+	sim.Observer.EntityCreated(name)
+	if cancel == nil && expires(config) {
+		// expired entities stop their subordinates
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	e := &entity{
+		name:   name,
+		config: config,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	e.session = entitySession(config.Session, parent, sim.session)
 	// the we sample the entities attributes
-	for key, config := range config.Attributes {
-		distribution := &AttributeDistribution{
-			Attribute: config,
-		}
-		value, err := distribution.Sample()
-		if err != nil {
-			sim.error(errors.Trace(err))
-			return
-		}
-		// and add sampled values to the set of attributes (as strings)
-		attributes[key] = value
+	if err := sampleAttributes(sim, config.Attributes, attributes, rnd); err != nil {
+		e.session.release()
+		sim.Observer.EntityFinished(name)
+		sim.error(errors.Trace(err))
+		return nil
 	}
 
 	// if there are any subordinate entities, we create them
 	for _, esConfig := range config.Subordinates {
-		newEntitySet(ctx, esConfig, attributes, sim)
+		newEntitySet(ctx, esConfig, attributes, e.session, sim, newRand(rnd))
 	}
 
 	// if an initial state is defined, we create it and run the state simulation
-	if config.InitialState != "" {
-		stateConfig, ok := sim.States[config.InitialState]
-		if !ok {
-			sim.error(errors.NotFoundf("state %q", config.InitialState))
-			return
+	if config.InitialState == "" {
+		// the entity performs no calls, only its subordinates
+		// use its session
+		e.session.release()
+		sim.Observer.EntityFinished(name)
+		if config.Lifetime.Type != "" {
+			// the entity lives, and its subordinates
+			// run, until its lifetime elapses.
+			e.runLifetime(ctx, sim, newRand(rnd), e.cancel)
 		}
-		s := &State{
-			State:      stateConfig,
-			Attributes: copyAttributes(attributes),
+		return e
+	}
+	stateConfig, ok := sim.States[config.InitialState]
+	if !ok {
+		e.session.release()
+		sim.Observer.EntityFinished(name)
+		sim.error(errors.NotFoundf("state %q", config.InitialState))
+		return nil
+	}
+	s := &State{
+		State:      stateConfig,
+		Attributes: copyAttributes(attributes),
+		entity:     e,
+		name:       config.InitialState,
+		rnd:        newRand(rnd),
+	}
+	runCtx := ctx
+	if config.Lifetime.Type != "" {
+		runCtx, e.stop = context.WithCancel(ctx)
+		e.runLifetime(runCtx, sim, newRand(rnd), e.expire)
+	}
+	sim.add()
+	go func() {
+		defer sim.done()
+		s.run(runCtx, sim)
+	}()
+	return e
+}
+
+// entitySession returns the session of an entity with the session
+// settings, given the session of its parent entity, if any, and the
+// shared session. The entity uses the session until it releases it.
+func entitySession(cfg config.Session, parent, shared *sessionRef) *sessionRef {
+	switch cfg.Scope {
+	case config.SharedSession:
+		shared.acquire()
+		return shared
+	case config.ParentSession:
+		if parent != nil {
+			parent.acquire()
+			return parent
 		}
-		sim.add()
-		go func() {
-			defer sim.done()
-			s.run(ctx, sim)
-		}()
 	}
+	return &sessionRef{
+		session: call.NewSession(cfg.IsolateConnections),
+		owned:   true,
+		users:   1,
+	}
+}
+
+// sessionRef holds a session and the number of its users: the entities
+// performing calls in the session and the entity sets that may create
+// more of them. A session created for an entity is closed once it has
+// no users.
+type sessionRef struct {
+	session *call.Session
+	// owned is true if the session was created for an entity.
+	owned bool
+	users int32
+}
 
+// acquire adds a user of the session.
+func (r *sessionRef) acquire() {
+	if r != nil {
+		atomic.AddInt32(&r.users, 1)
+	}
+}
+
+// release removes a user of the session, closing the session once it
+// has no users.
+func (r *sessionRef) release() {
+	if r != nil && atomic.AddInt32(&r.users, -1) == 0 && r.owned {
+		r.session.Close()
+	}
+}
+
+// runLifetime calls expire once the lifetime of the entity elapses,
+// unless the context is cancelled first.
+func (e *entity) runLifetime(ctx context.Context, sim *Simulation, rnd *rand.Rand, expire func()) {
+	timer := newTimer(e.config.Lifetime, rnd, sim)
+	sim.add()
+	go func() {
+		defer sim.done()
+		if err := timer.Next(ctx); err == nil {
+			expire()
+		}
+	}()
 }
 
 type State struct {
 	config.State
 	call.Attributes
+
+	// entity holds the entity in this state.
+	entity *entity
+	// name holds the name of the state.
+	name string
+	// final is true for the state an expired entity finishes in.
+	final bool
+
+	// rnd is the source of randomness of the entity, which is
+	// passed from state to state.
+	rnd *rand.Rand
 }
 
 func (s *State) generateAttributes(ctx context.Context, sim *Simulation) error {
 	// the we sample the state attributes if any are defined
-	for key, config := range s.State.Attributes {
+	return errors.Trace(sampleAttributes(sim, s.State.Attributes, s.Attributes, s.rnd))
+}
+
+// sampleAttributes samples the configured attributes and adds
+// sampled values to the set of attributes. Attributes are sampled
+// in order of their names, so that the same source of randomness
+// always yields the same values. Computed attributes are evaluated
+// once all other attributes have been sampled.
+func sampleAttributes(sim *Simulation, configs map[string]config.Attribute, attributes call.Attributes, rnd *rand.Rand) error {
+	keys := make([]string, 0, len(configs))
+	for key := range configs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var computed []string
+	for _, key := range keys {
+		if configs[key].Type == config.ExprAttributeType {
+			computed = append(computed, key)
+			continue
+		}
 		distribution := &AttributeDistribution{
-			Attribute: config,
+			Attribute: configs[key],
 		}
-		value, err := distribution.Sample()
+		value, err := distribution.Sample(rnd)
 		if err != nil {
 			return errors.Trace(err)
 		}
-		// and add sampled values to the set of attributes (as strings)
-		s.Attributes[key] = value
+		attributes[key] = value
+	}
+	for _, key := range computed {
+		value, err := sim.eval(configs[key].Expr, attributes)
+		if err != nil {
+			return errors.Annotatef(err, "cannot compute attribute %q", key)
+		}
+		attributes[key] = value
 	}
 	return nil
 }
 
 func (s *State) run(ctx context.Context, sim *Simulation) {
+	sim.Observer.StateEntered(s.entity.name, s.name)
+	// the entity finishes unless it moves on to the next state
+	next := false
+	defer func() {
+		if !next && s.entity.isExpired() && !s.final {
+			next = s.expire(sim)
+		}
+		sim.Observer.StateExited(s.entity.name, s.name)
+		if !next {
+			s.entity.finish(sim)
+		}
+	}()
+
 	// if there are no specified transtions, we just
 	// return and end the simulation
-	if len(s.Transitions) == 0 {
+	if len(s.Transitions) == 0 || s.final {
+		return
+	}
+	if max := s.entity.config.MaxTransitions; max > 0 && s.entity.transitions >= max {
+		s.entity.expire()
 		return
 	}
 
@@ -210,164 +794,457 @@ func (s *State) run(ctx context.Context, sim *Simulation) {
 	}
 
 	// create a time that defines the transition cadence
-	timer := newTimer(s.Timer)
-	// wait for the timer to fire
-	err = timer.Next(ctx)
+	timer := newTimer(s.Timer, s.rnd, sim)
+	var transition config.Transition
+	var attributes call.Attributes
+	// progress holds the calls of a skipped transition that were
+	// performed before one of its calls was skipped.
+	var progress *callProgress
+	for {
+		// wait for the timer to fire
+		if err := timer.Next(ctx); err != nil {
+			// the simulation or the entity has been stopped
+			return
+		}
+		if progress == nil {
+			var ok bool
+			transition, ok = s.chooseTransition(ctx, sim)
+			if !ok {
+				if s.Fallback == nil {
+					// no transition is eligible and the entity
+					// finishes in this state
+					zapctx.Debug(ctx, "no eligible transition", zap.String("state", s.name))
+					return
+				}
+				transition = *s.Fallback
+			}
+		}
+		if !sim.transition() {
+			return
+		}
+		attributes, err = s.Attributes, nil
+		if calls := transition.CallSequence(); len(calls) > 0 {
+			if progress == nil {
+				progress = newCallProgress()
+			}
+			attributes, err = s.performCalls(ctx, sim, transition, calls, progress)
+		}
+		if errors.Cause(err) == contextDoneError {
+			return
+		}
+		if errors.Cause(err) != skippedError {
+			break
+		}
+		// the transition is skipped and the entity remains
+		// in the current state until the timer fires again.
+		// It then resumes the transition with the calls that
+		// were not performed.
+		sim.skipTransition()
+	}
+	nextStateName := transition.State
+	failure := false
 	if err != nil {
-		sim.error(errors.Trace(err))
+		zapctx.Error(ctx, "error performing call", zaputil.Error(err))
+		attributes["error"] = errors.Details(err)
+		if state := failureState(transition, err); state != "" {
+			nextStateName = state
+			failure = true
+		}
+	}
+	nextStateConfig, ok := sim.States[nextStateName]
+	if !ok {
+		sim.error(errors.NotFoundf("state %q", nextStateName))
 		return
 	}
+
+	sim.Observer.Transition(s.entity.name, s.name, nextStateName, failure)
+	s.entity.transitions++
+
+	nextState := &State{
+		State:      nextStateConfig,
+		Attributes: copyAttributes(attributes),
+		entity:     s.entity,
+		name:       nextStateName,
+		rnd:        s.rnd,
+	}
+	next = true
+	sim.add()
+	go func() {
+		defer sim.done()
+		nextState.run(ctx, sim)
+	}()
+}
+
+// expire performs the on-expire transition of the expired entity, if
+// any. It returns true if the entity moves to the final state of the
+// transition.
+func (s *State) expire(sim *Simulation) bool {
+	transition := s.entity.config.OnExpire
+	// the entity's context is not cancelled when it expires, but
+	// when the simulation or the parent entity stops.
+	ctx := s.entity.ctx
+	if transition == nil || ctx.Err() != nil {
+		return false
+	}
+	if !sim.transition() {
+		return false
+	}
+	nextStateName := transition.State
+	attributes := s.Attributes
+	failure := false
+	if calls := transition.CallSequence(); len(calls) > 0 {
+		var err error
+		attributes, err = s.performCalls(ctx, sim, *transition, calls, newCallProgress())
+		if errors.Cause(err) == contextDoneError {
+			return false
+		}
+		if errors.Cause(err) == skippedError {
+			sim.skipTransition()
+			return false
+		}
+		if err != nil {
+			zapctx.Error(ctx, "error performing call", zaputil.Error(err))
+			attributes["error"] = errors.Details(err)
+			if state := failureState(*transition, err); state != "" {
+				nextStateName = state
+				failure = true
+			}
+		}
+	}
+	nextStateConfig, ok := sim.States[nextStateName]
+	if !ok {
+		sim.error(errors.NotFoundf("state %q", nextStateName))
+		return false
+	}
+
+	sim.Observer.Transition(s.entity.name, s.name, nextStateName, failure)
+	s.entity.transitions++
+
+	finalState := &State{
+		State:      nextStateConfig,
+		Attributes: copyAttributes(attributes),
+		entity:     s.entity,
+		name:       nextStateName,
+		final:      true,
+		rnd:        s.rnd,
+	}
+	sim.add()
+	go func() {
+		defer sim.done()
+		finalState.run(ctx, sim)
+	}()
+	return true
+}
+
+// chooseTransition randomly chooses one of the transitions whose guard
+// is true with probability proportional to its weight. It returns false
+// if no transition is eligible. Transitions whose weight cannot be
+// evaluated or is negative are not eligible.
+func (s *State) chooseTransition(ctx context.Context, sim *Simulation) (config.Transition, bool) {
+	eligible := make([]config.Transition, 0, len(s.Transitions))
+	weights := make([]float64, 0, len(s.Transitions))
 	// calculate the sum of transition weigths
 	sum := 0.0
 	for _, transition := range s.Transitions {
-		if transition.Probability < 0 {
-			sim.error(errors.Errorf("negative transition probability %v", transition.Probability))
-			return
+		if !s.guard(ctx, sim, transition) {
+			continue
+		}
+		weight, err := s.weight(sim, transition)
+		if err == nil && weight < 0 {
+			err = errors.Errorf("negative transition weight %v", weight)
+		}
+		if err != nil {
+			zapctx.Warn(ctx, "invalid transition weight", zap.String("state", s.name), zap.String("target", transition.State), zaputil.Error(err))
+			continue
 		}
-		sum += transition.Probability
+		eligible = append(eligible, transition)
+		weights = append(weights, weight)
+		sum += weight
 	}
 	if sum == 0 {
-		sim.error(errors.Errorf("sum of transition probabilities is 0"))
-		return
+		return config.Transition{}, false
 	}
 	// create a random number [0 .. sum]
-	randomNumber := sum * rand.Float64()
-	for _, transition := range s.Transitions {
+	randomNumber := sum * s.rnd.Float64()
+	for i, transition := range eligible {
 		// subtract the transition weigth
-		randomNumber -= transition.Probability
+		randomNumber -= weights[i]
 		// if we reached 0 (or less) we choose this transition
 		if randomNumber <= 0 {
-			nextStateName := transition.State
-			attributes := s.Attributes
-
-			if !isEmptyCall(transition.Call) {
-				attributes, err = sim.Do(ctx, transition.Call, s.Attributes)
-				if err != nil {
-					zapctx.Error(ctx, "error performing call", zaputil.Error(err))
-					attributes["error"] = errors.Details(err)
-					if transition.OnFailure != "" {
-						nextStateName = transition.OnFailure
-					}
-				}
-			}
-			nextStateConfig, ok := sim.States[nextStateName]
-			if !ok {
-				sim.error(errors.NotFoundf("state %q", nextStateName))
-				return
+			return transition, true
+		}
+	}
+	// guard against rounding errors by choosing the last transition
+	// with a non-zero weight
+	for i := len(eligible) - 1; i >= 0; i-- {
+		if weights[i] > 0 {
+			return eligible[i], true
+		}
+	}
+	return config.Transition{}, false
+}
+
+// weight returns the weight of the transition. Weights that are
+// expressions are evaluated against the state's attributes.
+func (s *State) weight(sim *Simulation, transition config.Transition) (float64, error) {
+	if w, ok := transition.Probability.Value(); ok {
+		return w, nil
+	}
+	e, err := sim.exprs.parse(string(transition.Probability))
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	w, err := e.Float(sim.env(s.Attributes))
+	if err != nil {
+		return 0, errors.Annotatef(err, "cannot evaluate weight of transition to %q", transition.State)
+	}
+	return w, nil
+}
+
+// guard returns true if the guard of the transition is true. Guards
+// that cannot be evaluated, e.g. because an attribute set from a call
+// result has an unexpected type, are false.
+func (s *State) guard(ctx context.Context, sim *Simulation, transition config.Transition) bool {
+	if transition.When == "" {
+		return true
+	}
+	e, err := sim.exprs.parse(transition.When)
+	if err == nil {
+		var ok bool
+		ok, err = e.Bool(sim.env(s.Attributes))
+		if err == nil {
+			return ok
+		}
+	}
+	zapctx.Warn(ctx, "cannot evaluate guard", zap.String("state", s.name), zap.String("target", transition.State), zaputil.Error(err))
+	return false
+}
+
+// failureState returns the state to which the transition leads if its
+// calls fail with the error, or an empty string if the transition leads
+// to its target state regardless.
+func failureState(transition config.Transition, err error) string {
+	if transition.OnTimeout != "" && call.ErrorCause(err) == call.CauseTimeout {
+		return transition.OnTimeout
+	}
+	return transition.OnFailure
+}
+
+// performCalls performs the calls of the transition in order, each
+// with the attributes resulting from the previous calls. Unless the
+// transition continues on failure, the remaining calls are not
+// performed once a call fails. It returns the resulting attributes
+// and the first error. If a call is skipped, the whole transition
+// is skipped: the progress records the calls that were performed so
+// that they are not performed again when the transition is resumed.
+func (s *State) performCalls(ctx context.Context, sim *Simulation, transition config.Transition, calls []config.Call, progress *callProgress) (call.Attributes, error) {
+	attributes := s.Attributes
+	var firstErr error
+	for i, c := range calls {
+		var err error
+		attributes, err = s.performCall(ctx, sim, transition.State, c, attributes, progress, strconv.Itoa(i))
+		switch errors.Cause(err) {
+		case nil:
+			continue
+		case contextDoneError, skippedError:
+			return attributes, err
+		}
+		if firstErr == nil {
+			firstErr = err
+		} else {
+			// only the first error is returned
+			zapctx.Error(ctx, "error performing call", zaputil.Error(err))
+		}
+		if !transition.ContinueOnFailure {
+			break
+		}
+	}
+	return attributes, firstErr
+}
+
+// performCall performs the call or, if the call specifies parallel
+// calls, performs them concurrently and merges their results into
+// the attributes in order. The path identifies the call within the
+// transition. Calls recorded by the progress are not performed again.
+func (s *State) performCall(ctx context.Context, sim *Simulation, target string, c config.Call, attributes call.Attributes, progress *callProgress, path string) (call.Attributes, error) {
+	if result, ok := progress.result(path); ok {
+		return copyAttributes(result.attributes), result.err
+	}
+	var err error
+	if len(c.Parallel) == 0 {
+		attributes, err = sim.call(ctx, s, target, c, attributes)
+	} else {
+		attributes, err = s.performParallelCalls(ctx, sim, target, c, attributes, progress, path)
+	}
+	switch errors.Cause(err) {
+	case contextDoneError, skippedError:
+	default:
+		progress.record(path, copyAttributes(attributes), err)
+	}
+	return attributes, err
+}
+
+// performParallelCalls performs the parallel calls of the call
+// concurrently and merges their results into the attributes in order.
+func (s *State) performParallelCalls(ctx context.Context, sim *Simulation, target string, c config.Call, attributes call.Attributes, progress *callProgress, path string) (call.Attributes, error) {
+	results := make([]call.Attributes, len(c.Parallel))
+	errs := make([]error, len(c.Parallel))
+	// the last parallel call to complete hands over to this
+	// goroutine, which does not run while waiting, so that the
+	// clock does not advance in between.
+	remaining := int32(len(c.Parallel))
+	var wg sync.WaitGroup
+	for i, pc := range c.Parallel {
+		// each parallel call has its own source of randomness
+		ps := *s
+		ps.rnd = newRand(s.rnd)
+		sim.Clock.Started()
+		wg.Add(1)
+		go func(i int, pc config.Call, ps *State) {
+			defer wg.Done()
+			results[i], errs[i] = ps.performCall(ctx, sim, target, pc, copyAttributes(attributes), progress, path+"."+strconv.Itoa(i))
+			if atomic.AddInt32(&remaining, -1) > 0 {
+				sim.Clock.Stopped()
 			}
+		}(i, pc, &ps)
+	}
+	sim.Clock.Stopped()
+	wg.Wait()
 
-			nextState := &State{
-				State:      nextStateConfig,
-				Attributes: copyAttributes(attributes),
+	merged := copyAttributes(attributes)
+	for i, pc := range c.Parallel {
+		if errs[i] != nil {
+			return merged, errs[i]
+		}
+		for _, name := range resultAttributes(pc) {
+			if value, ok := results[i][name]; ok {
+				merged[name] = value
 			}
-			sim.add()
-			go func() {
-				defer sim.done()
-				nextState.run(ctx, sim)
-			}()
 		}
 	}
+	return merged, nil
+}
+
+// callProgress records the results of the calls of a transition.
+type callProgress struct {
+	mu      sync.Mutex
+	results map[string]callResult
+}
+
+// callResult holds the result of a call.
+type callResult struct {
+	attributes call.Attributes
+	err        error
+}
+
+func newCallProgress() *callProgress {
+	return &callProgress{
+		results: make(map[string]callResult),
+	}
 }
 
-func isEmptyCall(call config.Call) bool {
-	return call.Method == "" && call.URL == "" && len(call.Parameters) == 0 && len(call.Results) == 0
+// result returns the recorded result of the call at the path, if any.
+func (p *callProgress) result(path string) (callResult, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	r, ok := p.results[path]
+	return r, ok
+}
+
+// record records the result of the call at the path.
+func (p *callProgress) record(path string, attributes call.Attributes, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.results[path] = callResult{
+		attributes: attributes,
+		err:        err,
+	}
+}
+
+// resultAttributes returns the names of attributes set by the call.
+func resultAttributes(c config.Call) []string {
+	var names []string
+	for _, r := range c.Results {
+		names = append(names, r.Attribute)
+	}
+	for _, pc := range c.Parallel {
+		names = append(names, resultAttributes(pc)...)
+	}
+	return names
 }
 
 type AttributeDistribution struct {
 	config.Attribute
 }
 
-// Sample return a sample float64 from the attribute distribution
-func (a *AttributeDistribution) Sample() (interface{}, error) {
+// Sample returns a sample from the attribute distribution using the
+// provided source of randomness.
+func (a *AttributeDistribution) Sample(rnd *rand.Rand) (interface{}, error) {
 	switch a.Type {
 	case config.ConstantIntAttributeType:
 		return a.Value, nil
 	case config.RandomIntAttributeType:
-		return int(math.Floor(a.Min + (a.Max-a.Min)*rand.Float64())), nil
+		return int(math.Floor(a.Min + (a.Max-a.Min)*rnd.Float64())), nil
 	case config.PowerIntAttributeType:
-		v := rand.Float64()
+		v := rnd.Float64()
 		nn := a.N + 1
 		sample := math.Pow((math.Pow(a.Max, nn)-math.Pow(a.Min, nn))*v+math.Pow(a.Min, nn), (1 / nn))
 		return int(math.Floor(sample)), nil
 	case config.NormalIntAttributeType:
-		return int(math.Floor(math.Abs(rand.NormFloat64()*a.StdDev + a.N))), nil
+		return int(math.Floor(math.Abs(rnd.NormFloat64()*a.StdDev + a.N))), nil
 	case config.RandomFloatAttributeType:
-		return a.Min + (a.Max-a.Min)*rand.Float64(), nil
+		return a.Min + (a.Max-a.Min)*rnd.Float64(), nil
 	case config.PowerFloatAttributeType:
-		v := rand.Float64()
+		v := rnd.Float64()
 		nn := a.N + 1
 		return math.Pow((math.Pow(a.Max, nn)-math.Pow(a.Min, nn))*v+math.Pow(a.Min, nn), (1 / nn)), nil
 	case config.NormalFloatAttributeType:
-		return math.Abs(rand.NormFloat64()*a.StdDev + a.N), nil
+		return math.Abs(rnd.NormFloat64()*a.StdDev + a.N), nil
 	case config.ConstantStringAttributeType:
 		return a.StringValue, nil
 	case config.RandomStringAttributeType:
-		uuid, err := utils.NewUUID()
-		if err != nil {
-			return nil, errors.Trace(err)
-		}
+		uuid := newUUID(rnd)
 		if a.StringValue == "" {
 			return uuid.String(), nil
 		}
 		if a.Min != 0 || a.Max != 0 {
-			return fmt.Sprintf("%s%d", a.StringValue, int(math.Floor(a.Min+(a.Max-a.Min)*rand.Float64()))), nil
+			return fmt.Sprintf("%s%d", a.StringValue, int(math.Floor(a.Min+(a.Max-a.Min)*rnd.Float64()))), nil
 		}
 		return fmt.Sprintf("%s%v", a.StringValue, uuid), nil
 	case config.RandomValueAttributeType:
 		if len(a.Values) == 0 {
 			return nil, errors.New("empty list of values")
 		}
-		return a.Values[rand.Intn(len(a.Values))], nil
+		return a.Values[rnd.Intn(len(a.Values))], nil
 	case config.RandomSubsetAttributeType:
 		if len(a.Values) == 0 {
 			return nil, errors.New("empty list of values")
 		}
 		values := make(map[int]bool)
-		for i := 0; i < rand.Intn(len(a.Values)); i++ {
-			values[rand.Intn(len(a.Values))] = true
+		for i := 0; i < rnd.Intn(len(a.Values)); i++ {
+			values[rnd.Intn(len(a.Values))] = true
 		}
+		// values are added to the subset in their original order,
+		// so that the subset does not depend on map iteration order
 		subset := []interface{}{}
-		for k, _ := range values {
-			subset = append(subset, a.Values[k])
+		for k := range a.Values {
+			if values[k] {
+				subset = append(subset, a.Values[k])
+			}
 		}
 		return subset, nil
+	case config.ExprAttributeType:
+		return nil, errors.New("computed attributes cannot be sampled")
 	}
 
 	return 0, nil
 }
 
-func newTimer(c config.Timer) *timer {
-	return &timer{
-		Timer: c,
-	}
-}
-
-type timer struct {
-	config.Timer
-}
-
-func (t *timer) Next(ctx context.Context) error {
-	var duration time.Duration
-	switch t.Type {
-	case config.FixedTimer:
-		duration = t.Interval
-	case config.RandomTimer:
-		duration = time.Duration(int64(t.Min) + rand.Int63n(int64(t.Max-t.Min)))
-	case "":
-		duration = 0
-	}
-	select {
-	case <-time.After(duration):
-		return nil
-	case <-ctx.Done():
-		return contextDoneError
-	}
-}
-
 type cardinality string
 
 // Value returns the value of the cardinality. It may be a constant integer
-// or name of an attribute.
+// or name of an attribute. Numeric attributes, such as those computed
+// by expressions, must hold an integral value.
 func (c *cardinality) Value(attributes call.Attributes) (int, error) {
 	value, ok := attributes[string(*c)]
 	if !ok {
@@ -381,8 +1258,14 @@ func (c *cardinality) Value(attributes call.Attributes) (int, error) {
 		return value.(int), nil
 	case string:
 		return strconv.Atoi(value.(string))
+	case float64:
+		f := value.(float64)
+		if math.IsInf(f, 0) || f != math.Trunc(f) {
+			return -1, errors.Errorf("cardinality %v is not an integer", f)
+		}
+		return int(f), nil
 	default:
-		return -1, errors.Errorf("unknown type: expected int or string, got %T", value)
+		return -1, errors.Errorf("unknown type: expected int, float64 or string, got %T", value)
 	}
 }
 
@@ -393,3 +1276,20 @@ func copyAttributes(a call.Attributes) call.Attributes {
 	}
 	return attributes
 }
+
+// newRand returns a new source of randomness seeded from r, so that
+// each goroutine uses its own source and the sequence of random
+// numbers it observes does not depend on goroutine scheduling.
+func newRand(r *rand.Rand) *rand.Rand {
+	return rand.New(rand.NewSource(r.Int63()))
+}
+
+// newUUID returns a random (version 4) UUID generated using the
+// provided source of randomness.
+func newUUID(r *rand.Rand) utils.UUID {
+	var uuid utils.UUID
+	r.Read(uuid[:])
+	uuid[6] = (uuid[6] & 0x0f) | 0x40
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+	return uuid
+}
@@ -4,7 +4,11 @@ package simulation_test
 
 import (
 	"context"
+	"fmt"
+	"sort"
+	"sync"
 	"testing"
+	"time"
 
 	qt "github.com/frankban/quicktest"
 	yaml "gopkg.in/yaml.v1"
@@ -47,6 +51,78 @@ state:
           attribute: message
   hello-body:
 `
+
+	loopingSim = `
+constants:
+  number-of-users: 2
+root-entities:
+- entity: user
+  cardinality: number-of-users
+entities:
+  user:
+    initial_state: ping
+state:
+  ping:
+    timer:
+      type: fixed
+      interval: 1ms
+    transitions:
+    - state: ping
+      probability: 1
+      call:
+        method: GET
+        url: http://test.com/ping
+`
+
+	randomSim = `
+root-entities:
+- entity: user
+  cardinality: "4"
+  timer:
+    type: random
+    min: 1ms
+    max: 2ms
+entities:
+  user:
+    initial_state: browse
+    attributes:
+      username:
+        type: random_string
+      group:
+        type: random_value
+        values: [a, b, c]
+state:
+  browse:
+    attributes:
+      delay:
+        type: random_int
+        min: 0
+        max: 1000
+    timer:
+      type: random
+      min: 1ms
+      max: 2ms
+    transitions:
+    - state: checkout
+      probability: 1
+      call:
+        url: http://test.com/browse
+    - state: checkout
+      probability: 1
+      call:
+        url: http://test.com/search
+  checkout:
+    transitions:
+    - state: done
+      probability: 2
+      call:
+        url: http://test.com/buy
+    - state: done
+      probability: 1
+      call:
+        url: http://test.com/cancel
+  done:
+`
 )
 
 func TestSimulation(t *testing.T) {
@@ -59,7 +135,11 @@ func TestSimulation(t *testing.T) {
 	err := yaml.Unmarshal([]byte(simpleSim), &simConfig)
 	c.Assert(err, qt.IsNil)
 
-	_, err = simulation.New(simConfig, callBackend)
+	sim, err := simulation.New(simConfig, callBackend)
+	c.Assert(err, qt.IsNil)
+	err = sim.Start(context.Background())
+	c.Assert(err, qt.IsNil)
+	err = sim.Wait()
 	c.Assert(err, qt.IsNil)
 
 	c.Assert(callBackend.calls, qt.DeepEquals, []config.Call{{
@@ -77,14 +157,204 @@ func TestSimulation(t *testing.T) {
 	}})
 }
 
+func TestSimulationInvalidConfig(t *testing.T) {
+	c := qt.New(t)
+	callBackend := &testCallBackend{
+		responseAttributes: make(map[string]call.Attributes),
+	}
+
+	var simConfig config.Config
+	err := yaml.Unmarshal([]byte(simpleSim), &simConfig)
+	c.Assert(err, qt.IsNil)
+	simConfig.RootEntities = append(simConfig.RootEntities, config.EntitySet{
+		Entity: "admin",
+	})
+
+	_, err = simulation.New(simConfig, callBackend)
+	c.Assert(err, qt.ErrorMatches, `(?s)1 configuration problem\(s\):\nroot-entities\[1\].entity: unknown entity "admin"`)
+	c.Assert(callBackend.calls, qt.HasLen, 0)
+}
+
+func TestSimulationLimits(t *testing.T) {
+	c := qt.New(t)
+
+	tests := []struct {
+		about  string
+		limits config.Limits
+		check  func(c *qt.C, stats simulation.Stats)
+	}{{
+		about: "max transitions",
+		limits: config.Limits{
+			MaxTransitions: 10,
+		},
+		check: func(c *qt.C, stats simulation.Stats) {
+			c.Assert(stats.Transitions, qt.Equals, int64(10))
+		},
+	}, {
+		about: "max calls",
+		limits: config.Limits{
+			MaxCalls: 5,
+		},
+		check: func(c *qt.C, stats simulation.Stats) {
+			c.Assert(stats.Calls, qt.Equals, int64(5))
+		},
+	}, {
+		about: "duration",
+		limits: config.Limits{
+			Duration: 50 * time.Millisecond,
+		},
+		check: func(c *qt.C, stats simulation.Stats) {
+			c.Assert(stats.Duration >= 50*time.Millisecond, qt.Equals, true)
+			c.Assert(stats.Calls > 0, qt.Equals, true)
+		},
+	}}
+
+	for i, test := range tests {
+		c.Logf("running test %d: %s", i, test.about)
+		callBackend := &testCallBackend{}
+
+		var simConfig config.Config
+		err := yaml.Unmarshal([]byte(loopingSim), &simConfig)
+		c.Assert(err, qt.IsNil)
+		simConfig.Limits = test.limits
+
+		sim, err := simulation.New(simConfig, callBackend)
+		c.Assert(err, qt.IsNil)
+		err = sim.Start(context.Background())
+		c.Assert(err, qt.IsNil)
+		err = sim.Wait()
+		c.Assert(err, qt.IsNil)
+		stats := sim.Stats()
+		c.Assert(stats.Entities, qt.Equals, int64(2))
+		test.check(c, stats)
+	}
+}
+
+func TestSimulationCancel(t *testing.T) {
+	c := qt.New(t)
+	callBackend := &testCallBackend{}
+
+	var simConfig config.Config
+	err := yaml.Unmarshal([]byte(loopingSim), &simConfig)
+	c.Assert(err, qt.IsNil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	sim, err := simulation.New(simConfig, callBackend)
+	c.Assert(err, qt.IsNil)
+	err = sim.Start(ctx)
+	c.Assert(err, qt.IsNil)
+	err = sim.Wait()
+	c.Assert(err, qt.IsNil)
+	c.Assert(sim.Stats().Calls > 0, qt.Equals, true)
+}
+
+func TestSimulationLifecycle(t *testing.T) {
+	c := qt.New(t)
+	callBackend := &testCallBackend{}
+
+	var simConfig config.Config
+	err := yaml.Unmarshal([]byte(loopingSim), &simConfig)
+	c.Assert(err, qt.IsNil)
+
+	sim, err := simulation.New(simConfig, callBackend)
+	c.Assert(err, qt.IsNil)
+	c.Assert(sim.Wait(), qt.ErrorMatches, "simulation not started")
+
+	err = sim.Start(context.Background())
+	c.Assert(err, qt.IsNil)
+	err = sim.Start(context.Background())
+	c.Assert(err, qt.ErrorMatches, "simulation already started")
+
+	time.Sleep(20 * time.Millisecond)
+	c.Assert(sim.Err(), qt.IsNil)
+	sim.Stop()
+	err = sim.Wait()
+	c.Assert(err, qt.IsNil)
+	c.Assert(sim.Stats().Calls > 0, qt.Equals, true)
+}
+
+func TestSimulationError(t *testing.T) {
+	c := qt.New(t)
+	callBackend := &testCallBackend{}
+
+	var simConfig config.Config
+	err := yaml.Unmarshal([]byte(loopingSim), &simConfig)
+	c.Assert(err, qt.IsNil)
+	simConfig.RootEntities[0].Cardinality = "not-a-number"
+	simConfig.Constants["not-a-number"] = "many"
+
+	sim, err := simulation.New(simConfig, callBackend)
+	c.Assert(err, qt.ErrorMatches, `(?s).*constant "not-a-number" is not an integer`)
+
+	simConfig.Constants["not-a-number"] = 1.5
+	simConfig.RootEntities[0].Cardinality = "users"
+	simConfig.Entities["user"] = config.Entity{
+		InitialState: "ping",
+		Subordinates: []config.EntitySet{{
+			Entity:      "user",
+			Cardinality: "not-a-number",
+		}},
+	}
+	simConfig.Limits.MaxTransitions = 100
+	simConfig.Constants["users"] = 1
+	sim, err = simulation.New(simConfig, callBackend)
+	c.Assert(err, qt.IsNil)
+	err = sim.Start(context.Background())
+	c.Assert(err, qt.IsNil)
+	err = sim.Wait()
+	c.Assert(err, qt.ErrorMatches, `cardinality 1.5 is not an integer`)
+	c.Assert(sim.Err(), qt.Equals, err)
+}
+
+func TestSimulationSeed(t *testing.T) {
+	c := qt.New(t)
+
+	simulate := func(seed int64) []string {
+		var simConfig config.Config
+		err := yaml.Unmarshal([]byte(randomSim), &simConfig)
+		c.Assert(err, qt.IsNil)
+		simConfig.Seed = seed
+
+		callBackend := &testCallBackend{}
+		sim, err := simulation.New(simConfig, callBackend)
+		c.Assert(err, qt.IsNil)
+		c.Assert(sim.Seed, qt.Equals, seed)
+		err = sim.Start(context.Background())
+		c.Assert(err, qt.IsNil)
+		err = sim.Wait()
+		c.Assert(err, qt.IsNil)
+
+		// calls of different entities are interleaved depending on
+		// goroutine scheduling, so we compare sorted calls
+		var calls []string
+		for i, callConfig := range callBackend.calls {
+			a := callBackend.attributes[i]
+			calls = append(calls, fmt.Sprintf("%s %v %v %v", callConfig.URL, a["username"], a["group"], a["delay"]))
+		}
+		sort.Strings(calls)
+		return calls
+	}
+
+	calls := simulate(42)
+	c.Assert(calls, qt.HasLen, 8)
+	c.Assert(simulate(42), qt.DeepEquals, calls)
+	c.Assert(simulate(43), qt.Not(qt.DeepEquals), calls)
+}
+
 type testCallBackend struct {
+	mu                 sync.Mutex
 	responseAttributes map[string]call.Attributes
 	responseError      error
 	calls              []config.Call
+	attributes         []call.Attributes
 }
 
 func (b *testCallBackend) Do(ctx context.Context, callConfig config.Call, attributes call.Attributes) (call.Attributes, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
 	b.calls = append(b.calls, callConfig)
+	b.attributes = append(b.attributes, attributes)
 	if b.responseError != nil {
 		return attributes, b.responseError
 	}
@@ -97,3 +367,60 @@ func (b *testCallBackend) Do(ctx context.Context, callConfig config.Call, attrib
 	}
 	return attrs, nil
 }
+
+// backendObserver records the backends of calls.
+type backendObserver struct {
+	simulation.NopObserver
+
+	mu       sync.Mutex
+	backends map[config.CallBackend]int
+}
+
+func (o *backendObserver) Call(info simulation.CallInfo) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.backends[info.Backend]++
+}
+
+func TestCallInfoBackend(t *testing.T) {
+	c := qt.New(t)
+
+	tests := []struct {
+		about           string
+		callBackend     simulation.CallBackend
+		expectedBackend config.CallBackend
+	}{{
+		about:           "named backend",
+		callBackend:     call.NewNOPCallBackend(),
+		expectedBackend: config.NOPCallBackend,
+	}, {
+		about: "unnamed backend",
+		callBackend: &testCallBackend{
+			responseAttributes: make(map[string]call.Attributes),
+		},
+		expectedBackend: "unknown",
+	}}
+	for i, test := range tests {
+		c.Logf("running test %d: %s", i, test.about)
+
+		var simConfig config.Config
+		err := yaml.Unmarshal([]byte(simpleSim), &simConfig)
+		c.Assert(err, qt.IsNil)
+		// the configured backend does not matter
+		simConfig.Backend = config.HTTPCallBackend
+
+		sim, err := simulation.New(simConfig, test.callBackend)
+		c.Assert(err, qt.IsNil)
+		observer := &backendObserver{
+			backends: make(map[config.CallBackend]int),
+		}
+		sim.Observer = observer
+		err = sim.Start(context.Background())
+		c.Assert(err, qt.IsNil)
+		err = sim.Wait()
+		c.Assert(err, qt.IsNil)
+
+		c.Assert(observer.backends, qt.HasLen, 1)
+		c.Assert(observer.backends[test.expectedBackend] > 0, qt.Equals, true)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2019 CanonicalLtd
+
+package simulation_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	yaml "gopkg.in/yaml.v1"
+
+	"github.com/cloud-green/sisyphus/config"
+	"github.com/cloud-green/sisyphus/simulation"
+	"github.com/cloud-green/sisyphus/simulation/call"
+)
+
+var timeoutSim = `
+root-entities:
+- entity: user
+entities:
+  user:
+    initial_state: home
+state:
+  done:
+  failed:
+  timedout:
+`
+
+// hangingCallBackend blocks calls to /hang until their context is done.
+type hangingCallBackend struct{}
+
+func (hangingCallBackend) Do(ctx context.Context, callConfig config.Call, attributes call.Attributes) (call.Attributes, error) {
+	if callConfig.URL == "/hang" {
+		<-ctx.Done()
+		return attributes, ctx.Err()
+	}
+	return attributes, nil
+}
+
+func TestCallTimeout(t *testing.T) {
+	c := qt.New(t)
+
+	tests := []struct {
+		about              string
+		callTimeout        time.Duration
+		transition         string
+		expectedTransition string
+	}{{
+		about:       "calls time out after the default timeout",
+		callTimeout: 10 * time.Millisecond,
+		transition: `
+state: done
+probability: 1
+on-failure: failed
+on-timeout: timedout
+call:
+  url: /hang
+`,
+		expectedTransition: "timedout",
+	}, {
+		about: "calls time out after their timeout",
+		transition: `
+state: done
+probability: 1
+on-failure: failed
+on-timeout: timedout
+call:
+  url: /hang
+  timeout: 10ms
+`,
+		expectedTransition: "timedout",
+	}, {
+		about:       "timed out calls fail",
+		callTimeout: time.Hour,
+		transition: `
+state: done
+probability: 1
+on-failure: failed
+call:
+  url: /hang
+  timeout: 10ms
+`,
+		expectedTransition: "failed",
+	}, {
+		about:       "calls completing in time",
+		callTimeout: 10 * time.Millisecond,
+		transition: `
+state: done
+probability: 1
+on-failure: failed
+on-timeout: timedout
+call:
+  url: /ok
+`,
+		expectedTransition: "done",
+	}}
+
+	for i, test := range tests {
+		c.Logf("running test %d: %s", i, test.about)
+
+		var simConfig config.Config
+		err := yaml.Unmarshal([]byte(timeoutSim), &simConfig)
+		c.Assert(err, qt.IsNil)
+		simConfig.CallTimeout = test.callTimeout
+		var transition config.Transition
+		err = yaml.Unmarshal([]byte(test.transition), &transition)
+		c.Assert(err, qt.IsNil)
+		simConfig.States["home"] = config.State{
+			Transitions: []config.Transition{transition},
+		}
+
+		sim, err := simulation.New(simConfig, hangingCallBackend{})
+		c.Assert(err, qt.IsNil)
+		observer := &transitionObserver{}
+		sim.Observer = observer
+		err = sim.Start(context.Background())
+		c.Assert(err, qt.IsNil)
+		err = sim.Wait()
+		c.Assert(err, qt.IsNil)
+
+		c.Assert(observer.targets, qt.DeepEquals, []string{test.expectedTransition})
+	}
+}
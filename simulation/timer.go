@@ -0,0 +1,240 @@
+// Copyright 2019 CanonicalLtd
+
+package simulation
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+
+	"github.com/cloud-green/sisyphus/config"
+)
+
+const day = 24 * time.Hour
+
+func newTimer(c config.Timer, rnd *rand.Rand, sim *Simulation) *timer {
+	return &timer{
+		Timer: c,
+		rnd:   rnd,
+		clock: sim.Clock,
+		trace: sim.traces[c.File],
+	}
+}
+
+type timer struct {
+	config.Timer
+	rnd   *rand.Rand
+	clock Clock
+	// trace holds the intervals replayed by trace timers.
+	trace *trace
+	// next holds the index of the next interval of the trace
+	// replayed by the timer.
+	next int
+}
+
+// Next waits for the timer to fire.
+func (t *timer) Next(ctx context.Context) error {
+	if err := t.clock.Sleep(ctx, t.duration(t.clock.Now())); err != nil {
+		return contextDoneError
+	}
+	return nil
+}
+
+// duration returns the time until the timer fires next, if it is
+// started at the specified time.
+func (t *timer) duration(now time.Time) time.Duration {
+	switch t.Type {
+	case config.FixedTimer:
+		return t.Interval
+	case config.RandomTimer:
+		return time.Duration(int64(t.Min) + t.rnd.Int63n(int64(t.Max-t.Min)))
+	case config.ExponentialTimer:
+		return seconds(t.rnd.ExpFloat64() / t.Rate)
+	case config.LogNormalTimer:
+		return seconds(t.Median.Seconds() * math.Exp(t.Sigma*t.rnd.NormFloat64()))
+	case config.ParetoTimer:
+		// 1-Float64() is in (0, 1], which avoids dividing by 0
+		d := seconds(t.Min.Seconds() / math.Pow(1-t.rnd.Float64(), 1/t.Alpha))
+		if t.Max > 0 && d > t.Max {
+			d = t.Max
+		}
+		return d
+	case config.DailyTimer:
+		return t.daily(now)
+	case config.TraceTimer:
+		if t.trace != nil {
+			d := t.trace.intervals[t.next]
+			t.next = (t.next + 1) % len(t.trace.intervals)
+			return d
+		}
+	}
+	return 0
+}
+
+// daily returns the time until the next event of a Poisson process
+// whose rate varies over the day as specified by the Rates.
+func (t *timer) daily(now time.Time) time.Duration {
+	period := day / time.Duration(len(t.Rates))
+	h, m, s := now.Clock()
+	offset := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second + time.Duration(now.Nanosecond())
+	// the number of events in an interval is proportional to the
+	// integral of the rate over the interval, so we consume an
+	// exponentially distributed amount of the integral.
+	remaining := t.rnd.ExpFloat64()
+	var d time.Duration
+	for {
+		i := int(offset / period)
+		if i >= len(t.Rates) {
+			// guard against rounding of the period
+			i = len(t.Rates) - 1
+		}
+		end := time.Duration(i+1) * period
+		if i == len(t.Rates)-1 {
+			end = day
+		}
+		rate := t.Rates[i]
+		if rate > 0 {
+			events := rate * (end - offset).Seconds()
+			if events >= remaining {
+				return d + seconds(remaining/rate)
+			}
+			remaining -= events
+		}
+		d += end - offset
+		offset = end % day
+	}
+}
+
+// seconds returns the duration of the specified number of seconds,
+// saturating at the maximum duration.
+func seconds(s float64) time.Duration {
+	if s >= float64(math.MaxInt64)/float64(time.Second) {
+		return math.MaxInt64
+	}
+	return time.Duration(s * float64(time.Second))
+}
+
+// trace holds intervals replayed by trace timers. Each timer replays
+// the intervals from the first, restarting once all intervals have
+// been replayed, so that timers do not depend on each other.
+type trace struct {
+	intervals []time.Duration
+}
+
+// loadTraces loads the traces replayed by the timers of the
+// configuration, indexed by file name.
+func loadTraces(c config.Config) (map[string]*trace, error) {
+	traces := make(map[string]*trace)
+	load := func(t config.Timer) error {
+		if t.Type != config.TraceTimer {
+			return nil
+		}
+		if _, ok := traces[t.File]; ok {
+			return nil
+		}
+		trace, err := loadTrace(t.File)
+		if err != nil {
+			return errors.Annotatef(err, "cannot load trace %q", t.File)
+		}
+		traces[t.File] = trace
+		return nil
+	}
+	for _, es := range c.RootEntities {
+		if err := load(es.Timer); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	for _, e := range c.Entities {
+		if err := load(e.Lifetime); err != nil {
+			return nil, errors.Trace(err)
+		}
+		for _, es := range e.Subordinates {
+			if err := load(es.Timer); err != nil {
+				return nil, errors.Trace(err)
+			}
+		}
+	}
+	for _, s := range c.States {
+		if err := load(s.Timer); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	return traces, nil
+}
+
+// loadTrace reads intervals from the first column of the named CSV
+// file. Intervals are either durations or numbers of seconds. A first
+// row that does not hold an interval is assumed to be a header.
+func loadTrace(filename string) (*trace, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.Comment = '#'
+	var t trace
+	for row := 1; ; row++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		d, err := parseInterval(record[0])
+		if errors.IsNotValid(err) && row == 1 {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Annotatef(err, "row %d", row)
+		}
+		t.intervals = append(t.intervals, d)
+	}
+	if len(t.intervals) == 0 {
+		return nil, errors.New("no intervals")
+	}
+	var total time.Duration
+	for _, d := range t.intervals {
+		total += d
+	}
+	if total == 0 {
+		// the timers would fire continuously
+		return nil, errors.New("all intervals are zero")
+	}
+	return &t, nil
+}
+
+// parseInterval parses a duration or a number of seconds. It returns
+// a NotValid error if s holds neither.
+func parseInterval(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		f, ferr := strconv.ParseFloat(s, 64)
+		if ferr != nil {
+			return 0, errors.NewNotValid(nil, fmt.Sprintf("invalid interval %q", s))
+		}
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return 0, errors.Errorf("non-finite interval %q", s)
+		}
+		if f < 0 {
+			return 0, errors.Errorf("negative interval %q", s)
+		}
+		d = seconds(f)
+	}
+	if d < 0 {
+		return 0, errors.Errorf("negative interval %q", s)
+	}
+	return d, nil
+}
@@ -0,0 +1,239 @@
+// Copyright 2019 CanonicalLtd
+
+package simulation_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/cloud-green/sisyphus/config"
+	"github.com/cloud-green/sisyphus/simulation"
+)
+
+// mean returns the mean of the durations.
+func mean(durations []time.Duration) time.Duration {
+	var sum time.Duration
+	for _, d := range durations {
+		sum += d
+	}
+	return sum / time.Duration(len(durations))
+}
+
+// median returns the median of the durations.
+func median(durations []time.Duration) time.Duration {
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[len(sorted)/2]
+}
+
+// assertApprox asserts that the duration is within 5% of the
+// expected duration.
+func assertApprox(c *qt.C, d, expected time.Duration) {
+	c.Assert(d > expected*95/100 && d < expected*105/100, qt.Equals, true, qt.Commentf("got %v, expected %v", d, expected))
+}
+
+func TestTimer(t *testing.T) {
+	c := qt.New(t)
+
+	dir, err := ioutil.TempDir("", "sisyphus")
+	c.Assert(err, qt.IsNil)
+	defer os.RemoveAll(dir)
+	traceFile := filepath.Join(dir, "trace.csv")
+	err = ioutil.WriteFile(traceFile, []byte("interval,comment\n1s,first\n0.5\n# comment\n250ms\n"), 0644)
+	c.Assert(err, qt.IsNil)
+
+	tests := []struct {
+		about string
+		timer config.Timer
+		start time.Time
+		check func(c *qt.C, durations []time.Duration)
+	}{{
+		about: "fixed",
+		timer: config.Timer{
+			Type:     config.FixedTimer,
+			Interval: time.Second,
+		},
+		check: func(c *qt.C, durations []time.Duration) {
+			for _, d := range durations {
+				c.Assert(d, qt.Equals, time.Second)
+			}
+		},
+	}, {
+		about: "random",
+		timer: config.Timer{
+			Type: config.RandomTimer,
+			Min:  time.Second,
+			Max:  2 * time.Second,
+		},
+		check: func(c *qt.C, durations []time.Duration) {
+			for _, d := range durations {
+				c.Assert(d >= time.Second && d < 2*time.Second, qt.Equals, true)
+			}
+			assertApprox(c, mean(durations), 1500*time.Millisecond)
+		},
+	}, {
+		about: "exponential",
+		timer: config.Timer{
+			Type: config.ExponentialTimer,
+			Rate: 10,
+		},
+		check: func(c *qt.C, durations []time.Duration) {
+			assertApprox(c, mean(durations), 100*time.Millisecond)
+		},
+	}, {
+		about: "lognormal",
+		timer: config.Timer{
+			Type:   config.LogNormalTimer,
+			Median: time.Second,
+			Sigma:  0.5,
+		},
+		check: func(c *qt.C, durations []time.Duration) {
+			assertApprox(c, median(durations), time.Second)
+		},
+	}, {
+		about: "pareto",
+		timer: config.Timer{
+			Type:  config.ParetoTimer,
+			Min:   time.Second,
+			Alpha: 3,
+		},
+		check: func(c *qt.C, durations []time.Duration) {
+			for _, d := range durations {
+				c.Assert(d >= time.Second, qt.Equals, true)
+			}
+			// the mean is alpha*min/(alpha-1)
+			assertApprox(c, mean(durations), 1500*time.Millisecond)
+		},
+	}, {
+		about: "truncated pareto",
+		timer: config.Timer{
+			Type:  config.ParetoTimer,
+			Min:   time.Second,
+			Max:   2 * time.Second,
+			Alpha: 1,
+		},
+		check: func(c *qt.C, durations []time.Duration) {
+			for _, d := range durations {
+				c.Assert(d >= time.Second && d <= 2*time.Second, qt.Equals, true)
+			}
+		},
+	}, {
+		about: "daily",
+		timer: config.Timer{
+			Type:  config.DailyTimer,
+			Rates: []float64{0, 0, 1, 0},
+		},
+		start: epoch.Add(3 * time.Hour),
+		check: func(c *qt.C, durations []time.Duration) {
+			// events only occur between 12:00 and 18:00
+			now := epoch.Add(3 * time.Hour)
+			for _, d := range durations {
+				now = now.Add(d)
+				c.Assert(now.Hour() >= 12 && now.Hour() < 18, qt.Equals, true, qt.Commentf("event at %v", now))
+			}
+			c.Assert(durations[0] > 9*time.Hour, qt.Equals, true)
+		},
+	}, {
+		about: "trace",
+		timer: config.Timer{
+			Type: config.TraceTimer,
+			File: traceFile,
+		},
+		check: func(c *qt.C, durations []time.Duration) {
+			expected := []time.Duration{time.Second, 500 * time.Millisecond, 250 * time.Millisecond}
+			for i, d := range durations {
+				c.Assert(d, qt.Equals, expected[i%len(expected)])
+			}
+		},
+	}}
+	for i, test := range tests {
+		c.Logf("running test %d: %s", i, test.about)
+		start := test.start
+		if start.IsZero() {
+			start = epoch
+		}
+		durations, err := simulation.TimerDurations(test.timer, 42, start, 100000)
+		c.Assert(err, qt.IsNil)
+		test.check(c, durations)
+	}
+}
+
+func TestTraceTimerErrors(t *testing.T) {
+	c := qt.New(t)
+
+	dir, err := ioutil.TempDir("", "sisyphus")
+	c.Assert(err, qt.IsNil)
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		about         string
+		data          string
+		expectedError string
+	}{{
+		about:         "empty trace",
+		data:          "interval\n",
+		expectedError: `cannot load trace ".*": no intervals`,
+	}, {
+		about:         "invalid interval",
+		data:          "1s\nsoon\n",
+		expectedError: `cannot load trace ".*": row 2: invalid interval "soon"`,
+	}, {
+		about:         "negative interval",
+		data:          "-1\n",
+		expectedError: `cannot load trace ".*": row 1: negative interval "-1"`,
+	}, {
+		about:         "negative number of seconds",
+		data:          "1s\n-0.5\n",
+		expectedError: `cannot load trace ".*": row 2: negative interval "-0.5"`,
+	}, {
+		about:         "negative duration",
+		data:          "interval\n1s\n-2s\n",
+		expectedError: `cannot load trace ".*": row 3: negative interval "-2s"`,
+	}, {
+		about:         "not a number",
+		data:          "1s\nNaN\n",
+		expectedError: `cannot load trace ".*": row 2: non-finite interval "NaN"`,
+	}, {
+		about:         "infinite interval",
+		data:          "+Inf\n1s\n",
+		expectedError: `cannot load trace ".*": row 1: non-finite interval "\+Inf"`,
+	}, {
+		about:         "zero intervals",
+		data:          "interval\n0\n0s\n",
+		expectedError: `cannot load trace ".*": all intervals are zero`,
+	}}
+	for i, test := range tests {
+		c.Logf("running test %d: %s", i, test.about)
+		filename := filepath.Join(dir, "trace.csv")
+		err := ioutil.WriteFile(filename, []byte(test.data), 0644)
+		c.Assert(err, qt.IsNil)
+		_, err = simulation.TimerDurations(config.Timer{
+			Type: config.TraceTimer,
+			File: filename,
+		}, 0, epoch, 1)
+		c.Assert(err, qt.ErrorMatches, test.expectedError)
+	}
+}
+
+func TestTraceTimersReplayIndependently(t *testing.T) {
+	c := qt.New(t)
+
+	dir, err := ioutil.TempDir("", "sisyphus")
+	c.Assert(err, qt.IsNil)
+	defer os.RemoveAll(dir)
+	traceFile := filepath.Join(dir, "trace.csv")
+	err = ioutil.WriteFile(traceFile, []byte("1s\n2s\n3s\n"), 0644)
+	c.Assert(err, qt.IsNil)
+
+	durations, err := simulation.TraceTimerDurations(traceFile, 3, 4)
+	c.Assert(err, qt.IsNil)
+	for _, d := range durations {
+		c.Assert(d, qt.DeepEquals, []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, time.Second})
+	}
+}